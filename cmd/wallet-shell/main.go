@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/shell"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"os"
+)
+
+func main() {
+	dir := flag.String("data", "data", "directory with dumps")
+	readOnly := flag.Bool("readonly", false, "forbid reject, repeat and save")
+	auditFile := flag.String("audit", "", "hash-chained audit log to record rejects and saves to")
+	auditHead := flag.String("audit-head", "", "file with the head of the -audit log; defaults to <audit>.head")
+	flag.Parse()
+
+	// в оболочке служебные сообщения только мешают, показываем лишь ошибки
+	svc := &wallet.Service{}
+	svc.SetLogger(logger.New(os.Stderr, logger.LevelError))
+	err := svc.Import(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	sh := shell.New(svc, *dir, *readOnly, os.Stdout)
+	if *auditFile != "" {
+		auditLog, err := audit.OpenFile(*auditFile, *auditHead)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sh.SetAuditLog(auditLog)
+	}
+	restore, err := shell.MakeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer restore()
+		sh.SetInput(shell.NewEditor(os.Stdin, os.Stdout, sh.History, sh.Complete))
+	} else {
+		sh.SetInput(shell.NewPlainReader(os.Stdin, os.Stdout))
+	}
+
+	err = sh.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/bahrom656/wallet/pkg/cli"
+	"os"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/auth"
+	"github.com/bahrom656/wallet/pkg/fraud"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/metrics"
+	"github.com/bahrom656/wallet/pkg/outbox"
+	"github.com/bahrom656/wallet/pkg/server"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dir := flag.String("data", "data", "directory with accounts.dump, payments.dump and favorites.dump")
+	timeout := flag.Duration("shutdown-timeout", 10*time.Second, "time to finish in-flight requests on shutdown")
+	events := flag.String("events", "", "event log file; when set, state is rebuilt from it instead of the dumps")
+	outboxFile := flag.String("outbox", "", "file to relay logged events to as JSON lines; requires -events")
+	logLevel := flag.String("log-level", "info", "minimal log level: debug, info, warn or error")
+	migratePhones := flag.Bool("migrate-phones", false, "normalize account phones loaded from old dumps or the event log to E.164")
+	fraudRules := flag.Bool("fraud", false, "check payments with the default fraud rules; flagged payments go to GET /reviews")
+	auditFile := flag.String("audit", "", "hash-chained audit log of privileged actions; verified on start against its head")
+	auditHead := flag.String("audit-head", "", "file with the head of the -audit log, best kept where the log's writers can't change it; defaults to <audit>.head")
+	confirmAbove := flag.Int64("confirm-above", 0, "payments above this amount need PIN or TOTP confirmation; 0 disables")
+	metricCategories := flag.String("metric-categories", "", "comma-separated payment categories kept as metric labels, others are counted as \"other\"; empty keeps the built-in list")
+	saveInterval := flag.Duration("save-interval", 10*time.Second, "how often to save changed state to -data when -events is not set; 0 saves only on shutdown")
+	flag.Parse()
+
+	level, err := logger.ParseLevel(*logLevel)
+	if err != nil {
+		fatal(err)
+	}
+	logger.SetDefault(logger.New(os.Stderr, level))
+
+	var auditLog *audit.Log
+	if *auditFile != "" {
+		auditLog, err = audit.OpenFile(*auditFile, *auditHead)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	var svc *wallet.Service
+	var store *wallet.EventStore
+	if *events != "" {
+		svc, store, err = openEventLog(*events)
+	} else {
+		svc = &wallet.Service{}
+		svc.SetLogger(logger.Default())
+		err = svc.Import(*dir)
+		record(auditLog, "import", *dir, err)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	if *migratePhones {
+		migrated := 0
+		for _, migration := range svc.MigratePhones() {
+			if migration.Err == nil {
+				migrated++
+			}
+		}
+		logger.Default().Info("phones migrated", logger.Int64("accounts", int64(migrated)))
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	if *outboxFile != "" {
+		if store == nil {
+			fatal(errors.New("-outbox requires -events"))
+		}
+		relay, err := openOutbox(*outboxFile, store, *dir)
+		if err != nil {
+			fatal(err)
+		}
+		svc.Events().Subscribe(relay.Handle)
+		go func() {
+			defer close(relayDone)
+			relay.Run(ctx, time.Second)
+		}()
+	} else {
+		close(relayDone)
+	}
+
+	saveDone := make(chan struct{})
+	if store == nil && *saveInterval > 0 {
+		go func() {
+			defer close(saveDone)
+			autosave(ctx, svc, auditLog, *dir, *saveInterval)
+		}()
+	} else {
+		close(saveDone)
+	}
+
+	svc.SetConfirmationThreshold(types.Money(*confirmAbove))
+	if *fraudRules {
+		engine := fraud.NewEngine(fraud.DefaultRules()...)
+		svc.Events().Subscribe(engine.Handle)
+		svc.SetFraudChecker(engine)
+	}
+	if *metricCategories != "" {
+		var categories []types.PaymentCategory
+		for _, category := range strings.Split(*metricCategories, ",") {
+			categories = append(categories, types.PaymentCategory(strings.TrimSpace(category)))
+		}
+		svc.SetMetricCategories(categories)
+	}
+	registry := metrics.NewRegistry()
+	svc.Instrument(registry)
+	api := server.NewServer(svc, *dir)
+	api.SetMetrics(registry)
+	if auditLog != nil {
+		api.SetAuditLog(auditLog)
+	}
+	if a := authFromEnv(); a != nil {
+		api.SetAuth(a)
+	} else {
+		logger.Default().Warn("authentication disabled: set WALLET_ADMIN_KEY or WALLET_TOKEN_SECRET")
+	}
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: api,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Default().Error("shutdown", logger.Err(err))
+		}
+	}()
+
+	logger.Default().Info("listening", logger.String("addr", *addr))
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		fatal(err)
+	}
+	<-done
+	stop()
+	<-relayDone
+	<-saveDone
+
+	// сохраняем состояние только после того, как все запросы завершились
+	err = svc.Export(*dir)
+	record(auditLog, "export", *dir, err)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// autosave выгружает состояние в dir каждые interval, если оно изменилось,
+// чтобы падение процесса теряло не больше изменений, чем накопилось за interval.
+// Неудачная выгрузка повторяется на следующем тике.
+//
+// Каждый файл выгрузки заменяется целиком (см. wallet.DirFS) и не бывает
+// записан наполовину, но файлы заменяются по одному: после падения посреди
+// выгрузки, например, accounts.dump может быть уже новым, а payments.dump —
+// ещё прежним. Согласованное состояние после падения даёт только -events.
+//
+// Каждая выгрузка, и удачная, и нет, пишется в журнал аудита log, если он включён.
+func autosave(ctx context.Context, svc *wallet.Service, log *audit.Log, dir string, interval time.Duration) {
+	saved := svc.Checkpoint()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkpoint := svc.Checkpoint()
+		if checkpoint == saved {
+			continue
+		}
+		err := svc.Export(dir)
+		record(log, "export", dir, err)
+		if err != nil {
+			logger.Default().Error("save state", logger.String("dir", dir), logger.Err(err))
+			continue
+		}
+		saved = checkpoint
+	}
+}
+
+// record пишет в журнал аудита действие самого walletd, если журнал включён.
+func record(log *audit.Log, action string, target string, err error) {
+	if log == nil {
+		return
+	}
+
+	_, err = log.RecordAction("walletd", action, 0, target, "", err)
+	if err != nil {
+		logger.Default().Error("write audit log", logger.String("action", action), logger.Err(err))
+	}
+}
+
+func fatal(err error) {
+	logger.Default().Error("walletd stopped", logger.Err(err))
+	os.Exit(1)
+}
+
+// authFromEnv включает проверку доступа, если задан ключ администратора
+// WALLET_ADMIN_KEY или секрет токенов WALLET_TOKEN_SECRET.
+func authFromEnv() *auth.Authenticator {
+	adminKey := os.Getenv("WALLET_ADMIN_KEY")
+	secret := os.Getenv("WALLET_TOKEN_SECRET")
+	if adminKey == "" && secret == "" {
+		return nil
+	}
+
+	a := auth.NewAuthenticator([]byte(secret))
+	if adminKey != "" {
+		a.AddKey(adminKey, auth.Admin)
+	}
+	return a
+}
+
+// openEventLog восстанавливает сервис из журнала событий path
+// и сохраняет в него события каждой операции до её завершения.
+// Запись, оборванная падением процесса, отрезается.
+func openEventLog(path string) (*wallet.Service, *wallet.EventStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	complete := bytes.LastIndexByte(content, '|') + 1
+	if complete != len(content) {
+		logger.Default().Warn("truncate event log", logger.Int64("bytes", int64(len(content)-complete)))
+		err = file.Truncate(int64(complete))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	store, err := wallet.LoadEventStore(bytes.NewReader(content[:complete]), file)
+	if err != nil {
+		return nil, nil, err
+	}
+	svc, err := store.Rebuild(store.Len())
+	if err != nil {
+		return nil, nil, err
+	}
+	svc.SetLogger(logger.Default())
+	svc.SetJournal(store)
+	return svc, store, nil
+}
+
+// openOutbox создаёт ретранслятор событий журнала в файл path.
+// Курсор ретранслятора хранится в каталоге dir.
+func openOutbox(path string, store *wallet.EventStore, dir string) (*outbox.Relay, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return outbox.NewRelay(store, outbox.NewFilePublisher(file), wallet.DirFS(dir))
+}
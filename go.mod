@@ -2,4 +2,4 @@ module github.com/bahrom656/wallet
 
 go 1.15
 
-require github.com/google/uuid v1.1.2 // indirect
+require github.com/google/uuid v1.1.2
@@ -0,0 +1,382 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrTampered = errors.New("audit log tampered")
+
+// AnchorSuffix — суффикс файла с головой журнала рядом с самим журналом;
+// так голова хранится, если её файл не задан (см. HeadPath).
+const AnchorSuffix = ".head"
+
+// Результаты действий.
+const (
+	ResultOK     = "ok"
+	ResultDenied = "denied"
+	ResultFailed = "failed"
+)
+
+// Entry — запись журнала аудита. Hash — SHA-256 записи вместе с PrevHash,
+// хэшем предыдущей записи, поэтому изменить или удалить запись в середине
+// журнала незаметно нельзя. Удаление записей с конца выявляет Anchor.
+type Entry struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	AccountID int64     `json:"accountId,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Result    string    `json:"result"`
+	Details   string    `json:"details,omitempty"`
+	PrevHash  string    `json:"prevHash,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// sum возвращает хэш записи: SHA-256 её JSON без поля Hash.
+func (e Entry) sum() (string, error) {
+	e.Hash = ""
+	content, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// TamperError описывает первую испорченную запись журнала.
+// errors.Is(err, ErrTampered) для неё верно.
+type TamperError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return ErrTampered.Error() + ": entry " + strconv.FormatInt(e.Seq, 10) + ": " + e.Reason
+}
+
+func (e *TamperError) Is(target error) bool {
+	return target == ErrTampered
+}
+
+// Anchor — номер и хэш последней записи журнала, хранимые отдельно от него.
+// Журнал, обрезанный с конца, с ней не сходится.
+type Anchor struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// HeadPath возвращает файл головы журнала path: head, если он задан,
+// иначе path+AnchorSuffix.
+func HeadPath(path string, head string) string {
+	if head != "" {
+		return head
+	}
+	return path + AnchorSuffix
+}
+
+// ReadAnchor читает голову журнала из файла path. Для отсутствующего файла
+// возвращается ошибка, для которой верно os.IsNotExist.
+func ReadAnchor(path string) (Anchor, error) {
+	var anchor Anchor
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return anchor, err
+	}
+	err = json.Unmarshal(content, &anchor)
+	if err != nil {
+		return anchor, &TamperError{Reason: "malformed head"}
+	}
+	return anchor, nil
+}
+
+// readHead читает голову журнала path из файла head. Голову пустого журнала
+// создаёт OpenFile, поэтому без головы проходит только пустой или ещё не
+// созданный журнал, и тогда missing истинно; у непустого журнала голову
+// удалили, и это *TamperError.
+func readHead(path string, head string) (anchor Anchor, missing bool, err error) {
+	anchor, err = ReadAnchor(head)
+	if !os.IsNotExist(err) {
+		return anchor, false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return anchor, false, err
+	}
+	if err == nil && info.Size() > 0 {
+		return anchor, false, &TamperError{Seq: 1, Reason: "head missing"}
+	}
+	return anchor, true, nil
+}
+
+// writeAnchor заменяет файл головы целиком, чтобы падение посреди записи
+// не оставило его испорченным. Новое содержимое сбрасывается на диск до
+// переименования.
+func writeAnchor(path string, anchor Anchor) error {
+	content, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(content, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// syncer — писатель, умеющий сбрасывать записанное на диск, как *os.File.
+type syncer interface {
+	Sync() error
+}
+
+// Log — журнал аудита: записи дописываются в w по одной JSON-строке
+// и хранятся в памяти для запросов. Если w умеет Sync, как *os.File,
+// каждая запись сбрасывается на диск до того, как Record вернётся.
+type Log struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []Entry
+	now     func() time.Time
+	// anchor — файл головы журнала, обновляемый после каждой записи
+	anchor string
+}
+
+// NewLog создаёт пустой журнал, пишущий в w.
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w, now: time.Now}
+}
+
+// LoadLog читает и проверяет журнал из r по голове anchor (см. Verify)
+// и продолжает его в w.
+func LoadLog(r io.Reader, w io.Writer, anchor Anchor) (*Log, error) {
+	entries, err := read(r, anchor)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{w: w, entries: entries, now: time.Now}, nil
+}
+
+// OpenFile проверяет журнал path по голове из файла head (пустой head —
+// path+AnchorSuffix) и продолжает его. Голова обновляется после каждой
+// записи, поэтому обрезанный с конца журнал при следующем открытии не
+// пройдёт проверку. Голову нового журнала OpenFile создаёт сразу, так что
+// непустой журнал без головы тоже её не пройдёт. Голову стоит хранить там,
+// где её не перепишет тот, кто может переписать журнал, или сверять с
+// копией, снятой через Head.
+func OpenFile(path string, head string) (*Log, error) {
+	head = HeadPath(path, head)
+	anchor, missing, err := readHead(path, head)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log, err := LoadLog(file, file, anchor)
+	if err == nil && missing {
+		err = writeAnchor(head, anchor)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.anchor = head
+	return log, nil
+}
+
+// ReadFile читает и проверяет журнал path по голове из файла head, как
+// OpenFile, но только для запросов: записи в него не попадут.
+func ReadFile(path string, head string) (*Log, error) {
+	anchor, _, err := readHead(path, HeadPath(path, head))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadLog(file, ioutil.Discard, anchor)
+}
+
+// Verify проверяет цепочку хэшей журнала из r и его голову anchor и возвращает
+// последнюю запись. Изменение, удаление или перестановка записи дают
+// *TamperError, как и журнал короче головы или с другим хэшем на её месте.
+// Голова обновляется после записи, поэтому журнал может быть длиннее её
+// на одну запись — ту, после которой процесс упал.
+func Verify(r io.Reader, anchor Anchor) (Entry, error) {
+	entries, err := read(r, anchor)
+	if err != nil || len(entries) == 0 {
+		return Entry{}, err
+	}
+	return entries[len(entries)-1], nil
+}
+
+func read(r io.Reader, anchor Anchor) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	prev := ""
+	for scanner.Scan() {
+		seq := int64(len(entries) + 1)
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, &TamperError{Seq: seq, Reason: "malformed entry"}
+		}
+		if entry.Seq != seq {
+			return nil, &TamperError{Seq: seq, Reason: "entry " + strconv.FormatInt(entry.Seq, 10) + " out of order"}
+		}
+		if entry.PrevHash != prev {
+			return nil, &TamperError{Seq: seq, Reason: "previous hash mismatch"}
+		}
+		sum, err := entry.sum()
+		if err != nil {
+			return nil, err
+		}
+		if sum != entry.Hash {
+			return nil, &TamperError{Seq: seq, Reason: "hash mismatch"}
+		}
+		entries = append(entries, entry)
+		prev = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	n := int64(len(entries))
+	switch {
+	case n < anchor.Seq:
+		return nil, &TamperError{Seq: n + 1, Reason: "log truncated"}
+	case anchor.Seq > 0 && entries[anchor.Seq-1].Hash != anchor.Hash:
+		return nil, &TamperError{Seq: anchor.Seq, Reason: "head hash mismatch"}
+	case n > anchor.Seq+1:
+		return nil, &TamperError{Seq: anchor.Seq + 2, Reason: "entry after head"}
+	}
+	return entries, nil
+}
+
+// Record дописывает запись: номер, время и хэши заполняет журнал.
+// Если журнал открыт через OpenFile, после записи обновляется его голова;
+// ошибка её записи возвращается, но запись в журнале уже есть. Голова
+// обновляется только после сброса записи на диск и не опережает журнал;
+// ошибка сброса тоже возвращается вместе с записью.
+func (l *Log) Record(entry Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = int64(len(l.entries) + 1)
+	entry.Time = l.now().UTC()
+	entry.PrevHash = ""
+	if len(l.entries) != 0 {
+		entry.PrevHash = l.entries[len(l.entries)-1].Hash
+	}
+	sum, err := entry.sum()
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Hash = sum
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	_, err = l.w.Write(append(content, '\n'))
+	if err != nil {
+		return Entry{}, err
+	}
+	l.entries = append(l.entries, entry)
+	if w, ok := l.w.(syncer); ok {
+		err = w.Sync()
+		if err != nil {
+			return entry, err
+		}
+	}
+	if l.anchor != "" {
+		err = writeAnchor(l.anchor, Anchor{Seq: entry.Seq, Hash: entry.Hash})
+	}
+	return entry, err
+}
+
+// RecordAction пишет действие actor над счётом accountID и целью target.
+// Без ошибки err результат — ResultOK, иначе ResultFailed, и текст ошибки
+// заменяет details. Этим пользуются все входы в кошелёк, кроме auth.Guard,
+// которому нужен ещё и ResultDenied.
+func (l *Log) RecordAction(actor string, action string, accountID int64, target string, details string, err error) (Entry, error) {
+	entry := Entry{
+		Actor:     actor,
+		Action:    action,
+		AccountID: accountID,
+		Target:    target,
+		Result:    ResultOK,
+		Details:   details,
+	}
+	if err != nil {
+		entry.Result = ResultFailed
+		entry.Details = err.Error()
+	}
+	return l.Record(entry)
+}
+
+// Head возвращает последнюю запись журнала; её Seq и Hash — голова для Verify.
+func (l *Log) Head() Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
+		return Entry{}
+	}
+	return l.entries[len(l.entries)-1]
+}
+
+// Query — условия выборки записей; пустые поля не ограничивают выборку.
+type Query struct {
+	Actor     string
+	AccountID int64
+	// From и To ограничивают время записи: From <= Time < To.
+	From time.Time
+	To   time.Time
+}
+
+func (q Query) match(entry Entry) bool {
+	return (q.Actor == "" || entry.Actor == q.Actor) &&
+		(q.AccountID == 0 || entry.AccountID == q.AccountID) &&
+		(q.From.IsZero() || !entry.Time.Before(q.From)) &&
+		(q.To.IsZero() || entry.Time.Before(q.To))
+}
+
+// Query возвращает записи, подходящие под q, в порядке записи.
+func (l *Log) Query(q Query) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result []Entry
+	for _, entry := range l.entries {
+		if q.match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
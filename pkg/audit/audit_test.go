@@ -0,0 +1,297 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLog создаёт журнал с тремя записями, сделанными с разницей в час.
+func newTestLog(t *testing.T) (*Log, *bytes.Buffer) {
+	var buf bytes.Buffer
+	log := NewLog(&buf)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	log.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+
+	entries := []Entry{
+		{Actor: "admin", Action: "deposit", AccountID: 1, Result: ResultOK, Details: "amount=100"},
+		{Actor: "account:2", Action: "reject", AccountID: 1, Target: "p1", Result: ResultDenied},
+		{Actor: "admin", Action: "export", Target: "data", Result: ResultOK},
+	}
+	for _, entry := range entries {
+		if _, err := log.Record(entry); err != nil {
+			t.Fatalf("Record(): error = %v", err)
+		}
+	}
+	return log, &buf
+}
+
+func TestLog_Record(t *testing.T) {
+	log, buf := newTestLog(t)
+
+	anchor := Anchor{Seq: log.Head().Seq, Hash: log.Head().Hash}
+	head, err := Verify(bytes.NewReader(buf.Bytes()), anchor)
+	if err != nil {
+		t.Errorf("Verify(): error = %v", err)
+		return
+	}
+	if head != log.Head() || head.Seq != 3 {
+		t.Errorf("Verify(): head = %v, want %v", head, log.Head())
+	}
+
+	//журнал продолжается после загрузки
+	loaded, err := LoadLog(bytes.NewReader(buf.Bytes()), buf, anchor)
+	if err != nil {
+		t.Errorf("LoadLog(): error = %v", err)
+		return
+	}
+	entry, err := loaded.Record(Entry{Actor: "walletd", Action: "import", Result: ResultOK})
+	if err != nil || entry.Seq != 4 || entry.PrevHash != head.Hash {
+		t.Errorf("Record(): entry = %v, error = %v", entry, err)
+	}
+	if _, err = Verify(bytes.NewReader(buf.Bytes()), anchor); err != nil {
+		t.Errorf("Verify(): error = %v", err)
+	}
+}
+
+func TestLog_RecordAction(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	entry, err := log.RecordAction("cli", "deposit", 1, "", "amount=100", nil)
+	if err != nil || entry.Actor != "cli" || entry.Result != ResultOK || entry.Details != "amount=100" {
+		t.Errorf("RecordAction(): entry = %v, error = %v", entry, err)
+	}
+	entry, err = log.RecordAction("cli", "reject", 1, "p1", "", ErrTampered)
+	if err != nil || entry.Result != ResultFailed || entry.Details != ErrTampered.Error() {
+		t.Errorf("RecordAction(): entry = %v, error = %v", entry, err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenFile(path, "")
+	if err != nil {
+		t.Errorf("OpenFile(): error = %v", err)
+		return
+	}
+	for _, action := range []string{"deposit", "reject", "export"} {
+		if _, err = log.RecordAction("cli", action, 1, "", "", nil); err != nil {
+			t.Errorf("RecordAction(): error = %v", err)
+			return
+		}
+	}
+
+	//журнал продолжается после открытия
+	log, err = OpenFile(path, "")
+	if err != nil {
+		t.Errorf("OpenFile(): error = %v", err)
+		return
+	}
+	entry, err := log.RecordAction("cli", "export", 0, "data", "", nil)
+	if err != nil || entry.Seq != 4 {
+		t.Errorf("RecordAction(): entry = %v, error = %v", entry, err)
+	}
+	anchor, err := ReadAnchor(path + AnchorSuffix)
+	if err != nil || anchor.Seq != 4 || anchor.Hash != entry.Hash {
+		t.Errorf("ReadAnchor() = %v, error = %v, want head %v", anchor, err, entry)
+	}
+
+	//удаление записей с конца журнала заметно по голове
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	err = ioutil.WriteFile(path, []byte(strings.Join(lines[:2], "")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenFile(path, "")
+	var tampered *TamperError
+	if !errors.As(err, &tampered) || tampered.Seq != 3 {
+		t.Errorf("OpenFile(): error = %v, want truncated log", err)
+	}
+
+	//как и удаление головы
+	_ = ioutil.WriteFile(path, content, 0644)
+	if _, err = OpenFile(path, ""); err != nil {
+		t.Errorf("OpenFile(): error = %v", err)
+	}
+	_ = os.Remove(path + AnchorSuffix)
+	if _, err = OpenFile(path, ""); !errors.Is(err, ErrTampered) {
+		t.Errorf("OpenFile(): error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestOpenFile_head(t *testing.T) {
+	//голова хранится отдельно от журнала и создаётся вместе с ним
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	head := filepath.Join(dir, "heads", "audit.head")
+	if err := os.Mkdir(filepath.Dir(head), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log, err := OpenFile(path, head)
+	if err != nil {
+		t.Errorf("OpenFile(): error = %v", err)
+		return
+	}
+	if _, err = ReadAnchor(head); err != nil {
+		t.Errorf("ReadAnchor(): error = %v", err)
+	}
+	entry, err := log.RecordAction("cli", "deposit", 1, "", "", nil)
+	if err != nil {
+		t.Errorf("RecordAction(): error = %v", err)
+		return
+	}
+	anchor, err := ReadAnchor(head)
+	if err != nil || anchor.Seq != 1 || anchor.Hash != entry.Hash {
+		t.Errorf("ReadAnchor() = %v, error = %v, want head %v", anchor, err, entry)
+	}
+	if _, err = os.Stat(path + AnchorSuffix); !os.IsNotExist(err) {
+		t.Errorf("Stat(): error = %v, want no head next to the log", err)
+	}
+	if _, err = ReadFile(path, head); err != nil {
+		t.Errorf("ReadFile(): error = %v", err)
+	}
+
+	//журнал даже из одной записи без головы не проходит проверку
+	_ = os.Remove(head)
+	if _, err = OpenFile(path, head); !errors.Is(err, ErrTampered) {
+		t.Errorf("OpenFile(): error = %v, want %v", err, ErrTampered)
+	}
+	if _, err = ReadFile(path, head); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadFile(): error = %v, want %v", err, ErrTampered)
+	}
+}
+
+// syncBuffer считает сбросы на диск.
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+	err   error
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	return b.err
+}
+
+func TestLog_Record_sync(t *testing.T) {
+	var buf syncBuffer
+	log := NewLog(&buf)
+	for i := 0; i < 2; i++ {
+		if _, err := log.RecordAction("cli", "deposit", 1, "", "", nil); err != nil {
+			t.Errorf("RecordAction(): error = %v", err)
+		}
+	}
+	if buf.syncs != 2 {
+		t.Errorf("RecordAction(): syncs = %v, want 2", buf.syncs)
+	}
+
+	//ошибка сброса возвращается, но запись уже в журнале
+	buf.err = errors.New("disk full")
+	entry, err := log.RecordAction("cli", "export", 0, "data", "", nil)
+	if err != buf.err || entry.Seq != 3 || log.Head().Hash != entry.Hash {
+		t.Errorf("RecordAction(): entry = %v, error = %v", entry, err)
+	}
+}
+
+func TestVerify_anchor(t *testing.T) {
+	log, buf := newTestLog(t)
+	head := log.Head()
+	lines := strings.SplitAfter(buf.String(), "\n")[:3]
+
+	tests := []struct {
+		name    string
+		content string
+		anchor  Anchor
+		seq     int64
+	}{
+		{"truncated", lines[0] + lines[1], Anchor{Seq: 3, Hash: head.Hash}, 3},
+		{"empty", "", Anchor{Seq: 3, Hash: head.Hash}, 1},
+		{"other head", buf.String(), Anchor{Seq: 3, Hash: "0000"}, 3},
+		{"after head", buf.String(), Anchor{Seq: 1, Hash: log.Query(Query{})[0].Hash}, 3},
+		{"no head", buf.String(), Anchor{}, 2},
+	}
+	for _, tt := range tests {
+		_, err := Verify(strings.NewReader(tt.content), tt.anchor)
+		var tampered *TamperError
+		if !errors.As(err, &tampered) || tampered.Seq != tt.seq {
+			t.Errorf("Verify(%s): error = %v, want tampered entry %v", tt.name, err, tt.seq)
+		}
+	}
+
+	//после последней записи процесс мог упасть, не обновив голову
+	previous := log.Query(Query{})[1]
+	got, err := Verify(strings.NewReader(buf.String()), Anchor{Seq: 2, Hash: previous.Hash})
+	if err != nil || got != head {
+		t.Errorf("Verify(): head = %v, error = %v, want %v", got, err, head)
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	_, buf := newTestLog(t)
+	lines := strings.SplitAfter(buf.String(), "\n")[:3]
+
+	tests := []struct {
+		name    string
+		content string
+		seq     int64
+	}{
+		{"modified", lines[0] + strings.Replace(lines[1], `"denied"`, `"ok"`, 1) + lines[2], 2},
+		{"deleted", lines[0] + lines[2], 2},
+		{"reordered", lines[1] + lines[0] + lines[2], 1},
+		{"malformed", lines[0] + "{\n" + lines[2], 2},
+	}
+	for _, tt := range tests {
+		_, err := Verify(strings.NewReader(tt.content), Anchor{})
+		var tampered *TamperError
+		if !errors.As(err, &tampered) || !errors.Is(err, ErrTampered) || tampered.Seq != tt.seq {
+			t.Errorf("Verify(%s): error = %v, want tampered entry %v", tt.name, err, tt.seq)
+		}
+	}
+}
+
+func TestLog_Query(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []int64
+	}{
+		{"all", Query{}, []int64{1, 2, 3}},
+		{"actor", Query{Actor: "admin"}, []int64{1, 3}},
+		{"account", Query{AccountID: 1}, []int64{1, 2}},
+		{"time", Query{
+			From: time.Date(2021, 1, 1, 2, 0, 0, 0, time.UTC),
+			To:   time.Date(2021, 1, 1, 3, 0, 0, 0, time.UTC),
+		}, []int64{2}},
+		{"none", Query{Actor: "admin", AccountID: 2}, nil},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, entry := range log.Query(tt.query) {
+			got = append(got, entry.Seq)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Query(%s) = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Query(%s) = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+var ErrForbidden = errors.New("forbidden")
+var ErrNoSecret = errors.New("token secret is not configured")
+
+// tokenPrefix отличает подписанные токены от ключей API.
+const tokenPrefix = "v1."
+
+// Principal — тот, от чьего имени выполняется операция: владелец счёта
+// AccountID или администратор.
+type Principal struct {
+	AccountID int64
+	Admin     bool
+}
+
+// Admin — администратор, которому доступны все счета.
+var Admin = Principal{Admin: true}
+
+// Account возвращает владельца счёта accountID.
+func Account(accountID int64) Principal {
+	return Principal{AccountID: accountID}
+}
+
+// CanAccess сообщает, может ли p работать со счётом accountID.
+func (p Principal) CanAccess(accountID int64) bool {
+	return p.Admin || (p.AccountID != 0 && p.AccountID == accountID)
+}
+
+func (p Principal) String() string {
+	if p.Admin {
+		return "admin"
+	}
+	return "account:" + strconv.FormatInt(p.AccountID, 10)
+}
+
+// Authenticator проверяет ключи API и подписанные HMAC токены.
+//
+// Ключи хранятся только в виде хэшей SHA-256. Токен имеет вид
+// v1.<данные>.<подпись>, где данные — base64 от «субъект;срок действия»,
+// а подпись — HMAC-SHA256 данных секретом.
+type Authenticator struct {
+	secret []byte
+	now    func() time.Time
+
+	mu   sync.RWMutex
+	keys map[[sha256.Size]byte]Principal
+}
+
+// NewAuthenticator создаёт проверку с секретом для токенов secret.
+// Если secret пуст, принимаются только ключи API.
+func NewAuthenticator(secret []byte) *Authenticator {
+	return &Authenticator{
+		secret: secret,
+		now:    time.Now,
+		keys:   make(map[[sha256.Size]byte]Principal),
+	}
+}
+
+// GenerateKey возвращает новый случайный ключ API.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// AddKey привязывает ключ API key к p.
+func (a *Authenticator) AddKey(key string, p Principal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keys[sha256.Sum256([]byte(key))] = p
+}
+
+func (a *Authenticator) RevokeKey(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.keys, sha256.Sum256([]byte(key)))
+}
+
+// IssueToken выпускает токен для p, действующий ttl.
+func (a *Authenticator) IssueToken(p Principal, ttl time.Duration) (string, error) {
+	if len(a.secret) == 0 {
+		return "", ErrNoSecret
+	}
+
+	subject := "admin"
+	if !p.Admin {
+		subject = strconv.FormatInt(p.AccountID, 10)
+	}
+	expires := a.now().Add(ttl).Unix()
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject + ";" + strconv.FormatInt(expires, 10)))
+	return tokenPrefix + payload + "." + a.sign(payload), nil
+}
+
+// Authenticate возвращает владельца ключа API или токена credential.
+// Для неизвестного ключа, поддельного или просроченного токена возвращается
+// ErrUnauthenticated.
+func (a *Authenticator) Authenticate(credential string) (Principal, error) {
+	if strings.HasPrefix(credential, tokenPrefix) {
+		return a.verifyToken(strings.TrimPrefix(credential, tokenPrefix))
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	p, ok := a.keys[sha256.Sum256([]byte(credential))]
+	if !ok {
+		return Principal{}, ErrUnauthenticated
+	}
+	return p, nil
+}
+
+func (a *Authenticator) verifyToken(token string) (Principal, error) {
+	if len(a.secret) == 0 {
+		return Principal{}, ErrUnauthenticated
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(a.sign(parts[0])), []byte(parts[1])) {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	value := strings.Split(string(payload), ";")
+	if len(value) != 2 {
+		return Principal{}, ErrUnauthenticated
+	}
+	expires, err := strconv.ParseInt(value[1], 10, 64)
+	if err != nil || a.now().Unix() >= expires {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	if value[0] == "admin" {
+		return Admin, nil
+	}
+	accountID, err := strconv.ParseInt(value[0], 10, 64)
+	if err != nil || accountID <= 0 {
+		return Principal{}, ErrUnauthenticated
+	}
+	return Account(accountID), nil
+}
+
+func (a *Authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	_, _ = mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
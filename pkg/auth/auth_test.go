@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bytes"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator_keys(t *testing.T) {
+	a := NewAuthenticator(nil)
+	a.AddKey("admin-key", Admin)
+	a.AddKey("user-key", Account(7))
+
+	p, err := a.Authenticate("admin-key")
+	if err != nil || p != Admin {
+		t.Errorf("Authenticate(): p = %v, error = %v", p, err)
+	}
+	p, err = a.Authenticate("user-key")
+	if err != nil || p != Account(7) {
+		t.Errorf("Authenticate(): p = %v, error = %v", p, err)
+	}
+
+	a.RevokeKey("user-key")
+	_, err = a.Authenticate("user-key")
+	if err != ErrUnauthenticated {
+		t.Errorf("Authenticate(): error = %v, want %v", err, ErrUnauthenticated)
+	}
+	_, err = a.IssueToken(Admin, time.Hour)
+	if err != ErrNoSecret {
+		t.Errorf("IssueToken(): error = %v, want %v", err, ErrNoSecret)
+	}
+}
+
+func TestAuthenticator_tokens(t *testing.T) {
+	a := NewAuthenticator([]byte("secret"))
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	token, err := a.IssueToken(Account(3), time.Hour)
+	if err != nil {
+		t.Errorf("IssueToken(): error = %v", err)
+		return
+	}
+	p, err := a.Authenticate(token)
+	if err != nil || p != Account(3) {
+		t.Errorf("Authenticate(): p = %v, error = %v", p, err)
+	}
+
+	//подпись другим секретом не принимается
+	forged, err := NewAuthenticator([]byte("other")).IssueToken(Admin, time.Hour)
+	if err != nil {
+		t.Errorf("IssueToken(): error = %v", err)
+		return
+	}
+	_, err = a.Authenticate(forged)
+	if err != ErrUnauthenticated {
+		t.Errorf("Authenticate(forged): error = %v, want %v", err, ErrUnauthenticated)
+	}
+
+	//подмена данных ломает подпись
+	admin, _ := a.IssueToken(Admin, time.Hour)
+	tampered := admin[:strings.LastIndex(admin, ".")] + token[strings.LastIndex(token, "."):]
+	_, err = a.Authenticate(tampered)
+	if err != ErrUnauthenticated {
+		t.Errorf("Authenticate(tampered): error = %v, want %v", err, ErrUnauthenticated)
+	}
+
+	now = now.Add(time.Hour)
+	_, err = a.Authenticate(token)
+	if err != ErrUnauthenticated {
+		t.Errorf("Authenticate(expired): error = %v, want %v", err, ErrUnauthenticated)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	//создаем Сервис с двумя счетами и платежом первого
+	svc := &wallet.Service{}
+	first, _ := svc.RegisterAccount("+992000000001")
+	second, _ := svc.RegisterAccount("+992000000002")
+	_ = svc.Deposit(first.ID, 1000)
+	payment, err := svc.Pay(first.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var audit bytes.Buffer
+	g := NewGuard(svc, logger.New(&audit, logger.LevelInfo))
+	owner := Account(first.ID)
+	stranger := Account(second.ID)
+
+	_, err = g.Pay(stranger, first.ID, 100, "auto")
+	if err != ErrForbidden {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrForbidden)
+	}
+	err = g.Reject(stranger, payment.ID)
+	if err != ErrForbidden {
+		t.Errorf("Reject(): error = %v, want %v", err, ErrForbidden)
+	}
+	_, err = g.ExportAccountHistory(stranger, first.ID)
+	if err != ErrForbidden {
+		t.Errorf("ExportAccountHistory(): error = %v, want %v", err, ErrForbidden)
+	}
+	_, err = g.RegisterAccount(owner, "+992000000003")
+	if err != ErrForbidden {
+		t.Errorf("RegisterAccount(): error = %v, want %v", err, ErrForbidden)
+	}
+
+	_, err = g.Pay(owner, first.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+	err = g.Reject(Admin, payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+	}
+	history, err := g.ExportAccountHistory(owner, first.ID)
+	if err != nil || len(history) != 2 {
+		t.Errorf("ExportAccountHistory(): history = %v, error = %v", history, err)
+	}
+	rejected, _ := svc.FindPaymentByID(payment.ID)
+	if rejected.Status != types.PaymentStatusFail {
+		t.Errorf("Reject(): status = %v, want %v", rejected.Status, types.PaymentStatusFail)
+	}
+
+	log := audit.String()
+	if strings.Count(log, `msg="access denied"`) != 4 {
+		t.Errorf("audit: log = %q", log)
+	}
+	if !strings.Contains(log, "principal=account:2 operation=reject account_id=1") {
+		t.Errorf("audit: log = %q", log)
+	}
+}
+
+func TestGuard_SetPIN_enrolment(t *testing.T) {
+	//первый второй фактор счёта задаёт только администратор
+	svc := &wallet.Service{}
+	account, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	g := NewGuard(svc, logger.New(ioutil.Discard, logger.LevelError))
+	owner := Account(account.ID)
+
+	err = g.SetPIN(owner, account.ID, "", "1234")
+	if err != ErrForbidden {
+		t.Errorf("SetPIN(): error = %v, want %v", err, ErrForbidden)
+	}
+	_, err = g.EnableTOTP(owner, account.ID)
+	if err != ErrForbidden {
+		t.Errorf("EnableTOTP(): error = %v, want %v", err, ErrForbidden)
+	}
+	err = g.SetPIN(Admin, account.ID, "", "1234")
+	if err != nil {
+		t.Errorf("SetPIN(): error = %v", err)
+		return
+	}
+
+	//дальше владелец меняет PIN сам, зная текущий
+	err = g.SetPIN(owner, account.ID, "0000", "5678")
+	if err != wallet.ErrInvalidCode {
+		t.Errorf("SetPIN(): error = %v, want %v", err, wallet.ErrInvalidCode)
+	}
+	err = g.SetPIN(owner, account.ID, "1234", "5678")
+	if err != nil {
+		t.Errorf("SetPIN(): error = %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"strconv"
+)
+
+// Guard проверяет права вызывающего перед операциями wallet.Service.
+// Владелец счёта работает только со своим счётом, его платежами и
+// избранным; администратору доступно всё. Пополнение и отмена платежа
+// зачисляют деньги на счёт, поэтому доступны только администратору. Каждый отказ возвращает
+// ErrForbidden и пишется в лог. Если задан журнал аудита (SetAuditLog), в него
+// пишутся отказы и привилегированные действия: регистрация, пополнение,
+// отмена платежа, смена второго фактора, смена статуса счёта и операции
+// администратора.
+type Guard struct {
+	svc   *wallet.Service
+	audit *logger.Logger
+	log   *audit.Log
+}
+
+// NewGuard создаёт проверку прав над svc. Отказы пишутся в audit,
+// а если он nil — в logger.Default().
+func NewGuard(svc *wallet.Service, audit *logger.Logger) *Guard {
+	return &Guard{svc: svc, audit: audit}
+}
+
+func (g *Guard) SetAuditLog(log *audit.Log) {
+	g.log = log
+}
+
+// Admin выполняет fn, если p — администратор, и записывает операцию
+// operation над target в журнал аудита.
+func (g *Guard) Admin(p Principal, operation string, target string, fn func() error) error {
+	if err := g.RequireAdmin(p, operation); err != nil {
+		return err
+	}
+	err := fn()
+	g.record(p, operation, 0, target, "", err)
+	return err
+}
+
+// RequireAdmin разрешает операцию operation только администратору.
+func (g *Guard) RequireAdmin(p Principal, operation string) error {
+	if !p.Admin {
+		return g.deny(p, operation, 0)
+	}
+	return nil
+}
+
+// RequireAccount разрешает операцию operation над счётом accountID его
+// владельцу и администратору.
+func (g *Guard) RequireAccount(p Principal, operation string, accountID int64) error {
+	if !p.CanAccess(accountID) {
+		return g.deny(p, operation, accountID)
+	}
+	return nil
+}
+
+func (g *Guard) RegisterAccount(p Principal, phone types.Phone) (*types.Account, error) {
+	if err := g.RequireAdmin(p, "register_account"); err != nil {
+		return nil, err
+	}
+	account, err := g.svc.RegisterAccount(phone)
+	if err != nil {
+		g.record(p, "register_account", 0, "", "", err)
+		return nil, err
+	}
+	g.record(p, "register_account", account.ID, "", "", nil)
+	return account, nil
+}
+
+func (g *Guard) FindAccountByID(p Principal, accountID int64) (*types.Account, error) {
+	if err := g.RequireAccount(p, "find_account", accountID); err != nil {
+		return nil, err
+	}
+	return g.svc.FindAccountByID(accountID)
+}
+
+func (g *Guard) Deposit(p Principal, accountID int64, amount types.Money) error {
+	if !p.Admin {
+		return g.deny(p, "deposit", accountID)
+	}
+	err := g.svc.Deposit(accountID, amount)
+	g.record(p, "deposit", accountID, "", "amount="+strconv.FormatInt(int64(amount), 10), err)
+	return err
+}
+
+func (g *Guard) Pay(p Principal, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	if err := g.RequireAccount(p, "pay", accountID); err != nil {
+		return nil, err
+	}
+	return g.svc.Pay(accountID, amount, category)
+}
+
+func (g *Guard) ExportAccountHistory(p Principal, accountID int64) ([]types.Payment, error) {
+	if err := g.RequireAccount(p, "export_account_history", accountID); err != nil {
+		return nil, err
+	}
+	return g.svc.ExportAccountHistory(accountID)
+}
+
+func (g *Guard) FindPaymentByID(p Principal, paymentID string) (*types.Payment, error) {
+	return g.payment(p, "find_payment", paymentID)
+}
+
+func (g *Guard) Reject(p Principal, paymentID string) error {
+	payment, err := g.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	if !p.Admin {
+		return g.deny(p, "reject", payment.AccountID)
+	}
+	err = g.svc.Reject(paymentID)
+	g.record(p, "reject", payment.AccountID, paymentID, "", err)
+	return err
+}
+
+func (g *Guard) Repeat(p Principal, paymentID string) (*types.Payment, error) {
+	if _, err := g.payment(p, "repeat", paymentID); err != nil {
+		return nil, err
+	}
+	return g.svc.Repeat(paymentID)
+}
+
+func (g *Guard) FavoritePayment(p Principal, paymentID string, name string) (*types.Favorite, error) {
+	if _, err := g.payment(p, "favorite_payment", paymentID); err != nil {
+		return nil, err
+	}
+	return g.svc.FavoritePayment(paymentID, name)
+}
+
+func (g *Guard) FindFavoriteByID(p Principal, favoriteID string) (*types.Favorite, error) {
+	return g.favorite(p, "find_favorite", favoriteID)
+}
+
+func (g *Guard) PayFromFavorite(p Principal, favoriteID string) (*types.Payment, error) {
+	if _, err := g.favorite(p, "pay_from_favorite", favoriteID); err != nil {
+		return nil, err
+	}
+	return g.svc.PayFromFavorite(favoriteID)
+}
+
+// SetPIN меняет PIN счёта; владельцу, как и администратору, нужен текущий PIN.
+// Первый второй фактор счёта задаёт только администратор (см. enrolment).
+func (g *Guard) SetPIN(p Principal, accountID int64, current string, pin string) error {
+	if err := g.enrolment(p, "set_pin", accountID); err != nil {
+		return err
+	}
+	err := g.svc.SetPIN(accountID, current, pin)
+	g.record(p, "set_pin", accountID, "", "", err)
+	return err
+}
+
+func (g *Guard) EnableTOTP(p Principal, accountID int64) (string, error) {
+	if err := g.enrolment(p, "enable_totp", accountID); err != nil {
+		return "", err
+	}
+	secret, err := g.svc.EnableTOTP(accountID)
+	g.record(p, "enable_totp", accountID, "", "", err)
+	return secret, err
+}
+
+// SetAccountStatus меняет статус счёта. Владелец может только заморозить свой
+// активный счёт, например потеряв телефон (см. wallet.Service.FreezeAccount);
+// остальные смены, включая заморозку заблокированного счёта, доступны
+// администратору.
+func (g *Guard) SetAccountStatus(p Principal, accountID int64, status types.AccountStatus, reason string) (*types.Account, error) {
+	var account *types.Account
+	var err error
+	switch {
+	case p.Admin:
+		account, err = g.svc.SetAccountStatus(accountID, status, reason)
+	case status == types.AccountStatusFrozen:
+		if err := g.RequireAccount(p, "set_status", accountID); err != nil {
+			return nil, err
+		}
+		account, err = g.svc.FreezeAccount(accountID, reason)
+	default:
+		return nil, g.deny(p, "set_status", accountID)
+	}
+	g.record(p, "set_status", accountID, "", "status="+string(status)+" reason="+reason, err)
+	return account, err
+}
+
+func (g *Guard) ConfirmPayment(p Principal, challengeID string, code string) (*types.Payment, error) {
+	challenge, err := g.svc.FindChallengeByID(challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.RequireAccount(p, "confirm_payment", challenge.AccountID); err != nil {
+		return nil, err
+	}
+	return g.svc.ConfirmPayment(challengeID, code)
+}
+
+// enrolment разрешает смену второго фактора владельцу счёта, только если
+// у счёта уже есть PIN или TOTP. Иначе любой, у кого есть токен счёта,
+// задал бы фактор без проверки, поэтому первый фактор задаёт администратор.
+func (g *Guard) enrolment(p Principal, operation string, accountID int64) error {
+	if !p.Admin && !g.svc.HasSecondFactor(accountID) {
+		return g.deny(p, operation, accountID)
+	}
+	return g.RequireAccount(p, operation, accountID)
+}
+
+// payment находит платёж и проверяет, что p владеет его счётом. Счёт платежа
+// не меняется, поэтому проверка остаётся верной и после поиска.
+func (g *Guard) payment(p Principal, operation string, paymentID string) (*types.Payment, error) {
+	payment, err := g.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.RequireAccount(p, operation, payment.AccountID); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func (g *Guard) favorite(p Principal, operation string, favoriteID string) (*types.Favorite, error) {
+	favorite, err := g.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.RequireAccount(p, operation, favorite.AccountID); err != nil {
+		return nil, err
+	}
+	return favorite, nil
+}
+
+func (g *Guard) deny(p Principal, operation string, accountID int64) error {
+	audit := g.audit
+	if audit == nil {
+		audit = logger.Default()
+	}
+	fields := []logger.Field{logger.String("principal", p.String()), logger.String("operation", operation)}
+	if accountID != 0 {
+		fields = append(fields, logger.AccountID(accountID))
+	}
+	audit.Warn("access denied", fields...)
+	g.record(p, operation, accountID, "", "", ErrForbidden)
+	return ErrForbidden
+}
+
+// record пишет действие в журнал аудита, если он задан. Ошибка записи
+// действие не отменяет и только пишется в лог.
+func (g *Guard) record(p Principal, action string, accountID int64, target string, details string, err error) {
+	if g.log == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		Actor:     p.String(),
+		Action:    action,
+		AccountID: accountID,
+		Target:    target,
+		Result:    audit.ResultOK,
+		Details:   details,
+	}
+	switch {
+	case err == ErrForbidden:
+		entry.Result = audit.ResultDenied
+	case err != nil:
+		entry.Result = audit.ResultFailed
+		entry.Details = err.Error()
+	}
+	_, err = g.log.Record(entry)
+	if err != nil {
+		lg := g.audit
+		if lg == nil {
+			lg = logger.Default()
+		}
+		lg.Error("write audit log", logger.String("action", action), logger.Err(err))
+	}
+}
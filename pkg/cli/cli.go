@@ -0,0 +1,427 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+const usage = `usage: wallet [-data dir] [-audit file [-audit-head file]] [-json] <command> [arguments]
+
+commands:
+  account register <phone>
+  account show <account>
+  deposit <account> <amount>
+  pay <account> <amount> <category>
+  reject <payment>
+  repeat <payment>
+  favorite add <payment> <name>
+  favorite pay <favorite>
+  history <account>
+  sum [-goroutines n]
+  export [-format dump|json] [-out dir]
+  squash [-out dir] <delta dir>...
+  audit verify [-head file] <file>
+  audit query [-head file] [-actor a] [-account n] [-from t] [-to t] <file>
+`
+
+// command выполняет одну подкоманду над загруженным сервисом.
+// changed сообщает, нужно ли сохранить данные после выполнения.
+type command func(c *cli, args []string) (changed bool, err error)
+
+var commands = map[string]command{
+	"account":  accountCommand,
+	"deposit":  depositCommand,
+	"pay":      payCommand,
+	"reject":   rejectCommand,
+	"repeat":   repeatCommand,
+	"favorite": favoriteCommand,
+	"history":  historyCommand,
+	"sum":      sumCommand,
+	"export":   exportCommand,
+	"squash":   squashCommand,
+	"audit":    auditCommand,
+}
+
+type cli struct {
+	svc    *wallet.Service
+	dir    string
+	json   bool
+	stdout io.Writer
+	stderr io.Writer
+	// auditLog получает пополнения, отмены платежей и выгрузки, если задан -audit
+	auditLog *audit.Log
+}
+
+// Run выполняет команду args над данными из каталога -data и возвращает код выхода.
+func Run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	dir := flags.String("data", "data", "directory with dumps")
+	asJSON := flags.Bool("json", false, "print results as JSON")
+	auditFile := flags.String("audit", "", "hash-chained audit log to record deposits, rejects and exports to")
+	auditHead := flags.String("audit-head", "", "file with the head of the -audit log; defaults to <audit>.head")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "wallet: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	c := &cli{svc: &wallet.Service{}, dir: *dir, json: *asJSON, stdout: stdout, stderr: stderr}
+	if *auditFile != "" {
+		log, err := audit.OpenFile(*auditFile, *auditHead)
+		if err != nil {
+			fmt.Fprintf(stderr, "wallet: %v\n", err)
+			return 1
+		}
+		c.auditLog = log
+	}
+	if err := c.svc.Import(c.dir); err != nil {
+		fmt.Fprintf(stderr, "wallet: %v\n", err)
+		return 1
+	}
+
+	changed, err := cmd(c, flags.Args()[1:])
+	if err == errUsage {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "wallet: %v\n", err)
+		return 1
+	}
+	if changed {
+		if err := c.svc.Export(c.dir); err != nil {
+			fmt.Fprintf(stderr, "wallet: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func accountCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 2 {
+		return false, errUsage
+	}
+	switch args[0] {
+	case "register":
+		account, err := c.svc.RegisterAccount(types.Phone(args[1]))
+		if err != nil {
+			return false, err
+		}
+		return true, c.printAccount(*account)
+	case "show":
+		id, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		account, err := c.svc.FindAccountByID(id)
+		if err != nil {
+			return false, err
+		}
+		return false, c.printAccount(*account)
+	}
+	return false, errUsage
+}
+
+func depositCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 2 {
+		return false, errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return false, err
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return false, err
+	}
+
+	err = c.svc.Deposit(id, amount)
+	c.record("deposit", id, "", "amount="+strconv.FormatInt(int64(amount), 10), err)
+	if err != nil {
+		return false, err
+	}
+	account, err := c.svc.FindAccountByID(id)
+	if err != nil {
+		return false, err
+	}
+	return true, c.printAccount(*account)
+}
+
+func payCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 3 {
+		return false, errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return false, err
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return false, err
+	}
+
+	payment, err := c.svc.Pay(id, amount, types.PaymentCategory(args[2]))
+	if err != nil {
+		return false, err
+	}
+	return true, c.printPayments(*payment)
+}
+
+func rejectCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, errUsage
+	}
+
+	payment, err := c.svc.FindPaymentByID(args[0])
+	if err != nil {
+		return false, err
+	}
+	err = c.svc.Reject(payment.ID)
+	c.record("reject", payment.AccountID, payment.ID, "", err)
+	if err != nil {
+		return false, err
+	}
+	payment, err = c.svc.FindPaymentByID(payment.ID)
+	if err != nil {
+		return false, err
+	}
+	return true, c.printPayments(*payment)
+}
+
+func repeatCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, errUsage
+	}
+
+	payment, err := c.svc.Repeat(args[0])
+	if err != nil {
+		return false, err
+	}
+	return true, c.printPayments(*payment)
+}
+
+func favoriteCommand(c *cli, args []string) (bool, error) {
+	if len(args) < 2 {
+		return false, errUsage
+	}
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		favorite, err := c.svc.FavoritePayment(args[1], args[2])
+		if err != nil {
+			return false, err
+		}
+		return true, c.printFavorite(*favorite)
+	case args[0] == "pay" && len(args) == 2:
+		payment, err := c.svc.PayFromFavorite(args[1])
+		if err != nil {
+			return false, err
+		}
+		return true, c.printPayments(*payment)
+	}
+	return false, errUsage
+}
+
+func historyCommand(c *cli, args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return false, err
+	}
+	if _, err := c.svc.FindAccountByID(id); err != nil {
+		return false, err
+	}
+
+	payments, err := c.svc.ExportAccountHistory(id)
+	if err != nil && err != wallet.ErrAccountNotFound {
+		return false, err
+	}
+	return false, c.printPayments(payments...)
+}
+
+func sumCommand(c *cli, args []string) (bool, error) {
+	flags := flag.NewFlagSet("sum", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	goroutines := flags.Int("goroutines", 4, "number of goroutines")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return false, errUsage
+	}
+
+	sum := c.svc.SumPayments(*goroutines)
+	if c.json {
+		return false, c.printJSON(struct {
+			Sum types.Money `json:"sum"`
+		}{Sum: sum})
+	}
+	_, err := fmt.Fprintf(c.stdout, "%d\n", sum)
+	return false, err
+}
+
+func exportCommand(c *cli, args []string) (bool, error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	format := flags.String("format", "dump", "dump or json")
+	out := flags.String("out", "", "directory for dump files, defaults to -data")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return false, errUsage
+	}
+
+	switch *format {
+	case "dump":
+		dir := *out
+		if dir == "" {
+			dir = c.dir
+		}
+		err := c.svc.Export(dir)
+		c.record("export", 0, dir, "", err)
+		return false, err
+	case "json":
+		err := c.printJSON(snapshot(c.svc))
+		c.record("export", 0, "stdout", "format=json", err)
+		return false, err
+	}
+	return false, errUsage
+}
+
+// squashCommand собирает базовую выгрузку из -data и цепочку дельт
+// (см. wallet.Service.ExportDelta) в новую базовую выгрузку в -out,
+// по умолчанию в -data. Дельты перечисляются в порядке выгрузки.
+func squashCommand(c *cli, args []string) (bool, error) {
+	flags := flag.NewFlagSet("squash", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	out := flags.String("out", "", "directory for the new base dump, defaults to -data")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return false, errUsage
+	}
+
+	dir := *out
+	if dir == "" {
+		dir = c.dir
+	}
+	// новая выгрузка уже записана, сохранять загруженный сервис поверх неё нельзя
+	err := wallet.Squash(dir, c.dir, flags.Args()...)
+	c.record("squash", 0, dir, "deltas="+strings.Join(flags.Args(), ","), err)
+	return false, err
+}
+
+// record пишет действие в журнал аудита, если он задан. Ошибка записи
+// действие не отменяет и только выводится в stderr.
+func (c *cli) record(action string, accountID int64, target string, details string, err error) {
+	if c.auditLog == nil {
+		return
+	}
+
+	_, err = c.auditLog.RecordAction("cli", action, accountID, target, details, err)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "wallet: write audit log: %v\n", err)
+	}
+}
+
+// auditCommand проверяет журнал аудита по его голове (по умолчанию из
+// <file>.head, см. audit.HeadPath) и делает выборку из него.
+func auditCommand(c *cli, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, errUsage
+	}
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	actor := flags.String("actor", "", "actor, e.g. admin or account:1")
+	account := flags.Int64("account", 0, "account id")
+	from := flags.String("from", "", "RFC 3339 time, inclusive")
+	to := flags.String("to", "", "RFC 3339 time, exclusive")
+	head := flags.String("head", "", "file with the log's head; defaults to <file>.head")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+		return false, errUsage
+	}
+
+	log, err := audit.ReadFile(flags.Arg(0), *head)
+	if err != nil {
+		return false, err
+	}
+
+	switch args[0] {
+	case "verify":
+		head := log.Head()
+		if c.json {
+			return false, c.printJSON(struct {
+				Entries int64  `json:"entries"`
+				Head    string `json:"head"`
+			}{head.Seq, head.Hash})
+		}
+		_, err = fmt.Fprintf(c.stdout, "ok: %d entries, head %s\n", head.Seq, head.Hash)
+		return false, err
+	case "query":
+		q := audit.Query{Actor: *actor, AccountID: *account}
+		if q.From, err = parseTime(*from); err != nil {
+			return false, err
+		}
+		if q.To, err = parseTime(*to); err != nil {
+			return false, err
+		}
+		entries := log.Query(q)
+		if c.json {
+			if entries == nil {
+				entries = []audit.Entry{}
+			}
+			return false, c.printJSON(entries)
+		}
+		for _, entry := range entries {
+			_, err = fmt.Fprintf(c.stdout, "%d %s %s %s account=%d target=%s %s\n", entry.Seq,
+				entry.Time.Format(time.RFC3339), entry.Actor, entry.Action, entry.AccountID, entry.Target, entry.Result)
+			if err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	return false, errUsage
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", raw)
+	}
+	return t, nil
+}
+
+func parseID(raw string) (int64, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid account id %q", raw)
+	}
+	return id, nil
+}
+
+func parseAmount(raw string) (types.Money, error) {
+	amount, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", raw)
+	}
+	return types.Money(amount), nil
+}
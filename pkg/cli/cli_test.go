@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(append([]string{"-data", dir}, args...), &stdout, &stderr)
+	if code != 0 {
+		t.Logf("wallet %v: %s", args, stderr.String())
+	}
+	return stdout.String(), code
+}
+
+func TestRun_payments(t *testing.T) {
+	dir := t.TempDir()
+
+	out, code := run(t, dir, "account", "register", "+992000000001")
+	if code != 0 || !strings.Contains(out, "account 1") {
+		t.Fatalf("account register: code = %v, out = %q", code, out)
+	}
+	if _, code = run(t, dir, "deposit", "1", "1000"); code != 0 {
+		t.Fatalf("deposit: code = %v", code)
+	}
+
+	// данные сохраняются между запусками
+	out, code = run(t, dir, "-json", "pay", "1", "300", "auto")
+	if code != 0 {
+		t.Fatalf("pay: code = %v", code)
+	}
+	var payments []paymentJSON
+	if err := json.Unmarshal([]byte(out), &payments); err != nil || len(payments) != 1 {
+		t.Fatalf("pay: invalid output %q, error = %v", out, err)
+	}
+	if _, code = run(t, dir, "reject", payments[0].ID); code != 0 {
+		t.Fatalf("reject: code = %v", code)
+	}
+	out, code = run(t, dir, "favorite", "add", payments[0].ID, "Tcell")
+	if code != 0 {
+		t.Fatalf("favorite add: code = %v", code)
+	}
+	favoriteID := strings.Fields(out)[1]
+	if _, code = run(t, dir, "favorite", "pay", favoriteID); code != 0 {
+		t.Fatalf("favorite pay: code = %v", code)
+	}
+
+	out, code = run(t, dir, "history", "1")
+	if code != 0 || strings.Count(out, "\n") != 2 {
+		t.Errorf("history: code = %v, out = %q", code, out)
+	}
+	out, code = run(t, dir, "sum")
+	if code != 0 || out != "600\n" {
+		t.Errorf("sum: code = %v, out = %q, want %q", code, out, "600\n")
+	}
+
+	out, code = run(t, dir, "export", "-format", "json")
+	if code != 0 {
+		t.Fatalf("export: code = %v", code)
+	}
+	var snapshot snapshotJSON
+	if err := json.Unmarshal([]byte(out), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Accounts) != 1 || len(snapshot.Payments) != 2 || len(snapshot.Favorites) != 1 {
+		t.Errorf("export: got %v", snapshot)
+	}
+	if snapshot.Accounts[0].Balance != 700 {
+		t.Errorf("export: balance = %v, want %v", snapshot.Accounts[0].Balance, 700)
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, 2},
+		{[]string{"unknown"}, 2},
+		{[]string{"deposit", "1"}, 2},
+		{[]string{"deposit", "1", "100"}, 1},
+		{[]string{"pay", "x", "100", "auto"}, 1},
+		{[]string{"export", "-format", "xml"}, 2},
+	}
+	for _, tt := range tests {
+		if _, code := run(t, dir, tt.args...); code != tt.want {
+			t.Errorf("wallet %v: code = %v, want %v", tt.args, code, tt.want)
+		}
+	}
+}
+
+func TestRun_squash(t *testing.T) {
+	dir := t.TempDir()
+	if _, code := run(t, dir, "account", "register", "+992000000001"); code != 0 {
+		t.Fatalf("account register: code = %v", code)
+	}
+	if _, code := run(t, dir, "deposit", "1", "1000"); code != 0 {
+		t.Fatalf("deposit: code = %v", code)
+	}
+
+	//выгружаем две дельты поверх базовой выгрузки
+	svc := &wallet.Service{}
+	if err := svc.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	since := svc.Checkpoint()
+	var deltas []string
+	for _, category := range []string{"auto", "food"} {
+		if _, err := svc.Pay(1, 100, types.PaymentCategory(category)); err != nil {
+			t.Fatal(err)
+		}
+		delta := t.TempDir()
+		upto, err := svc.ExportDelta(delta, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deltas = append(deltas, delta)
+		since = upto
+	}
+
+	out := t.TempDir()
+	if _, code := run(t, dir, append([]string{"squash", "-out", out}, deltas...)...); code != 0 {
+		t.Fatalf("squash: code = %v", code)
+	}
+	squashed := &wallet.Service{}
+	if err := squashed.Import(out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(squashed.Accounts(), svc.Accounts()) || len(squashed.Payments()) != 2 {
+		t.Errorf("squash: accounts = %v, payments = %v", squashed.Accounts(), squashed.Payments())
+	}
+
+	//дельты применяются только по порядку
+	if _, code := run(t, dir, "squash", "-out", t.TempDir(), deltas[1]); code != 1 {
+		t.Errorf("squash: code = %v, want 1", code)
+	}
+	if _, code := run(t, dir, "squash"); code != 2 {
+		t.Errorf("squash: code = %v, want 2", code)
+	}
+}
+
+func TestRun_audit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.OpenFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = log.Record(audit.Entry{Actor: "admin", Action: "deposit", AccountID: 1, Result: audit.ResultOK})
+	_, _ = log.Record(audit.Entry{Actor: "walletd", Action: "export", Result: audit.ResultOK})
+
+	out, code := run(t, dir, "audit", "verify", path)
+	if code != 0 || !strings.HasPrefix(out, "ok: 2 entries, head "+log.Head().Hash) {
+		t.Errorf("audit verify: code = %v, out = %q", code, out)
+	}
+	out, code = run(t, dir, "audit", "query", "-actor", "walletd", path)
+	if code != 0 || !strings.Contains(out, "walletd export") || strings.Contains(out, "deposit") {
+		t.Errorf("audit query: code = %v, out = %q", code, out)
+	}
+
+	// испорченный журнал не проходит проверку
+	content, _ := ioutil.ReadFile(path)
+	_ = ioutil.WriteFile(path, bytes.Replace(content, []byte("deposit"), []byte("reject"), 1), 0644)
+	if _, code = run(t, dir, "audit", "verify", path); code != 1 {
+		t.Errorf("audit verify: code = %v, want 1", code)
+	}
+
+	// как и обрезанный с конца
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	_ = ioutil.WriteFile(path, lines[0], 0644)
+	if _, code = run(t, dir, "audit", "verify", path); code != 1 {
+		t.Errorf("audit verify: code = %v, want 1", code)
+	}
+}
+
+func TestRun_auditHead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	head := filepath.Join(dir, "audit.anchor")
+
+	if _, code := run(t, dir, "account", "register", "+992000000001"); code != 0 {
+		t.Fatalf("account register: code = %v", code)
+	}
+	if _, code := run(t, dir, "-audit", path, "-audit-head", head, "deposit", "1", "100"); code != 0 {
+		t.Errorf("deposit: code = %v", code)
+	}
+	out, code := run(t, dir, "audit", "verify", "-head", head, path)
+	if code != 0 || !strings.HasPrefix(out, "ok: 1 entries") {
+		t.Errorf("audit verify: code = %v, out = %q", code, out)
+	}
+
+	// рядом с журналом головы нет, и без -head он не проходит проверку
+	if _, code = run(t, dir, "audit", "verify", path); code != 1 {
+		t.Errorf("audit verify: code = %v, want 1", code)
+	}
+}
+
+func TestRun_auditRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+
+	if _, code := run(t, dir, "account", "register", "+992000000001"); code != 0 {
+		t.Fatalf("account register: code = %v", code)
+	}
+	if _, code := run(t, dir, "-audit", path, "deposit", "1", "1000"); code != 0 {
+		t.Fatalf("deposit: code = %v", code)
+	}
+	out, code := run(t, dir, "-json", "pay", "1", "300", "auto")
+	if code != 0 {
+		t.Fatalf("pay: code = %v", code)
+	}
+	var payments []paymentJSON
+	if err := json.Unmarshal([]byte(out), &payments); err != nil || len(payments) != 1 {
+		t.Fatalf("pay: invalid output %q, error = %v", out, err)
+	}
+	if _, code = run(t, dir, "-audit", path, "reject", payments[0].ID); code != 0 {
+		t.Fatalf("reject: code = %v", code)
+	}
+	if _, code = run(t, dir, "-audit", path, "reject", payments[0].ID); code != 1 {
+		t.Fatalf("reject: code = %v, want 1", code)
+	}
+	if _, code = run(t, dir, "-audit", path, "export", "-out", t.TempDir()); code != 0 {
+		t.Fatalf("export: code = %v", code)
+	}
+
+	out, code = run(t, dir, "audit", "query", "-actor", "cli", path)
+	if code != 0 {
+		t.Fatalf("audit query: code = %v", code)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		got = append(got, fields[3]+":"+fields[len(fields)-1])
+	}
+	want := "deposit:ok reject:ok reject:failed export:ok"
+	if strings.Join(got, " ") != want {
+		t.Errorf("audit query: got %v, want %v", got, want)
+	}
+	if _, code = run(t, dir, "audit", "verify", path); code != 0 {
+		t.Errorf("audit verify: code = %v", code)
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+)
+
+type accountJSON struct {
+	ID      int64       `json:"id"`
+	Phone   types.Phone `json:"phone"`
+	Balance types.Money `json:"balance"`
+	Version int64       `json:"version"`
+}
+
+type paymentJSON struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+	Status    types.PaymentStatus   `json:"status"`
+	Version   int64                 `json:"version"`
+}
+
+type favoriteJSON struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Name      string                `json:"name"`
+	Category  types.PaymentCategory `json:"category"`
+}
+
+type snapshotJSON struct {
+	Accounts  []accountJSON  `json:"accounts"`
+	Payments  []paymentJSON  `json:"payments"`
+	Favorites []favoriteJSON `json:"favorites"`
+}
+
+func toAccountJSON(account types.Account) accountJSON {
+	return accountJSON{ID: account.ID, Phone: account.Phone, Balance: account.Balance, Version: account.Version}
+}
+
+func toPaymentJSON(payment types.Payment) paymentJSON {
+	return paymentJSON{
+		ID:        payment.ID,
+		AccountID: payment.AccountID,
+		Amount:    payment.Amount,
+		Category:  payment.Category,
+		Status:    payment.Status,
+		Version:   payment.Version,
+	}
+}
+
+func toFavoriteJSON(favorite types.Favorite) favoriteJSON {
+	return favoriteJSON{
+		ID:        favorite.ID,
+		AccountID: favorite.AccountID,
+		Amount:    favorite.Amount,
+		Name:      favorite.Name,
+		Category:  favorite.Category,
+	}
+}
+
+func snapshot(svc *wallet.Service) snapshotJSON {
+	result := snapshotJSON{
+		Accounts:  make([]accountJSON, 0),
+		Payments:  make([]paymentJSON, 0),
+		Favorites: make([]favoriteJSON, 0),
+	}
+	for _, account := range svc.Accounts() {
+		result.Accounts = append(result.Accounts, toAccountJSON(account))
+	}
+	for _, payment := range svc.Payments() {
+		result.Payments = append(result.Payments, toPaymentJSON(payment))
+	}
+	for _, favorite := range svc.Favorites() {
+		result.Favorites = append(result.Favorites, toFavoriteJSON(favorite))
+	}
+	return result
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *cli) printAccount(account types.Account) error {
+	if c.json {
+		return c.printJSON(toAccountJSON(account))
+	}
+	_, err := fmt.Fprintf(c.stdout, "account %d  phone %s  balance %d\n", account.ID, account.Phone, account.Balance)
+	return err
+}
+
+// printPayments печатает платежи; в режиме JSON всегда массивом.
+func (c *cli) printPayments(payments ...types.Payment) error {
+	if c.json {
+		result := make([]paymentJSON, 0, len(payments))
+		for _, payment := range payments {
+			result = append(result, toPaymentJSON(payment))
+		}
+		return c.printJSON(result)
+	}
+	for _, payment := range payments {
+		_, err := fmt.Fprintf(c.stdout, "payment %s  account %d  amount %d  category %s  status %s\n",
+			payment.ID, payment.AccountID, payment.Amount, payment.Category, payment.Status)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) printFavorite(favorite types.Favorite) error {
+	if c.json {
+		return c.printJSON(toFavoriteJSON(favorite))
+	}
+	_, err := fmt.Fprintf(c.stdout, "favorite %s  %q  account %d  amount %d  category %s\n",
+		favorite.ID, favorite.Name, favorite.AccountID, favorite.Amount, favorite.Category)
+	return err
+}
@@ -0,0 +1,68 @@
+package dto
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+)
+
+// Account — счёт в JSON: в ответах HTTP API и в сообщениях outbox.
+// Status счёта без статуса — ACTIVE (см. wallet.AccountStatus).
+type Account struct {
+	ID      int64               `json:"id"`
+	Phone   types.Phone         `json:"phone"`
+	Balance types.Money         `json:"balance"`
+	Version int64               `json:"version"`
+	Status  types.AccountStatus `json:"status"`
+	Reason  string              `json:"reason,omitempty"`
+}
+
+// Payment — платёж в JSON.
+type Payment struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+	Status    types.PaymentStatus   `json:"status"`
+	Version   int64                 `json:"version"`
+}
+
+// Favorite — избранное в JSON.
+type Favorite struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Name      string                `json:"name"`
+	Category  types.PaymentCategory `json:"category"`
+}
+
+func FromAccount(account types.Account) Account {
+	return Account{
+		ID:      account.ID,
+		Phone:   account.Phone,
+		Balance: account.Balance,
+		Version: account.Version,
+		Status:  wallet.AccountStatus(&account),
+		Reason:  account.StatusReason,
+	}
+}
+
+func FromPayment(payment types.Payment) Payment {
+	return Payment{
+		ID:        payment.ID,
+		AccountID: payment.AccountID,
+		Amount:    payment.Amount,
+		Category:  payment.Category,
+		Status:    payment.Status,
+		Version:   payment.Version,
+	}
+}
+
+func FromFavorite(favorite types.Favorite) Favorite {
+	return Favorite{
+		ID:        favorite.ID,
+		AccountID: favorite.AccountID,
+		Amount:    favorite.Amount,
+		Name:      favorite.Name,
+		Category:  favorite.Category,
+	}
+}
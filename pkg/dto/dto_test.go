@@ -0,0 +1,34 @@
+package dto
+
+import (
+	"encoding/json"
+	"github.com/bahrom656/wallet/pkg/types"
+	"testing"
+)
+
+func TestFromAccount(t *testing.T) {
+	//счёт без статуса показывается активным
+	account := FromAccount(types.Account{ID: 1, Phone: "+992000000001", Balance: 100, Version: 2})
+	content, err := json.Marshal(account)
+	if err != nil {
+		t.Errorf("Marshal(): error = %v", err)
+		return
+	}
+	want := `{"id":1,"phone":"+992000000001","balance":100,"version":2,"status":"ACTIVE"}`
+	if string(content) != want {
+		t.Errorf("FromAccount(): json = %s, want %s", content, want)
+	}
+}
+
+func TestFromPayment(t *testing.T) {
+	payment := FromPayment(types.Payment{ID: "p1", AccountID: 1, Amount: 100, Category: "auto", Status: types.PaymentStatusOk, Version: 1})
+	content, err := json.Marshal(payment)
+	if err != nil {
+		t.Errorf("Marshal(): error = %v", err)
+		return
+	}
+	want := `{"id":"p1","accountId":1,"amount":100,"category":"auto","status":"OK","version":1}`
+	if string(content) != want {
+		t.Errorf("FromPayment(): json = %s, want %s", content, want)
+	}
+}
@@ -0,0 +1,200 @@
+package fraud
+
+import (
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Activity — проведённый платёж или перевод счёта, о котором движок узнал
+// из событий.
+type Activity struct {
+	At       time.Time
+	RepeatOf string
+}
+
+// Rule — правило проверки платежа. recent — платежи счёта за последние
+// Engine.Retention, старые первыми, и в конце — attempt.Pending. Вместе с решением правило возвращает
+// причину, которая попадёт в wallet.Decision.
+type Rule interface {
+	Evaluate(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string)
+}
+
+// RuleFunc позволяет использовать функцию как правило.
+type RuleFunc func(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string)
+
+func (f RuleFunc) Evaluate(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string) {
+	return f(attempt, recent, now)
+}
+
+// Engine проверяет платежи набором правил и реализует wallet.FraudChecker.
+// Итоговое решение — самое строгое из решений правил. Время платежей движок
+// узнаёт из событий сервиса, поэтому Handle нужно подписать на шину:
+//
+//	svc.Events().Subscribe(engine.Handle)
+//
+// Платежи одного пакета (PayBatch) правила видят через attempt.Pending.
+// Платежи, проверяемые одновременно разными вызовами, друг друга не видят,
+// так что правила частоты могут пропустить один-два лишних платежа.
+type Engine struct {
+	// Retention — сколько помнить платежи счёта; должно быть не меньше окна
+	// самого длинного правила.
+	Retention time.Duration
+
+	rules []Rule
+	now   func() time.Time
+
+	mu       sync.Mutex
+	activity map[int64][]Activity
+}
+
+// NewEngine создаёт движок с правилами rules; платежи он помнит час.
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{
+		Retention: time.Hour,
+		rules:     rules,
+		now:       time.Now,
+		activity:  make(map[int64][]Activity),
+	}
+}
+
+// DefaultRules — правила по умолчанию: блокировать больше 10 платежей за
+// минуту и 5 повторов за 10 минут, отмечать больше 5 платежей за 10 минут,
+// платёж в 10 раз больше среднего и первый платёж в новой категории.
+func DefaultRules() []Rule {
+	return []Rule{
+		Velocity(10, time.Minute, wallet.VerdictBlock),
+		Velocity(5, 10*time.Minute, wallet.VerdictFlag),
+		RepeatLoop(5, 10*time.Minute, wallet.VerdictBlock),
+		AmountSpike(10, 3, wallet.VerdictFlag),
+		NewCategory(3, wallet.VerdictFlag),
+	}
+}
+
+func (e *Engine) Check(attempt wallet.PaymentAttempt) wallet.Decision {
+	now := e.now()
+	recent := e.recent(attempt.AccountID, now)
+	for _, pending := range attempt.Pending {
+		recent = append(recent, Activity{At: now, RepeatOf: pending.RepeatOf})
+	}
+
+	var decision wallet.Decision
+	for _, rule := range e.rules {
+		verdict, reason := rule.Evaluate(attempt, recent, now)
+		if verdict == wallet.VerdictAllow {
+			continue
+		}
+		if verdict > decision.Verdict {
+			decision.Verdict = verdict
+		}
+		decision.Reasons = append(decision.Reasons, reason)
+	}
+	return decision
+}
+
+// Handle запоминает проведённые платежи и переводы.
+func (e *Engine) Handle(event wallet.Event) {
+	var activity Activity
+	switch event := event.(type) {
+	case wallet.PaymentCreated:
+		activity.RepeatOf = event.RepeatOf
+	case wallet.TransferSent:
+	default:
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	accountID := event.AccountID()
+	activity.At = e.now()
+	e.activity[accountID] = append(e.activity[accountID], activity)
+}
+
+// recent возвращает платежи счёта за Retention и забывает более старые.
+func (e *Engine) recent(accountID int64, now time.Time) []Activity {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	activity := e.activity[accountID]
+	from := sort.Search(len(activity), func(i int) bool {
+		return now.Sub(activity[i].At) < e.Retention
+	})
+	if from == len(activity) {
+		delete(e.activity, accountID)
+		return nil
+	}
+	activity = activity[from:]
+	e.activity[accountID] = activity
+	return append([]Activity(nil), activity...)
+}
+
+// Velocity срабатывает, если за window у счёта уже было count платежей.
+func Velocity(count int, window time.Duration, verdict wallet.Verdict) Rule {
+	return RuleFunc(func(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string) {
+		if within(recent, window, now, false) >= count {
+			return verdict, "velocity: " + strconv.Itoa(count) + " payments in " + window.String()
+		}
+		return wallet.VerdictAllow, ""
+	})
+}
+
+// RepeatLoop срабатывает на повтор платежа, если за window у счёта уже было
+// count повторов.
+func RepeatLoop(count int, window time.Duration, verdict wallet.Verdict) Rule {
+	return RuleFunc(func(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string) {
+		if attempt.RepeatOf != "" && within(recent, window, now, true) >= count {
+			return verdict, "repeat loop: " + strconv.Itoa(count) + " repeats in " + window.String()
+		}
+		return wallet.VerdictAllow, ""
+	})
+}
+
+// AmountSpike срабатывает, если сумма больше средней суммы последних платежей
+// (attempt.History) в factor раз. Счета, у которых меньше minHistory платежей, не проверяются.
+func AmountSpike(factor float64, minHistory int, verdict wallet.Verdict) Rule {
+	return RuleFunc(func(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string) {
+		if len(attempt.History) < minHistory || len(attempt.History) == 0 {
+			return wallet.VerdictAllow, ""
+		}
+		total := 0.0
+		for _, payment := range attempt.History {
+			total += float64(payment.Amount)
+		}
+		average := total / float64(len(attempt.History))
+		if float64(attempt.Amount) > factor*average {
+			return verdict, "amount spike: more than " + strconv.FormatFloat(factor, 'g', -1, 64) + "x average"
+		}
+		return wallet.VerdictAllow, ""
+	})
+}
+
+// NewCategory срабатывает на первый платёж счёта в категории. Счета, у
+// которых меньше minHistory платежей, не проверяются: у нового счёта любая
+// категория первая. Переводы, у которых нет категории, тоже.
+func NewCategory(minHistory int, verdict wallet.Verdict) Rule {
+	return RuleFunc(func(attempt wallet.PaymentAttempt, recent []Activity, now time.Time) (wallet.Verdict, string) {
+		if attempt.To != 0 || len(attempt.History) < minHistory {
+			return wallet.VerdictAllow, ""
+		}
+		for _, payment := range attempt.History {
+			if payment.Category == attempt.Category {
+				return wallet.VerdictAllow, ""
+			}
+		}
+		return verdict, "first payment in category " + string(attempt.Category)
+	})
+}
+
+// within считает платежи (или только повторы) за window до now.
+func within(recent []Activity, window time.Duration, now time.Time, repeats bool) int {
+	count := 0
+	for _, activity := range recent {
+		if now.Sub(activity.At) < window && (!repeats || activity.RepeatOf != "") {
+			count++
+		}
+	}
+	return count
+}
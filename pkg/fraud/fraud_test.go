@@ -0,0 +1,177 @@
+package fraud
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"testing"
+	"time"
+)
+
+// newTestEngine создаёт движок с часами, которые двигает тест.
+func newTestEngine(rules ...Rule) (*Engine, *time.Time) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	engine := NewEngine(rules...)
+	engine.now = func() time.Time { return now }
+	return engine, &now
+}
+
+func history(amounts ...types.Money) []types.Payment {
+	result := make([]types.Payment, 0, len(amounts))
+	for _, amount := range amounts {
+		result = append(result, types.Payment{AccountID: 1, Amount: amount, Category: "auto"})
+	}
+	return result
+}
+
+func TestEngine_velocity(t *testing.T) {
+	engine, now := newTestEngine(Velocity(3, time.Minute, wallet.VerdictBlock))
+	attempt := wallet.PaymentAttempt{AccountID: 1, Amount: 100, Category: "auto"}
+
+	for i := 0; i < 3; i++ {
+		if decision := engine.Check(attempt); decision.Verdict != wallet.VerdictAllow {
+			t.Errorf("Check(): payment %v, decision = %v", i, decision)
+		}
+		engine.Handle(wallet.PaymentCreated{Payment: types.Payment{AccountID: 1}})
+	}
+	decision := engine.Check(attempt)
+	if decision.Verdict != wallet.VerdictBlock || len(decision.Reasons) != 1 {
+		t.Errorf("Check(): decision = %v, want block", decision)
+	}
+
+	//другой счёт и платежи после окна не затрагиваются
+	if decision := engine.Check(wallet.PaymentAttempt{AccountID: 2}); decision.Verdict != wallet.VerdictAllow {
+		t.Errorf("Check(): other account decision = %v", decision)
+	}
+	*now = now.Add(time.Minute)
+	if decision := engine.Check(attempt); decision.Verdict != wallet.VerdictAllow {
+		t.Errorf("Check(): after window decision = %v", decision)
+	}
+}
+
+func TestEngine_repeatLoop(t *testing.T) {
+	engine, _ := newTestEngine(RepeatLoop(2, time.Minute, wallet.VerdictBlock))
+	engine.Handle(wallet.PaymentCreated{Payment: types.Payment{AccountID: 1}})
+	engine.Handle(wallet.PaymentCreated{Payment: types.Payment{AccountID: 1}, RepeatOf: "a"})
+
+	repeat := wallet.PaymentAttempt{AccountID: 1, RepeatOf: "a"}
+	if decision := engine.Check(repeat); decision.Verdict != wallet.VerdictAllow {
+		t.Errorf("Check(): decision = %v", decision)
+	}
+	engine.Handle(wallet.PaymentCreated{Payment: types.Payment{AccountID: 1}, RepeatOf: "a"})
+	if decision := engine.Check(repeat); decision.Verdict != wallet.VerdictBlock {
+		t.Errorf("Check(): decision = %v, want block", decision)
+	}
+	//обычный платёж не считается повтором
+	if decision := engine.Check(wallet.PaymentAttempt{AccountID: 1}); decision.Verdict != wallet.VerdictAllow {
+		t.Errorf("Check(): decision = %v", decision)
+	}
+}
+
+func TestEngine_history(t *testing.T) {
+	engine, _ := newTestEngine(AmountSpike(5, 3, wallet.VerdictFlag), NewCategory(3, wallet.VerdictFlag))
+
+	tests := []struct {
+		name    string
+		attempt wallet.PaymentAttempt
+		want    wallet.Verdict
+		reasons int
+	}{
+		{"usual", wallet.PaymentAttempt{Amount: 150, Category: "auto", History: history(100, 100, 200)}, wallet.VerdictAllow, 0},
+		{"spike", wallet.PaymentAttempt{Amount: 1_000, Category: "auto", History: history(100, 100, 200)}, wallet.VerdictFlag, 1},
+		{"new category", wallet.PaymentAttempt{Amount: 100, Category: "food", History: history(100, 100, 200)}, wallet.VerdictFlag, 1},
+		{"both", wallet.PaymentAttempt{Amount: 1_000, Category: "food", History: history(100, 100, 200)}, wallet.VerdictFlag, 2},
+		{"short history", wallet.PaymentAttempt{Amount: 1_000, Category: "food", History: history(100)}, wallet.VerdictAllow, 0},
+	}
+	for _, tt := range tests {
+		decision := engine.Check(tt.attempt)
+		if decision.Verdict != tt.want || len(decision.Reasons) != tt.reasons {
+			t.Errorf("Check(%s): decision = %v, want %v with %v reasons", tt.name, decision, tt.want, tt.reasons)
+		}
+	}
+}
+
+func TestEngine_service(t *testing.T) {
+	//подключаем движок к Сервису
+	svc := &wallet.Service{}
+	engine := NewEngine(Velocity(2, time.Minute, wallet.VerdictBlock))
+	svc.Events().Subscribe(engine.Handle)
+	svc.SetFraudChecker(engine)
+
+	account, _ := svc.RegisterAccount("+992000000001")
+	_ = svc.Deposit(account.ID, 1_000)
+	payment, err := svc.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = svc.Repeat(payment.ID)
+	if err != nil {
+		t.Errorf("Repeat(): error = %v", err)
+	}
+	_, err = svc.Pay(account.ID, 100, "auto")
+	if err != wallet.ErrPaymentBlocked {
+		t.Errorf("Pay(): error = %v, want %v", err, wallet.ErrPaymentBlocked)
+	}
+}
+
+func TestEngine_service_transfer(t *testing.T) {
+	//переводы считаются правилами частоты, но не правилом новой категории
+	svc := &wallet.Service{}
+	engine := NewEngine(Velocity(2, time.Minute, wallet.VerdictBlock), NewCategory(1, wallet.VerdictBlock))
+	svc.Events().Subscribe(engine.Handle)
+	svc.SetFraudChecker(engine)
+
+	first, _ := svc.RegisterAccount("+992000000001")
+	second, _ := svc.RegisterAccount("+992000000002")
+	_ = svc.Deposit(first.ID, 1_000)
+	_, err := svc.Pay(first.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	err = svc.Transfer(first.ID, second.ID, 100)
+	if err != nil {
+		t.Errorf("Transfer(): error = %v", err)
+	}
+	err = svc.Transfer(first.ID, second.ID, 100)
+	if err != wallet.ErrPaymentBlocked {
+		t.Errorf("Transfer(): error = %v, want %v", err, wallet.ErrPaymentBlocked)
+	}
+}
+
+func TestEngine_service_batch(t *testing.T) {
+	//платежи пакета считаются правилами частоты
+	svc := &wallet.Service{}
+	engine := NewEngine(Velocity(2, time.Minute, wallet.VerdictBlock))
+	svc.Events().Subscribe(engine.Handle)
+	svc.SetFraudChecker(engine)
+
+	first, _ := svc.RegisterAccount("+992000000001")
+	second, _ := svc.RegisterAccount("+992000000002")
+	_ = svc.Deposit(first.ID, 1_000)
+	_ = svc.Deposit(second.ID, 1_000)
+	results, _ := svc.PayBatch([]wallet.PaymentRequest{
+		{AccountID: first.ID, Amount: 100, Category: "auto"},
+		{AccountID: second.ID, Amount: 100, Category: "auto"},
+		{AccountID: first.ID, Amount: 100, Category: "auto"},
+		{AccountID: first.ID, Amount: 100, Category: "auto"},
+	}, wallet.BatchBestEffort)
+	for i, want := range []error{nil, nil, nil, wallet.ErrPaymentBlocked} {
+		if results[i].Err != want {
+			t.Errorf("PayBatch(): result %v error = %v, want %v", i, results[i].Err, want)
+		}
+	}
+}
+
+func TestEngine_pending(t *testing.T) {
+	engine, _ := newTestEngine(RepeatLoop(2, time.Minute, wallet.VerdictBlock))
+	repeat := wallet.PaymentAttempt{AccountID: 1, RepeatOf: "a"}
+	engine.Handle(wallet.PaymentCreated{Payment: types.Payment{AccountID: 1}, RepeatOf: "a"})
+	if decision := engine.Check(repeat); decision.Verdict != wallet.VerdictAllow {
+		t.Errorf("Check(): decision = %v", decision)
+	}
+	repeat.Pending = []wallet.PaymentAttempt{{AccountID: 1, RepeatOf: "a"}}
+	if decision := engine.Check(repeat); decision.Verdict != wallet.VerdictBlock {
+		t.Errorf("Check(): decision = %v, want block", decision)
+	}
+}
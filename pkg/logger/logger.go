@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level — уровень важности записи.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel разбирает уровень по имени: debug, info, warn или error.
+func ParseLevel(name string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if l.String() == strings.ToLower(name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Field — поле записи.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key string, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+func AccountID(id int64) Field {
+	return Int64("account_id", id)
+}
+
+func PaymentID(id string) Field {
+	return String("payment_id", id)
+}
+
+// Phone — номер телефона; в записи он всегда маскируется (см. Redact).
+func Phone(phone string) Field {
+	return String("phone", phone)
+}
+
+// sensitive — поля, значения которых маскируются при записи.
+var sensitive = map[string]bool{"phone": true}
+
+// Redact маскирует середину значения, оставляя первые четыре и последние два символа.
+func Redact(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 6 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:4]) + strings.Repeat("*", len(runes)-6) + string(runes[len(runes)-2:])
+}
+
+// Logger пишет записи в формате logfmt: time=... level=... msg=... ключ=значение.
+// Методы безопасно вызывать у nil — такой логгер ничего не пишет.
+type Logger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  Level
+	fields []Field
+	now    func() time.Time
+}
+
+// New создаёт логгер, пишущий в w записи уровня level и выше.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, w: w, level: level, now: time.Now}
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo)
+var defaultMu sync.RWMutex
+
+// Default возвращает логгер для компонентов, которым логгер не задан.
+// По умолчанию он пишет в stderr записи уровня info и выше.
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	return defaultLogger
+}
+
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultLogger = l
+}
+
+// With возвращает логгер, добавляющий fields к каждой записи.
+func (l *Logger) With(fields ...Field) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := *l
+	child.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &child
+}
+
+// Enabled сообщает, будут ли записаны записи уровня level.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.log(LevelDebug, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.log(LevelInfo, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.log(LevelWarn, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.log(LevelError, msg, fields)
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("time=" + l.now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(" level=" + level.String())
+	b.WriteString(" msg=" + quote(msg))
+	for _, field := range l.fields {
+		writeField(&b, field)
+	}
+	for _, field := range fields {
+		writeField(&b, field)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+func writeField(b *strings.Builder, field Field) {
+	value := fmt.Sprint(field.Value)
+	if sensitive[field.Key] {
+		value = Redact(value)
+	}
+	b.WriteString(" " + field.Key + "=" + quote(value))
+}
+
+// quote заключает значение в кавычки, если в нём есть пробелы, кавычки или знак =.
+func quote(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
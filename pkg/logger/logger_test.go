@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf, level)
+	l.now = func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestLogger_fields(t *testing.T) {
+	l, buf := newTestLogger(LevelInfo)
+	l.With(AccountID(7)).Error("pay failed", PaymentID("p-1"), Err(errors.New("not enough balance")), Phone("+992900123456"))
+
+	want := `time=2021-01-02T03:04:05Z level=error msg="pay failed" account_id=7 payment_id=p-1 error="not enough balance" phone=+992*******56` + "\n"
+	if buf.String() != want {
+		t.Errorf("Error(): got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLogger_level(t *testing.T) {
+	l, buf := newTestLogger(LevelWarn)
+	l.Debug("debug")
+	l.Info("info")
+	if buf.Len() != 0 {
+		t.Errorf("Info(): records below level must be skipped, got %q", buf.String())
+	}
+	l.Warn("warn")
+	if buf.Len() == 0 {
+		t.Error("Warn(): record must be written")
+	}
+}
+
+func TestLogger_nil(t *testing.T) {
+	var l *Logger
+	l.With(AccountID(1)).Error("nothing happens")
+	if l.Enabled(LevelError) {
+		t.Error("Enabled(): nil logger must be disabled")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Errorf("ParseLevel(): got %v, %v", level, err)
+	}
+	_, err = ParseLevel("verbose")
+	if err == nil {
+		t.Error("ParseLevel(): must return error for unknown level")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := map[string]string{
+		"+992900123456": "+992*******56",
+		"12345":         "*****",
+	}
+	for value, want := range tests {
+		if got := Redact(value); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets — границы корзин гистограммы длительности в секундах по умолчанию.
+var DefBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Registry хранит метрики и отдаёт их в текстовом формате Prometheus.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter регистрирует счётчик с метками labels.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, labels)}
+	r.register(c)
+	return c
+}
+
+// NewHistogram регистрирует гистограмму с корзинами buckets (по возрастанию) и метками labels.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{family: newFamily(name, help, labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// NewGaugeFunc регистрирует показатель, значение которого вычисляет fn при каждом чтении.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(w)
+}
+
+// family — общая часть метрик с метками: значения хранятся по набору значений меток.
+type family struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func newFamily(name string, help string, labels []string) family {
+	return family{name: name, help: help, labels: labels, series: make(map[string]*series)}
+}
+
+// get вызывается под f.mu.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic("metrics: " + f.name + ": wrong number of label values")
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted вызывается под f.mu и возвращает серии в порядке значений меток.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, f.series[key])
+	}
+	return result
+}
+
+func (f *family) header(w *bufio.Writer, kind string) {
+	_, _ = w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	_, _ = w.WriteString("# TYPE " + f.name + " " + kind + "\n")
+}
+
+// Counter — монотонно растущий счётчик.
+type Counter struct {
+	family
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(labelValues).value += value
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, s := range c.sorted() {
+		writeSample(w, c.name, c.labels, s.labels, "", "", s.value)
+	}
+}
+
+// Histogram считает наблюдения по корзинам, их сумму и число.
+type Histogram struct {
+	family
+	buckets []float64
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+	for _, s := range h.sorted() {
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labels, "", "", s.value)
+		writeSample(w, h.name+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	_, _ = w.WriteString("# HELP " + g.name + " " + escapeHelp(g.help) + "\n")
+	_, _ = w.WriteString("# TYPE " + g.name + " gauge\n")
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraLabel string, extraValue string, value float64) {
+	_, _ = w.WriteString(name)
+	if len(labels) != 0 || extraLabel != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for i, label := range labels {
+			pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+		}
+		if extraLabel != "" {
+			pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+		}
+		_, _ = w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	_, _ = w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Number of requests.", "method", "code")
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+	registry.NewGaugeFunc("queue_length", "Queue length.", func() float64 { return 3 })
+
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", `5"0\0`)
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(2, "GET")
+
+	var buf bytes.Buffer
+	err := registry.WriteText(&buf)
+	if err != nil {
+		t.Errorf("WriteText(): error = %v", err)
+		return
+	}
+	want := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",code="200"} 3
+requests_total{method="POST",code="5\"0\\0"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 3
+latency_seconds_sum{method="GET"} 2.55
+latency_seconds_count{method="GET"} 3
+# HELP queue_length Queue length.
+# TYPE queue_length gauge
+queue_length 3
+`
+	if buf.String() != want {
+		t.Errorf("WriteText():\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("events_total", "Number of events.").Inc()
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("ServeHTTP(): Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "events_total 1\n") {
+		t.Errorf("ServeHTTP(): body = %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,256 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bahrom656/wallet/pkg/dto"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cursorFile хранит номер последнего опубликованного события.
+const cursorFile = "outbox.cursor"
+
+// Message — сообщение о событии для внешних потребителей. ID у повторно
+// отправленного сообщения тот же, по нему потребитель отбрасывает дубликаты.
+type Message struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	AccountID int64           `json:"accountId"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// Publisher доставляет сообщения потребителям, например в брокер очередей.
+type Publisher interface {
+	Publish(ctx context.Context, message Message) error
+}
+
+// Relay публикует события из журнала wallet.EventStore. Журнал и есть outbox:
+// событие попадает в него той же записью, которой сохраняется изменение
+// состояния (см. wallet.Service.SetJournal), поэтому падение процесса после
+// Pay событие не теряет.
+//
+// Номер последнего опубликованного события (курсор) хранится в файле
+// outbox.cursor каталога fsys и сохраняется после каждой публикации. Если
+// процесс упал между публикацией и сохранением курсора, событие будет
+// опубликовано ещё раз с тем же ID (доставка «хотя бы один раз»).
+type Relay struct {
+	store     *wallet.EventStore
+	publisher Publisher
+	fsys      wallet.FS
+
+	mu     sync.Mutex
+	cursor int64
+	wake   chan struct{}
+	logger *logger.Logger
+}
+
+// NewRelay создаёт ретранслятор и читает сохранённый курсор.
+func NewRelay(store *wallet.EventStore, publisher Publisher, fsys wallet.FS) (*Relay, error) {
+	cursor, err := loadCursor(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Relay{
+		store:     store,
+		publisher: publisher,
+		fsys:      fsys,
+		cursor:    cursor,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+// SetLogger задаёт логгер для ошибок Run; без него используется logger.Default().
+func (r *Relay) SetLogger(l *logger.Logger) {
+	r.logger = l
+}
+
+// Cursor возвращает номер последнего опубликованного события.
+func (r *Relay) Cursor() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cursor
+}
+
+// Flush публикует по порядку все события после курсора и возвращает их число.
+// События wallet.SecondFactorChanged не публикуются, но курсор их проходит.
+// На первой ошибке публикация останавливается, чтобы не нарушить порядок.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events, err := r.store.Events(r.cursor+1, r.store.Len())
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		number := r.cursor + 1
+		// секреты вторых факторов не покидают журнал
+		if _, ok := event.(wallet.SecondFactorChanged); !ok {
+			message, err := NewMessage(number, event)
+			if err != nil {
+				return i, err
+			}
+			err = r.publisher.Publish(ctx, message)
+			if err != nil {
+				return i, err
+			}
+		}
+		err = saveCursor(r.fsys, number)
+		if err != nil {
+			return i, err
+		}
+		r.cursor = number
+	}
+	return len(events), nil
+}
+
+// Handle будит Run после записи нового события. События операции попадают
+// в журнал до её завершения (см. wallet.Service.SetJournal), поэтому к вызову
+// Handle событие уже в журнале.
+func (r *Relay) Handle(wallet.Event) {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run публикует события по мере появления (см. Handle), но не реже чем
+// раз в interval, пока не отменён ctx. Ошибки публикации пишутся в лог,
+// и попытка повторяется на следующем шаге.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			lg := r.logger
+			if lg == nil {
+				lg = logger.Default()
+			}
+			lg.Error("relay events", logger.Int64("cursor", r.Cursor()), logger.Err(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+type bodyDTO struct {
+	Account      *dto.Account        `json:"account,omitempty"`
+	Amount       types.Money         `json:"amount,omitempty"`
+	Payment      *dto.Payment        `json:"payment,omitempty"`
+	RepeatOf     string              `json:"repeatOf,omitempty"`
+	FavoriteID   string              `json:"favoriteId,omitempty"`
+	Favorite     *dto.Favorite       `json:"favorite,omitempty"`
+	From         types.AccountStatus `json:"from,omitempty"`
+	Counterparty int64               `json:"counterparty,omitempty"`
+}
+
+// NewMessage строит сообщение о событии с номером number журнала.
+func NewMessage(number int64, event wallet.Event) (Message, error) {
+	var body bodyDTO
+	var account *types.Account
+	var payment *types.Payment
+	switch e := event.(type) {
+	case wallet.AccountRegistered:
+		account = &e.Account
+	case wallet.Deposited:
+		account = &e.Account
+		body.Amount = e.Amount
+	case wallet.TransferSent:
+		account = &e.Account
+		body.Amount = e.Amount
+		body.Counterparty = e.To
+	case wallet.TransferReceived:
+		account = &e.Account
+		body.Amount = e.Amount
+		body.Counterparty = e.From
+	case wallet.AccountUpdated:
+		account = &e.Account
+	case wallet.PaymentCreated:
+		payment = &e.Payment
+		body.RepeatOf = e.RepeatOf
+		body.FavoriteID = e.FavoriteID
+	case wallet.PaymentRejected:
+		payment = &e.Payment
+	case wallet.PaymentUpdated:
+		payment = &e.Payment
+	case wallet.FavoriteCreated:
+		favorite := dto.FromFavorite(e.Favorite)
+		body.Favorite = &favorite
+	case wallet.AccountStatusChanged:
+		account = &e.Account
+		body.From = e.From
+	default:
+		return Message{}, wallet.ErrUnknownEvent
+	}
+	if account != nil {
+		result := dto.FromAccount(*account)
+		body.Account = &result
+	}
+	if payment != nil {
+		result := dto.FromPayment(*payment)
+		body.Payment = &result
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		ID:        strconv.FormatInt(number, 10),
+		Type:      wallet.EventName(event),
+		AccountID: event.AccountID(),
+		Body:      raw,
+	}, nil
+}
+
+func loadCursor(fsys wallet.FS) (int64, error) {
+	file, err := fsys.Open(cursorFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := strconv.ParseInt(strings.TrimSuffix(string(content), "|"), 10, 64)
+	if err != nil {
+		return 0, wallet.ErrInvalidDump
+	}
+	return cursor, nil
+}
+
+func saveCursor(fsys wallet.FS, cursor int64) error {
+	file, err := fsys.Create(cursorFile)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte(strconv.FormatInt(cursor, 10) + "|"))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
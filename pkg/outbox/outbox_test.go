@@ -0,0 +1,219 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errBrokerDown = errors.New("broker is down")
+
+// flakyPublisher не принимает сообщения, пока down равен true.
+type flakyPublisher struct {
+	MemoryPublisher
+	down bool
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, message Message) error {
+	if p.down {
+		return errBrokerDown
+	}
+	return p.MemoryPublisher.Publish(ctx, message)
+}
+
+func newTestService(t *testing.T) (*wallet.Service, *wallet.EventStore, int64) {
+	//создаем Сервис, события которого пишутся в журнал
+	svc := &wallet.Service{}
+	store := wallet.NewEventStore(nil)
+	svc.SetJournal(store)
+
+	account, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Deposit(account.ID, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, store, account.ID
+}
+
+func TestRelay_Flush(t *testing.T) {
+	svc, store, accountID := newTestService(t)
+	publisher := &flakyPublisher{down: true}
+	fsys := &wallet.MemFS{}
+	relay, err := NewRelay(store, publisher, fsys)
+	if err != nil {
+		t.Errorf("NewRelay(): error = %v", err)
+		return
+	}
+
+	payment, err := svc.Pay(accountID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//брокер недоступен — событие остаётся в журнале
+	_, err = relay.Flush(context.Background())
+	if err != errBrokerDown {
+		t.Errorf("Flush(): must return publisher error, returned = %v", err)
+	}
+	if relay.Cursor() != 0 {
+		t.Errorf("Flush(): cursor = %v, want 0", relay.Cursor())
+	}
+
+	publisher.down = false
+	n, err := relay.Flush(context.Background())
+	if err != nil {
+		t.Errorf("Flush(): error = %v", err)
+		return
+	}
+	if n != 3 || relay.Cursor() != 3 {
+		t.Errorf("Flush(): published %v, cursor %v, want 3 and 3", n, relay.Cursor())
+	}
+
+	messages := publisher.Messages()
+	if len(messages) != 3 || messages[2].ID != "3" || messages[2].Type != "payment.created" || messages[2].AccountID != accountID {
+		t.Errorf("Flush(): messages = %v", messages)
+		return
+	}
+	var body struct {
+		Payment struct {
+			ID     string `json:"id"`
+			Amount int64  `json:"amount"`
+		} `json:"payment"`
+	}
+	err = json.Unmarshal(messages[2].Body, &body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if body.Payment.ID != payment.ID || body.Payment.Amount != 1_000 {
+		t.Errorf("Flush(): body = %s", messages[2].Body)
+	}
+}
+
+func TestRelay_Flush_redeliveryAfterCrash(t *testing.T) {
+	svc, store, accountID := newTestService(t)
+	publisher := &MemoryPublisher{}
+	fsys := &wallet.MemFS{}
+	relay, err := NewRelay(store, publisher, fsys)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = relay.Flush(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//курсор последнего события не успели сохранить
+	_, err = svc.Pay(accountID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = relay.Flush(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = saveCursor(fsys, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	restarted, err := NewRelay(store, publisher, fsys)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if restarted.Cursor() != 2 {
+		t.Errorf("NewRelay(): cursor = %v, want 2", restarted.Cursor())
+	}
+	_, err = restarted.Flush(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if publisher.Published() != 4 || len(publisher.Messages()) != 3 {
+		t.Errorf("Flush(): published %v, unique %v, want 4 and 3", publisher.Published(), len(publisher.Messages()))
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	svc, store, accountID := newTestService(t)
+	var out bytes.Buffer
+	publisher := NewFilePublisher(&out)
+	relay, err := NewRelay(store, publisher, &wallet.MemFS{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	svc.Events().Subscribe(relay.Handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx, time.Hour)
+	}()
+
+	_, err = svc.Pay(accountID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for relay.Cursor() != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"type":"payment.created"`) {
+		t.Errorf("Run(): published %q", out.String())
+	}
+}
+
+func TestRelay_Flush_secondFactor(t *testing.T) {
+	//смена PIN попадает в журнал, но не публикуется
+	svc, store, accountID := newTestService(t)
+	publisher := &MemoryPublisher{}
+	relay, err := NewRelay(store, publisher, &wallet.MemFS{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = svc.SetPIN(accountID, "", "1234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = svc.Pay(accountID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = relay.Flush(context.Background())
+	if err != nil {
+		t.Errorf("Flush(): error = %v", err)
+		return
+	}
+	if relay.Cursor() != 4 {
+		t.Errorf("Flush(): cursor = %v, want 4", relay.Cursor())
+	}
+	messages := publisher.Messages()
+	if len(messages) != 3 || messages[2].ID != "4" || messages[2].Type != "payment.created" {
+		t.Errorf("Flush(): messages = %v", messages)
+	}
+}
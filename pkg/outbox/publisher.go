@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// MemoryPublisher хранит сообщения в памяти и, как добросовестный потребитель,
+// отбрасывает повторы по ID. Подходит для тестов.
+type MemoryPublisher struct {
+	mu        sync.Mutex
+	messages  []Message
+	seen      map[string]bool
+	published int
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, message Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.published++
+	if p.seen[message.ID] {
+		return nil
+	}
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+	p.seen[message.ID] = true
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// Messages возвращает полученные сообщения без повторов.
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Message(nil), p.messages...)
+}
+
+// Published возвращает число вызовов Publish, включая повторы.
+func (p *MemoryPublisher) Published() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.published
+}
+
+// FilePublisher дописывает сообщения в w по одному JSON на строку.
+// Повторы он не отбрасывает — это дело читателя файла.
+type FilePublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFilePublisher(w io.Writer) *FilePublisher {
+	return &FilePublisher{w: w}
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, message Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
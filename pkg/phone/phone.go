@@ -0,0 +1,107 @@
+package phone
+
+import (
+	"errors"
+	"github.com/bahrom656/wallet/pkg/types"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// Country — правила номеров страны.
+type Country struct {
+	// Region — код страны ISO 3166-1, например TJ.
+	Region string
+	// Code — телефонный код страны без +, например 992.
+	Code string
+	// NationalLength — число цифр номера после кода страны.
+	NationalLength int
+}
+
+// Tajikistan — номера Таджикистана: +992 и девять цифр.
+var Tajikistan = Country{Region: "TJ", Code: "992", NationalLength: 9}
+
+// countries — страны с известными правилами. Номера других стран
+// проверяются только по общим правилам E.164.
+var countries = []Country{Tajikistan}
+
+// DefaultCountry — страна номеров, записанных без кода страны.
+var DefaultCountry = Tajikistan
+
+// Number — разобранный номер.
+type Number struct {
+	// CountryCode — код страны без +.
+	CountryCode string
+	// National — номер без кода страны.
+	National string
+}
+
+// E164 возвращает номер в формате E.164: + и до 15 цифр без разделителей.
+func (n Number) E164() types.Phone {
+	return types.Phone("+" + n.CountryCode + n.National)
+}
+
+// Parse разбирает номер в международном (+992 900 00 00 01, 00992…,
+// 992…) или национальном (900 00 00 01, для DefaultCountry) формате.
+// Пробелы, дефисы, точки и скобки между цифрами допускаются.
+func Parse(raw string) (Number, error) {
+	digits, international, ok := clean(raw)
+	if !ok {
+		return Number{}, ErrInvalidPhone
+	}
+
+	if !international {
+		if strings.HasPrefix(digits, "00") {
+			digits = digits[2:]
+			international = true
+		} else if len(digits) == DefaultCountry.NationalLength {
+			return Number{CountryCode: DefaultCountry.Code, National: digits}, nil
+		}
+	}
+
+	for _, country := range countries {
+		if !strings.HasPrefix(digits, country.Code) {
+			continue
+		}
+		national := digits[len(country.Code):]
+		if len(national) != country.NationalLength {
+			return Number{}, ErrInvalidPhone
+		}
+		return Number{CountryCode: country.Code, National: national}, nil
+	}
+
+	// без + и без известного кода страны номер не разобрать
+	if !international || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return Number{}, ErrInvalidPhone
+	}
+	// код страны неизвестен, поэтому он не отделяется от номера
+	return Number{National: digits}, nil
+}
+
+// Normalize приводит номер к формату E.164 (см. Parse).
+func Normalize(raw types.Phone) (types.Phone, error) {
+	number, err := Parse(string(raw))
+	if err != nil {
+		return "", err
+	}
+	return number.E164(), nil
+}
+
+// clean убирает разделители и возвращает цифры номера и признак ведущего +.
+func clean(raw string) (string, bool, bool) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+	raw = strings.TrimPrefix(raw, "+")
+
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false, false
+		}
+	}
+	return b.String(), international, b.Len() != 0
+}
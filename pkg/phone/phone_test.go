@@ -0,0 +1,45 @@
+package phone
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  types.Phone
+		want types.Phone
+		err  error
+	}{
+		{"+992900000001", "+992900000001", nil},
+		{"+992 900 00 00 01", "+992900000001", nil},
+		{"992900000001", "+992900000001", nil},
+		{"00992 (90) 000-00-01", "+992900000001", nil},
+		{"900.00.00.01", "+992900000001", nil},
+		{" +7 999 123-45-67 ", "+79991234567", nil},
+		{"+992 90 000 00 0", "", ErrInvalidPhone},
+		{"+99290000000100", "", ErrInvalidPhone},
+		{"79991234567", "", ErrInvalidPhone},
+		{"+0123456789", "", ErrInvalidPhone},
+		{"+992-900-abc", "", ErrInvalidPhone},
+		{"9+92900000001", "", ErrInvalidPhone},
+		{"", "", ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", tt.raw, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	number, err := Parse("+992 93 555 44 33")
+	if err != nil {
+		t.Errorf("Parse(): error = %v", err)
+		return
+	}
+	if number.CountryCode != Tajikistan.Code || number.National != "935554433" {
+		t.Errorf("Parse(): number = %v", number)
+	}
+}
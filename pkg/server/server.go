@@ -0,0 +1,705 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/auth"
+	"github.com/bahrom656/wallet/pkg/dto"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/phone"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidID = errors.New("invalid id")
+var errInvalidBody = errors.New("invalid request body")
+var errInvalidQuery = errors.New("invalid query parameter")
+var errNotFound = errors.New("not found")
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// Server отдаёт операции wallet.Service по HTTP в виде JSON REST API.
+type Server struct {
+	svc     *wallet.Service
+	dir     string
+	logger  *logger.Logger
+	auth    *auth.Authenticator
+	guard   *auth.Guard
+	audit   *audit.Log
+	metrics http.Handler
+}
+
+// NewServer создаёт сервер поверх svc. В каталог dir выгружаются данные по POST /export.
+func NewServer(svc *wallet.Service, dir string) *Server {
+	return &Server{svc: svc, dir: dir, guard: auth.NewGuard(svc, nil)}
+}
+
+// SetLogger задаёт логгер для ошибок сервера и отказов в доступе;
+// без него используется logger.Default().
+func (s *Server) SetLogger(l *logger.Logger) {
+	s.logger = l
+	s.guard = auth.NewGuard(s.svc, l)
+	s.guard.SetAuditLog(s.audit)
+}
+
+// SetAuditLog включает журнал аудита привилегированных действий (см. auth.Guard)
+// и выборку из него по GET /audit.
+func (s *Server) SetAuditLog(log *audit.Log) {
+	s.audit = log
+	s.guard.SetAuditLog(log)
+}
+
+// SetMetrics отдаёт метрики handler по GET /metrics. В метриках есть общий
+// баланс счетов, поэтому они доступны только администратору.
+func (s *Server) SetMetrics(handler http.Handler) {
+	s.metrics = handler
+}
+
+// SetAuth включает проверку ключа API или токена из заголовка
+// Authorization: Bearer. Без неё каждый запрос выполняется с правами
+// администратора.
+func (s *Server) SetAuth(a *auth.Authenticator) {
+	s.auth = a
+}
+
+func (s *Server) principal(r *http.Request) (auth.Principal, error) {
+	if s.auth == nil {
+		return auth.Admin, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	credential := strings.TrimPrefix(header, "Bearer ")
+	if credential == header || credential == "" {
+		return auth.Principal{}, auth.ErrUnauthenticated
+	}
+	return s.auth.Authenticate(credential)
+}
+
+func (s *Server) log() *logger.Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+	return logger.Default()
+}
+
+type challengeDTO struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+	Factor    wallet.Factor         `json:"factor"`
+	ExpiresAt time.Time             `json:"expiresAt"`
+}
+
+type reviewDTO struct {
+	Payment dto.Payment `json:"payment"`
+	Reasons []string    `json:"reasons"`
+}
+
+type tokenDTO struct {
+	Token     string `json:"token"`
+	ExpiresIn int64  `json:"expiresIn"`
+}
+
+type errorDTO struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP проверяет вызывающего (см. SetAuth), разбирает путь запроса и
+// вызывает нужный обработчик. Регистрация счёта, выгрузка, очередь проверки
+// платежей, выпуск токенов, журнал аудита и метрики доступны только администратору, остальные операции — ещё и владельцу счёта.
+// Статус счёта владелец может сменить только на FROZEN.
+// Платёж выше порога подтверждения не проводится сразу: в ответ 202 приходит
+// проверка, которую подтверждают через /challenges/{id}/confirm:
+//
+//	POST /accounts                    {"phone"}
+//	GET  /accounts/{id}
+//	POST /accounts/{id}/deposit       {"amount"}
+//	POST /accounts/{id}/payments      {"amount", "category"}
+//	GET  /accounts/{id}/payments
+//	POST /accounts/{id}/pin           {"current", "pin"}
+//	POST /accounts/{id}/totp
+//	POST /accounts/{id}/status        {"status", "reason"}
+//	GET  /payments/{id}
+//	POST /payments/{id}/reject
+//	POST /payments/{id}/repeat
+//	POST /payments/{id}/favorite      {"name"}
+//	GET  /favorites/{id}
+//	POST /favorites/{id}/pay
+//	POST /challenges/{id}/confirm     {"code"}
+//	POST /export
+//	GET  /export/{accounts|payments|favorites}
+//	GET  /reviews
+//	POST /reviews/{id}/{approve|reject}
+//	POST /tokens                      {"accountId", "admin", "ttl"}
+//	GET  /audit?actor=&account=&from=&to=
+//	GET  /metrics
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := s.principal(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + parts[0]
+	if len(parts) > 1 {
+		route += "/{id}"
+	}
+	if len(parts) > 2 {
+		route += "/" + strings.Join(parts[2:], "/")
+	}
+	if parts[0] == "export" && len(parts) == 2 {
+		route = r.Method + " export/" + parts[1]
+	}
+
+	switch route {
+	case "POST accounts":
+		s.handleRegisterAccount(w, r, p)
+	case "GET accounts/{id}":
+		s.handleAccount(w, r, p, parts[1])
+	case "POST accounts/{id}/deposit":
+		s.handleDeposit(w, r, p, parts[1])
+	case "POST accounts/{id}/payments":
+		s.handlePay(w, r, p, parts[1])
+	case "GET accounts/{id}/payments":
+		s.handleHistory(w, r, p, parts[1])
+	case "POST accounts/{id}/pin":
+		s.handleSetPIN(w, r, p, parts[1])
+	case "POST accounts/{id}/totp":
+		s.handleEnableTOTP(w, r, p, parts[1])
+	case "POST accounts/{id}/status":
+		s.handleSetStatus(w, r, p, parts[1])
+	case "GET payments/{id}":
+		s.handlePayment(w, r, p, parts[1])
+	case "POST payments/{id}/reject":
+		s.handleReject(w, r, p, parts[1])
+	case "POST payments/{id}/repeat":
+		s.handleRepeat(w, r, p, parts[1])
+	case "POST payments/{id}/favorite":
+		s.handleFavoritePayment(w, r, p, parts[1])
+	case "GET favorites/{id}":
+		s.handleFavorite(w, r, p, parts[1])
+	case "POST favorites/{id}/pay":
+		s.handlePayFromFavorite(w, r, p, parts[1])
+	case "POST challenges/{id}/confirm":
+		s.handleConfirmPayment(w, r, p, parts[1])
+	case "POST export":
+		s.handleExport(w, r, p)
+	case "GET export/accounts":
+		s.handleDump(w, p, "export_accounts", s.svc.ExportAccounts)
+	case "GET export/payments":
+		s.handleDump(w, p, "export_payments", s.svc.ExportPayments)
+	case "GET export/favorites":
+		s.handleDump(w, p, "export_favorites", s.svc.ExportFavorites)
+	case "GET reviews":
+		s.handleReviews(w, r, p)
+	case "POST reviews/{id}/approve":
+		s.handleResolveReview(w, r, p, parts[1], true)
+	case "POST reviews/{id}/reject":
+		s.handleResolveReview(w, r, p, parts[1], false)
+	case "POST tokens":
+		s.handleIssueToken(w, r, p)
+	case "GET audit":
+		s.handleAudit(w, r, p)
+	case "GET metrics":
+		s.handleMetrics(w, r, p)
+	default:
+		if s.knownPath(parts) {
+			s.writeError(w, errMethodNotAllowed)
+			return
+		}
+		s.writeError(w, errNotFound)
+	}
+}
+
+func (s *Server) knownPath(parts []string) bool {
+	switch parts[0] {
+	case "accounts", "payments", "favorites", "challenges", "reviews", "export":
+		return len(parts) <= 3
+	case "tokens", "audit", "metrics":
+		return len(parts) == 1
+	}
+	return false
+}
+
+func (s *Server) handleRegisterAccount(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	var body struct {
+		Phone types.Phone `json:"phone"`
+	}
+	if err := decode(r, &body); err != nil || body.Phone == "" {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	account, err := s.guard.RegisterAccount(p, body.Phone)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, dto.FromAccount(*account))
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	account, err := s.guard.FindAccountByID(p, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, dto.FromAccount(*account))
+}
+
+func (s *Server) handleDeposit(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var body struct {
+		Amount types.Money `json:"amount"`
+	}
+	if err := decode(r, &body); err != nil {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	err = s.guard.Deposit(p, id, body.Amount)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.handleAccount(w, r, p, rawID)
+}
+
+func (s *Server) handlePay(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var body struct {
+		Amount   types.Money           `json:"amount"`
+		Category types.PaymentCategory `json:"category"`
+	}
+	if err := decode(r, &body); err != nil || body.Category == "" {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	payment, err := s.guard.Pay(p, id, body.Amount, body.Category)
+	s.writePayment(w, payment, err)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if _, err := s.guard.FindAccountByID(p, id); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result := make([]dto.Payment, 0)
+	payments, err := s.guard.ExportAccountHistory(p, id)
+	if err != nil && err != wallet.ErrAccountNotFound {
+		s.writeError(w, err)
+		return
+	}
+	for _, payment := range payments {
+		result = append(result, dto.FromPayment(payment))
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handlePayment(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	payment, err := s.guard.FindPaymentByID(p, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, dto.FromPayment(*payment))
+}
+
+func (s *Server) handleReject(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	err := s.guard.Reject(p, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRepeat(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	payment, err := s.guard.Repeat(p, id)
+	s.writePayment(w, payment, err)
+}
+
+func (s *Server) handleFavoritePayment(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := decode(r, &body); err != nil || body.Name == "" {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	favorite, err := s.guard.FavoritePayment(p, id, body.Name)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, dto.FromFavorite(*favorite))
+}
+
+func (s *Server) handleFavorite(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	favorite, err := s.guard.FindFavoriteByID(p, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, dto.FromFavorite(*favorite))
+}
+
+func (s *Server) handlePayFromFavorite(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	payment, err := s.guard.PayFromFavorite(p, id)
+	s.writePayment(w, payment, err)
+}
+
+func (s *Server) handleSetPIN(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var body struct {
+		Current string `json:"current"`
+		PIN     string `json:"pin"`
+	}
+	if err := decode(r, &body); err != nil {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	err = s.guard.SetPIN(p, id, body.Current, body.PIN)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSetStatus(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var body struct {
+		Status types.AccountStatus `json:"status"`
+		Reason string              `json:"reason"`
+	}
+	if err := decode(r, &body); err != nil || body.Status == "" {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	account, err := s.guard.SetAccountStatus(p, id, body.Status, body.Reason)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, dto.FromAccount(*account))
+}
+
+func (s *Server) handleEnableTOTP(w http.ResponseWriter, r *http.Request, p auth.Principal, rawID string) {
+	id, err := parseID(rawID)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	secret, err := s.guard.EnableTOTP(p, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, struct {
+		Secret string `json:"secret"`
+	}{secret})
+}
+
+func (s *Server) handleConfirmPayment(w http.ResponseWriter, r *http.Request, p auth.Principal, id string) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := decode(r, &body); err != nil || body.Code == "" {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+
+	payment, err := s.guard.ConfirmPayment(p, id, body.Code)
+	s.writePayment(w, payment, err)
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	err := s.guard.Admin(p, "export", s.dir, func() error {
+		return s.svc.Export(s.dir)
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDump(w http.ResponseWriter, p auth.Principal, operation string, export func(w io.Writer) error) {
+	err := s.guard.Admin(p, operation, "", func() error {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return export(w)
+	})
+	if err == auth.ErrForbidden {
+		s.writeError(w, err)
+		return
+	}
+	// выгрузка уже начата, поэтому об ошибке остаётся только написать в лог
+	if err != nil {
+		s.log().Error("write dump", logger.Err(err))
+	}
+}
+
+func (s *Server) handleReviews(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	err := s.guard.RequireAdmin(p, "reviews")
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result := make([]reviewDTO, 0)
+	for _, review := range s.svc.Reviews() {
+		result = append(result, reviewDTO{Payment: dto.FromPayment(review.Payment), Reasons: review.Reasons})
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleResolveReview(w http.ResponseWriter, r *http.Request, p auth.Principal, id string, approve bool) {
+	operation := "reject_review"
+	if approve {
+		operation = "approve_review"
+	}
+	err := s.guard.Admin(p, operation, id, func() error {
+		return s.svc.ResolveReview(id, approve)
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAudit отдаёт записи журнала аудита по автору actor, счёту account и
+// времени from <= time < to (RFC 3339); пустые параметры не ограничивают выборку.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	err := s.guard.RequireAdmin(p, "audit")
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if s.audit == nil {
+		s.writeError(w, errNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	q := audit.Query{Actor: params.Get("actor")}
+	if raw := params.Get("account"); raw != "" {
+		q.AccountID, err = parseID(raw)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if raw := params.Get(bound.name); raw != "" {
+			*bound.t, err = time.Parse(time.RFC3339, raw)
+			if err != nil {
+				s.writeError(w, errInvalidQuery)
+				return
+			}
+		}
+	}
+
+	result := s.audit.Query(q)
+	if result == nil {
+		result = []audit.Entry{}
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	err := s.guard.RequireAdmin(p, "metrics")
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if s.metrics == nil {
+		s.writeError(w, errNotFound)
+		return
+	}
+	s.metrics.ServeHTTP(w, r)
+}
+
+// handleIssueToken выпускает токен для владельца счёта accountId или, если
+// admin, для администратора. Срок действия ttl задаётся как "1h30m".
+func (s *Server) handleIssueToken(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	err := s.guard.RequireAdmin(p, "issue_token")
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var body struct {
+		AccountID int64  `json:"accountId"`
+		Admin     bool   `json:"admin"`
+		TTL       string `json:"ttl"`
+	}
+	if err := decode(r, &body); err != nil || (body.AccountID <= 0) == !body.Admin {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil || ttl <= 0 {
+		s.writeError(w, errInvalidBody)
+		return
+	}
+	if s.auth == nil {
+		s.writeError(w, auth.ErrNoSecret)
+		return
+	}
+
+	subject := auth.Admin
+	if !body.Admin {
+		if _, err := s.svc.FindAccountByID(body.AccountID); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		subject = auth.Account(body.AccountID)
+	}
+	var token string
+	err = s.guard.Admin(p, "issue_token", subject.String(), func() error {
+		token, err = s.auth.IssueToken(subject, ttl)
+		return err
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, tokenDTO{Token: token, ExpiresIn: int64(ttl.Seconds())})
+}
+
+func parseID(raw string) (int64, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errInvalidID
+	}
+	return id, nil
+}
+
+func decode(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// statusOf сопоставляет ошибкам сервиса коды ответа HTTP.
+func statusOf(err error) int {
+	switch err {
+	case errInvalidID, errInvalidBody, errInvalidQuery, wallet.ErrAmountMustBePositive:
+		return http.StatusBadRequest
+	case errNotFound, wallet.ErrAccountNotFound, wallet.ErrPaymentNotFound, wallet.ErrFavoriteNotFound:
+		return http.StatusNotFound
+	case errMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case wallet.ErrPhoneRegistered, wallet.ErrVersionConflict, wallet.ErrPaymentAlreadyRejected:
+		return http.StatusConflict
+	case wallet.ErrNotEnoughBalance, wallet.ErrInvalidCode:
+		return http.StatusUnprocessableEntity
+	case wallet.ErrInvalidPIN, phone.ErrInvalidPhone:
+		return http.StatusBadRequest
+	case wallet.ErrChallengeNotFound, wallet.ErrReviewNotFound:
+		return http.StatusNotFound
+	case wallet.ErrChallengeExpired:
+		return http.StatusGone
+	case wallet.ErrConfirmationLocked:
+		return http.StatusLocked
+	case wallet.ErrSecondFactorRequired, wallet.ErrConfirmationRequired:
+		return http.StatusPreconditionRequired
+	case wallet.ErrTOTPEnabled, wallet.ErrInvalidTransition, wallet.ErrBalanceNotZero:
+		return http.StatusConflict
+	case wallet.ErrInvalidStatus, wallet.ErrInvalidReason, wallet.ErrInvalidCategory, wallet.ErrInvalidName:
+		return http.StatusBadRequest
+	case wallet.ErrAccountFrozen, wallet.ErrAccountBlocked, wallet.ErrAccountClosed:
+		return http.StatusLocked
+	case auth.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case auth.ErrForbidden, wallet.ErrPaymentBlocked:
+		return http.StatusForbidden
+	case auth.ErrNoSecret:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// writePayment отвечает проведённым платежом или, если платёж ждёт
+// подтверждения, проверкой со статусом 202.
+func (s *Server) writePayment(w http.ResponseWriter, payment *types.Payment, err error) {
+	var required *wallet.ConfirmationRequiredError
+	if errors.As(err, &required) {
+		c := required.Challenge
+		s.writeJSON(w, http.StatusAccepted, challengeDTO{
+			ID:        c.ID,
+			AccountID: c.AccountID,
+			Amount:    c.Amount,
+			Category:  c.Category,
+			Factor:    c.Factor,
+			ExpiresAt: c.ExpiresAt,
+		})
+		return
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, dto.FromPayment(*payment))
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		s.log().Error("request failed", logger.Err(err))
+	}
+	s.writeJSON(w, status, errorDTO{Error: err.Error()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		s.log().Error("write response", logger.Err(err))
+	}
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/auth"
+	"github.com/bahrom656/wallet/pkg/dto"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/metrics"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(NewServer(&wallet.Service{}, t.TempDir()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, srv *httptest.Server, method string, path string, body string, want int, v interface{}) {
+	t.Helper()
+	doAs(t, srv, "", method, path, body, want, v)
+}
+
+// doAs выполняет запрос с ключом или токеном credential.
+func doAs(t *testing.T, srv *httptest.Server, credential string, method string, path string, body string, want int, v interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		content, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("%s %s: status = %v, want %v, body = %s", method, path, resp.StatusCode, want, content)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServer_payments(t *testing.T) {
+	srv := newTestServer(t)
+
+	var account dto.Account
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, &account)
+	do(t, srv, "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, &account)
+	if account.Balance != 1000 {
+		t.Errorf("deposit: balance = %v, want %v", account.Balance, 1000)
+	}
+
+	var payment dto.Payment
+	do(t, srv, "POST", "/accounts/1/payments", `{"amount": 300, "category": "auto"}`, http.StatusCreated, &payment)
+	do(t, srv, "POST", "/payments/"+payment.ID+"/reject", "", http.StatusNoContent, nil)
+	do(t, srv, "GET", "/payments/"+payment.ID, "", http.StatusOK, &payment)
+	if payment.Status != "FAIL" {
+		t.Errorf("reject: status = %v, want FAIL", payment.Status)
+	}
+
+	var repeated dto.Payment
+	do(t, srv, "POST", "/payments/"+payment.ID+"/repeat", "", http.StatusCreated, &repeated)
+
+	var favorite dto.Favorite
+	do(t, srv, "POST", "/payments/"+payment.ID+"/favorite", `{"name": "Tcell"}`, http.StatusCreated, &favorite)
+	do(t, srv, "POST", "/favorites/"+favorite.ID+"/pay", "", http.StatusCreated, nil)
+
+	var history []dto.Payment
+	do(t, srv, "GET", "/accounts/1/payments", "", http.StatusOK, &history)
+	if len(history) != 3 {
+		t.Errorf("history: got %v payments, want %v", len(history), 3)
+	}
+	do(t, srv, "GET", "/accounts/1", "", http.StatusOK, &account)
+	if account.Balance != 400 {
+		t.Errorf("balance = %v, want %v", account.Balance, 400)
+	}
+	do(t, srv, "POST", "/export", "", http.StatusNoContent, nil)
+}
+
+func TestServer_errors(t *testing.T) {
+	srv := newTestServer(t)
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusConflict},
+		{"POST", "/accounts", `{"phone": "992 00 000 00 01"}`, http.StatusConflict},
+		{"POST", "/accounts", `{"phone": "+992 900"}`, http.StatusBadRequest},
+		{"POST", "/accounts", `{"phone": ""}`, http.StatusBadRequest},
+		{"POST", "/accounts", `{"phone": "1", "extra": true}`, http.StatusBadRequest},
+		{"GET", "/accounts/abc", "", http.StatusBadRequest},
+		{"GET", "/accounts/2", "", http.StatusNotFound},
+		{"POST", "/accounts/1/deposit", `{"amount": -5}`, http.StatusBadRequest},
+		{"POST", "/accounts/1/payments", `{"amount": 5, "category": "auto"}`, http.StatusUnprocessableEntity},
+		{"POST", "/payments/unknown/reject", "", http.StatusNotFound},
+		{"GET", "/favorites/unknown", "", http.StatusNotFound},
+		{"DELETE", "/accounts/1", "", http.StatusMethodNotAllowed},
+		{"GET", "/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var body errorDTO
+		do(t, srv, tt.method, tt.path, tt.body, tt.want, &body)
+		if body.Error == "" {
+			t.Errorf("%s %s: empty error message", tt.method, tt.path)
+		}
+	}
+}
+
+func TestServer_exportDump(t *testing.T) {
+	srv := newTestServer(t)
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+
+	resp, err := srv.Client().Get(srv.URL + "/export/accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "1;+992000000001;0;1|" {
+		t.Errorf("export: got %q", content)
+	}
+}
+
+func TestServer_auth(t *testing.T) {
+	//создаем сервер с проверкой доступа и журналом аудита в буфер
+	api := NewServer(&wallet.Service{}, t.TempDir())
+	a := auth.NewAuthenticator([]byte("secret"))
+	a.AddKey("admin-key", auth.Admin)
+	api.SetAuth(a)
+	var audit bytes.Buffer
+	api.SetLogger(logger.New(&audit, logger.LevelInfo))
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusUnauthorized, nil)
+	doAs(t, srv, "wrong", "GET", "/accounts/1", "", http.StatusUnauthorized, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts", `{"phone": "+992000000002"}`, http.StatusCreated, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+
+	var token tokenDTO
+	doAs(t, srv, "admin-key", "POST", "/tokens", `{"accountId": 1, "ttl": "1h"}`, http.StatusCreated, &token)
+	var other tokenDTO
+	doAs(t, srv, "admin-key", "POST", "/tokens", `{"accountId": 2, "ttl": "1h"}`, http.StatusCreated, &other)
+
+	var payment dto.Payment
+	doAs(t, srv, token.Token, "POST", "/accounts/1/payments", `{"amount": 100, "category": "auto"}`, http.StatusCreated, &payment)
+	doAs(t, srv, token.Token, "GET", "/accounts/1/payments", "", http.StatusOK, nil)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/accounts/1/payments", `{"amount": 100, "category": "auto"}`},
+		{"GET", "/accounts/1/payments", ""},
+		{"POST", "/payments/" + payment.ID + "/reject", ""},
+		{"GET", "/export/accounts", ""},
+		{"POST", "/tokens", `{"admin": true, "ttl": "1h"}`},
+	}
+	for _, tt := range tests {
+		doAs(t, srv, other.Token, tt.method, tt.path, tt.body, http.StatusForbidden, nil)
+	}
+	//пополнение и отмена платежа зачисляют деньги, владельцу они недоступны
+	doAs(t, srv, token.Token, "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusForbidden, nil)
+	doAs(t, srv, token.Token, "POST", "/payments/"+payment.ID+"/reject", "", http.StatusForbidden, nil)
+	doAs(t, srv, "admin-key", "POST", "/payments/"+payment.ID+"/reject", "", http.StatusNoContent, nil)
+	doAs(t, srv, "admin-key", "POST", "/payments/"+payment.ID+"/reject", "", http.StatusConflict, nil)
+
+	var account dto.Account
+	doAs(t, srv, token.Token, "GET", "/accounts/1", "", http.StatusOK, &account)
+	if account.Balance != 1000 {
+		t.Errorf("reject: balance = %v, want %v", account.Balance, 1000)
+	}
+	if got := strings.Count(audit.String(), `msg="access denied" principal=account:2`); got != len(tests) {
+		t.Errorf("audit: got %v denials, want %v, log = %q", got, len(tests), audit.String())
+	}
+	if !strings.Contains(audit.String(), "principal=account:1 operation=reject account_id=1") {
+		t.Errorf("audit: log = %q", audit.String())
+	}
+}
+
+func TestServer_confirmPayment(t *testing.T) {
+	//создаем сервер с порогом подтверждения
+	svc := &wallet.Service{}
+	svc.SetConfirmationThreshold(500)
+	srv := httptest.NewServer(NewServer(svc, t.TempDir()))
+	t.Cleanup(srv.Close)
+
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	do(t, srv, "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+	do(t, srv, "POST", "/accounts/1/payments", `{"amount": 600, "category": "auto"}`, http.StatusPreconditionRequired, nil)
+	do(t, srv, "POST", "/accounts/1/pin", `{"pin": "12"}`, http.StatusBadRequest, nil)
+	do(t, srv, "POST", "/accounts/1/pin", `{"pin": "1234"}`, http.StatusNoContent, nil)
+
+	var challenge challengeDTO
+	do(t, srv, "POST", "/accounts/1/payments", `{"amount": 600, "category": "auto"}`, http.StatusAccepted, &challenge)
+	if challenge.Factor != wallet.FactorPIN || challenge.Amount != 600 {
+		t.Errorf("pay: challenge = %v", challenge)
+	}
+	do(t, srv, "POST", "/challenges/"+challenge.ID+"/confirm", `{"code": "0000"}`, http.StatusUnprocessableEntity, nil)
+
+	var payment dto.Payment
+	do(t, srv, "POST", "/challenges/"+challenge.ID+"/confirm", `{"code": "1234"}`, http.StatusCreated, &payment)
+	if payment.Amount != 600 {
+		t.Errorf("confirm: payment = %v", payment)
+	}
+	do(t, srv, "POST", "/challenges/"+challenge.ID+"/confirm", `{"code": "1234"}`, http.StatusNotFound, nil)
+}
+
+// flagAll отмечает каждый платёж.
+type flagAll struct{}
+
+func (flagAll) Check(wallet.PaymentAttempt) wallet.Decision {
+	return wallet.Decision{Verdict: wallet.VerdictFlag, Reasons: []string{"test"}}
+}
+
+func TestServer_reviews(t *testing.T) {
+	svc := &wallet.Service{}
+	svc.SetFraudChecker(flagAll{})
+	srv := httptest.NewServer(NewServer(svc, t.TempDir()))
+	t.Cleanup(srv.Close)
+
+	do(t, srv, "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	do(t, srv, "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+	var payment dto.Payment
+	do(t, srv, "POST", "/accounts/1/payments", `{"amount": 300, "category": "auto"}`, http.StatusCreated, &payment)
+
+	var reviews []reviewDTO
+	do(t, srv, "GET", "/reviews", "", http.StatusOK, &reviews)
+	if len(reviews) != 1 || reviews[0].Payment.ID != payment.ID || reviews[0].Reasons[0] != "test" {
+		t.Errorf("reviews: got %v", reviews)
+	}
+	do(t, srv, "POST", "/reviews/"+payment.ID+"/reject", "", http.StatusNoContent, nil)
+	do(t, srv, "POST", "/reviews/"+payment.ID+"/approve", "", http.StatusNotFound, nil)
+	do(t, srv, "GET", "/payments/"+payment.ID, "", http.StatusOK, &payment)
+	if payment.Status != "FAIL" {
+		t.Errorf("reject review: status = %v, want FAIL", payment.Status)
+	}
+}
+
+func TestServer_accountStatus(t *testing.T) {
+	//создаем сервер с проверкой доступа
+	api := NewServer(&wallet.Service{}, t.TempDir())
+	a := auth.NewAuthenticator([]byte("secret"))
+	a.AddKey("admin-key", auth.Admin)
+	a.AddKey("user-key", auth.Account(1))
+	api.SetAuth(a)
+	api.SetLogger(logger.New(ioutil.Discard, logger.LevelError))
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	doAs(t, srv, "admin-key", "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+
+	//владелец может только заморозить свой счёт
+	var account dto.Account
+	doAs(t, srv, "user-key", "POST", "/accounts/1/status", `{"status": "FROZEN", "reason": "lost phone"}`, http.StatusOK, &account)
+	if account.Status != "FROZEN" || account.Reason != "lost phone" {
+		t.Errorf("status: account = %v", account)
+	}
+	doAs(t, srv, "user-key", "POST", "/accounts/1/status", `{"status": "ACTIVE"}`, http.StatusForbidden, nil)
+	doAs(t, srv, "user-key", "POST", "/accounts/1/payments", `{"amount": 100, "category": "auto"}`, http.StatusLocked, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/deposit", `{"amount": 100}`, http.StatusOK, nil)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"status": ""}`, http.StatusBadRequest},
+		{`{"status": "DELETED"}`, http.StatusBadRequest},
+		{`{"status": "FROZEN"}`, http.StatusConflict},
+		{`{"status": "CLOSED"}`, http.StatusConflict},
+		{`{"status": "ACTIVE", "reason": "found"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		doAs(t, srv, "admin-key", "POST", "/accounts/1/status", tt.body, tt.want, nil)
+	}
+	doAs(t, srv, "user-key", "GET", "/accounts/1", "", http.StatusOK, &account)
+	if account.Status != "ACTIVE" || account.Balance != 1100 {
+		t.Errorf("status: account = %v", account)
+	}
+
+	//заблокированный счёт владелец заморозить не может
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/status", `{"status": "BLOCKED", "reason": "fraud"}`, http.StatusOK, nil)
+	doAs(t, srv, "user-key", "POST", "/accounts/1/status", `{"status": "FROZEN"}`, http.StatusConflict, nil)
+	doAs(t, srv, "user-key", "GET", "/accounts/1", "", http.StatusOK, &account)
+	if account.Status != "BLOCKED" || account.Reason != "fraud" {
+		t.Errorf("status: account = %v", account)
+	}
+}
+
+func TestServer_audit(t *testing.T) {
+	//создаем сервер с журналом аудита
+	api := NewServer(&wallet.Service{}, t.TempDir())
+	a := auth.NewAuthenticator([]byte("secret"))
+	a.AddKey("admin-key", auth.Admin)
+	a.AddKey("user-key", auth.Account(1))
+	api.SetAuth(a)
+	api.SetLogger(logger.New(ioutil.Discard, logger.LevelError))
+	var buf bytes.Buffer
+	auditLog := audit.NewLog(&buf)
+	api.SetAuditLog(auditLog)
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	doAs(t, srv, "admin-key", "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+	var payment dto.Payment
+	doAs(t, srv, "user-key", "POST", "/accounts/1/payments", `{"amount": 300, "category": "auto"}`, http.StatusCreated, &payment)
+	doAs(t, srv, "user-key", "POST", "/payments/"+payment.ID+"/reject", "", http.StatusForbidden, nil)
+	doAs(t, srv, "admin-key", "POST", "/payments/"+payment.ID+"/reject", "", http.StatusNoContent, nil)
+	doAs(t, srv, "user-key", "POST", "/export", "", http.StatusForbidden, nil)
+	doAs(t, srv, "admin-key", "POST", "/export", "", http.StatusNoContent, nil)
+	doAs(t, srv, "user-key", "GET", "/audit", "", http.StatusForbidden, nil)
+
+	var entries []audit.Entry
+	doAs(t, srv, "admin-key", "GET", "/audit?actor=account:1", "", http.StatusOK, &entries)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action+":"+entry.Result)
+	}
+	want := "reject:denied export:denied audit:denied"
+	if strings.Join(actions, " ") != want {
+		t.Errorf("audit: got %v, want %v", actions, want)
+	}
+	doAs(t, srv, "admin-key", "GET", "/audit?account=1&from=2000-01-01T00:00:00Z", "", http.StatusOK, &entries)
+	if len(entries) != 4 {
+		t.Errorf("audit: got %v entries, want %v", len(entries), 4)
+	}
+	doAs(t, srv, "admin-key", "GET", "/audit?from=yesterday", "", http.StatusBadRequest, nil)
+
+	head := auditLog.Head()
+	if _, err := audit.Verify(&buf, audit.Anchor{Seq: head.Seq, Hash: head.Hash}); err != nil {
+		t.Errorf("audit: verify error = %v", err)
+	}
+}
+
+func TestServer_metrics(t *testing.T) {
+	//метрики с общим балансом отдаются только администратору
+	svc := &wallet.Service{}
+	registry := metrics.NewRegistry()
+	svc.Instrument(registry)
+	api := NewServer(svc, t.TempDir())
+	api.SetMetrics(registry)
+	a := auth.NewAuthenticator([]byte("secret"))
+	a.AddKey("admin-key", auth.Admin)
+	a.AddKey("user-key", auth.Account(1))
+	api.SetAuth(a)
+	api.SetLogger(logger.New(ioutil.Discard, logger.LevelError))
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	doAs(t, srv, "admin-key", "POST", "/accounts", `{"phone": "+992000000001"}`, http.StatusCreated, nil)
+	doAs(t, srv, "admin-key", "POST", "/accounts/1/deposit", `{"amount": 1000}`, http.StatusOK, nil)
+	do(t, srv, "GET", "/metrics", "", http.StatusUnauthorized, nil)
+	doAs(t, srv, "user-key", "GET", "/metrics", "", http.StatusForbidden, nil)
+	doAs(t, srv, "admin-key", "POST", "/metrics", "", http.StatusMethodNotAllowed, nil)
+
+	req, err := http.NewRequest("GET", srv.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer admin-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(content), "wallet_balance_total 1000\n") {
+		t.Errorf("metrics: status = %v, body:\n%s", resp.StatusCode, content)
+	}
+}
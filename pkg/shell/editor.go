@@ -0,0 +1,162 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PlainReader читает строки как есть, без редактирования. Подходит, когда ввод
+// идёт не с терминала, например из файла или канала.
+type PlainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewPlainReader(in io.Reader, out io.Writer) *PlainReader {
+	return &PlainReader{in: bufio.NewReader(in), out: out}
+}
+
+func (r *PlainReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Editor — редактор строки для терминала в неканоническом режиме (см. MakeRaw):
+// Tab дополняет последнее слово, стрелки вверх и вниз листают историю,
+// Ctrl-C сбрасывает строку, Ctrl-D на пустой строке завершает ввод.
+type Editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  func() []string
+	complete func(line string) []string
+}
+
+func NewEditor(in io.Reader, out io.Writer, history func() []string, complete func(line string) []string) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out, history: history, complete: complete}
+}
+
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyTab       = 9
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+
+	history := e.history()
+	pos := len(history)
+	line := []rune{}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", nil
+		case keyCtrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+		case keyTab:
+			line = e.completeLine(prompt, line)
+		case keyEscape:
+			key := e.readEscape()
+			switch {
+			case key == 'A' && pos > 0:
+				pos--
+				line = []rune(history[pos])
+			case key == 'B' && pos < len(history):
+				pos++
+				line = []rune{}
+				if pos < len(history) {
+					line = []rune(history[pos])
+				}
+			default:
+				continue
+			}
+			e.redraw(prompt, line)
+		default:
+			if r >= ' ' {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// readEscape читает последовательность вида ESC [ X и возвращает X.
+func (e *Editor) readEscape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' {
+		return 0
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	return r
+}
+
+func (e *Editor) completeLine(prompt string, line []rune) []rune {
+	text := string(line)
+	candidates := e.complete(text)
+	if len(candidates) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return line
+	}
+
+	start := strings.LastIndex(text, " ") + 1
+	word := text[start:]
+	if len(candidates) == 1 {
+		line = []rune(text[:start] + candidates[0] + " ")
+		e.redraw(prompt, line)
+		return line
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		line = []rune(text[:start] + prefix)
+		e.redraw(prompt, line)
+		return line
+	}
+
+	fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	e.redraw(prompt, line)
+	return line
+}
+
+func (e *Editor) redraw(prompt string, line []rune) {
+	fmt.Fprint(e.out, "\r"+prompt+string(line)+"\x1b[K")
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
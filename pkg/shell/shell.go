@@ -0,0 +1,309 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errReadOnly = errors.New("shell is read-only")
+var errUsage = errors.New("invalid arguments, see help")
+
+const help = `commands:
+  account <id>            show account
+  phone <phone>           find account by phone
+  payments <account>      list account payments
+  payment <id>            show payment
+  reject <payment>        reject payment (asks for confirmation)
+  repeat <payment>        repeat payment (asks for confirmation)
+  save                    export data back to the dump directory
+  history                 show command history
+  help                    show this help
+  exit                    leave the shell
+`
+
+// LineReader читает строку ввода, показывая приглашение prompt.
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// Shell — интерактивная оболочка поверх wallet.Service для сотрудников поддержки.
+type Shell struct {
+	svc      *wallet.Service
+	dir      string
+	readOnly bool
+	in       LineReader
+	out      io.Writer
+	history  []string
+	auditLog *audit.Log
+}
+
+// New создаёт оболочку над сервисом, загруженным из каталога dir.
+// В режиме readOnly команды, меняющие данные, запрещены.
+func New(svc *wallet.Service, dir string, readOnly bool, out io.Writer) *Shell {
+	return &Shell{svc: svc, dir: dir, readOnly: readOnly, out: out}
+}
+
+// SetInput задаёт источник строк, например редактор строки терминала.
+func (s *Shell) SetInput(in LineReader) {
+	s.in = in
+}
+
+// SetAuditLog включает запись отмен платежей и выгрузок в журнал аудита.
+func (s *Shell) SetAuditLog(log *audit.Log) {
+	s.auditLog = log
+}
+
+// History возвращает введённые команды.
+func (s *Shell) History() []string {
+	return s.history
+}
+
+// Run читает и выполняет команды до exit или конца ввода.
+func (s *Shell) Run() error {
+	for {
+		line, err := s.in.ReadLine("wallet> ")
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s.history = append(s.history, line)
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+
+		err = s.execute(strings.Fields(line))
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (s *Shell) execute(args []string) error {
+	switch args[0] {
+	case "help":
+		_, err := io.WriteString(s.out, help)
+		return err
+	case "history":
+		for i, line := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, line)
+		}
+		return nil
+	case "account":
+		if len(args) != 2 {
+			return errUsage
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		account, err := s.svc.FindAccountByID(id)
+		if err != nil {
+			return err
+		}
+		s.printAccount(*account)
+		return nil
+	case "phone":
+		if len(args) != 2 {
+			return errUsage
+		}
+		account, err := s.svc.FindAccountByPhone(types.Phone(args[1]))
+		if err != nil {
+			return err
+		}
+		s.printAccount(*account)
+		return nil
+	case "payments":
+		if len(args) != 2 {
+			return errUsage
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		payments, err := s.svc.FilterPayments(id, 1)
+		if err != nil {
+			return err
+		}
+		for _, payment := range payments {
+			s.printPayment(payment)
+		}
+		return nil
+	case "payment":
+		if len(args) != 2 {
+			return errUsage
+		}
+		payment, err := s.svc.FindPaymentByID(args[1])
+		if err != nil {
+			return err
+		}
+		s.printPayment(*payment)
+		return nil
+	case "reject":
+		return s.reject(args)
+	case "repeat":
+		return s.repeat(args)
+	case "save":
+		if s.readOnly {
+			return errReadOnly
+		}
+		err := s.svc.Export(s.dir)
+		s.record("export", 0, s.dir, err)
+		return err
+	}
+	return fmt.Errorf("unknown command %q, see help", args[0])
+}
+
+func (s *Shell) reject(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	if s.readOnly {
+		return errReadOnly
+	}
+	payment, err := s.svc.FindPaymentByID(args[1])
+	if err != nil {
+		return err
+	}
+
+	s.printPayment(*payment)
+	ok, err := s.confirm("reject this payment?")
+	if err != nil || !ok {
+		return err
+	}
+	err = s.svc.Reject(payment.ID)
+	s.record("reject", payment.AccountID, payment.ID, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "rejected")
+	return nil
+}
+
+// record пишет действие в журнал аудита, если он задан. Ошибка записи
+// действие не отменяет и только выводится пользователю.
+func (s *Shell) record(action string, accountID int64, target string, err error) {
+	if s.auditLog == nil {
+		return
+	}
+
+	_, err = s.auditLog.RecordAction("shell", action, accountID, target, "", err)
+	if err != nil {
+		fmt.Fprintf(s.out, "error: write audit log: %v\n", err)
+	}
+}
+
+func (s *Shell) repeat(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	if s.readOnly {
+		return errReadOnly
+	}
+	payment, err := s.svc.FindPaymentByID(args[1])
+	if err != nil {
+		return err
+	}
+
+	s.printPayment(*payment)
+	ok, err := s.confirm("repeat this payment?")
+	if err != nil || !ok {
+		return err
+	}
+	repeated, err := s.svc.Repeat(payment.ID)
+	if err != nil {
+		return err
+	}
+	s.printPayment(*repeated)
+	return nil
+}
+
+func (s *Shell) confirm(question string) (bool, error) {
+	answer, err := s.in.ReadLine(question + " [y/N] ")
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer == "y" || answer == "yes" {
+		return true, nil
+	}
+	fmt.Fprintln(s.out, "cancelled")
+	return false, nil
+}
+
+func (s *Shell) printAccount(account types.Account) {
+	fmt.Fprintf(s.out, "account %d  phone %s  balance %d\n", account.ID, account.Phone, account.Balance)
+}
+
+func (s *Shell) printPayment(payment types.Payment) {
+	fmt.Fprintf(s.out, "payment %s  account %d  amount %d  category %s  status %s\n",
+		payment.ID, payment.AccountID, payment.Amount, payment.Category, payment.Status)
+}
+
+var commands = []string{"account", "exit", "help", "history", "payment", "payments", "phone", "reject", "repeat", "save"}
+
+// Complete возвращает варианты дополнения последнего слова строки line:
+// имена команд для первого слова, номера счетов и ID платежей для аргументов.
+func (s *Shell) Complete(line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(line, " ")) {
+		prefix := ""
+		if len(fields) == 1 {
+			prefix = fields[0]
+		}
+		return withPrefix(commands, prefix)
+	}
+
+	prefix := ""
+	if !strings.HasSuffix(line, " ") {
+		prefix = fields[len(fields)-1]
+	}
+	if len(fields) > 2 || (len(fields) == 2 && prefix == "") {
+		return nil
+	}
+
+	var candidates []string
+	switch fields[0] {
+	case "account", "payments":
+		for _, account := range s.svc.Accounts() {
+			candidates = append(candidates, strconv.FormatInt(account.ID, 10))
+		}
+	case "phone":
+		for _, account := range s.svc.Accounts() {
+			candidates = append(candidates, string(account.Phone))
+		}
+	case "payment", "reject", "repeat":
+		for _, payment := range s.svc.Payments() {
+			candidates = append(candidates, payment.ID)
+		}
+	}
+	return withPrefix(candidates, prefix)
+}
+
+func withPrefix(candidates []string, prefix string) []string {
+	var found []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			found = append(found, candidate)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
@@ -0,0 +1,187 @@
+package shell
+
+import (
+	"bytes"
+	"github.com/bahrom656/wallet/pkg/audit"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestShell(t *testing.T, readOnly bool, input string) (*Shell, *wallet.Service, *bytes.Buffer) {
+	//создаем Сервис со счётом и платежом
+	svc := &wallet.Service{}
+	account, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Deposit(account.ID, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	sh := New(svc, t.TempDir(), readOnly, &out)
+	sh.SetInput(NewPlainReader(strings.NewReader(input), &out))
+	return sh, svc, &out
+}
+
+func TestShell_Run_commands(t *testing.T) {
+	sh, svc, out := newTestShell(t, false, "account 1\nphone +992000000001\npayments 1\nunknown\nexit\naccount 1\n")
+	payment := svc.Payments()[0]
+
+	err := sh.Run()
+	if err != nil {
+		t.Errorf("Run(): error = %v", err)
+		return
+	}
+	for _, want := range []string{
+		"account 1  phone +992000000001  balance 9000",
+		"payment " + payment.ID,
+		`error: unknown command "unknown"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Run(): output %q must contain %q", out.String(), want)
+		}
+	}
+	wantHistory := []string{"account 1", "phone +992000000001", "payments 1", "unknown", "exit"}
+	if !reflect.DeepEqual(sh.History(), wantHistory) {
+		t.Errorf("History() = %v, want %v", sh.History(), wantHistory)
+	}
+}
+
+func TestShell_Run_rejectConfirm(t *testing.T) {
+	sh, svc, out := newTestShell(t, false, "")
+	payment := svc.Payments()[0]
+	sh.SetInput(NewPlainReader(strings.NewReader("reject "+payment.ID+"\nn\nreject "+payment.ID+"\ny\n"), out))
+
+	err := sh.Run()
+	if err != nil {
+		t.Errorf("Run(): error = %v", err)
+		return
+	}
+	if !strings.Contains(out.String(), "cancelled") || !strings.Contains(out.String(), "rejected") {
+		t.Errorf("Run(): output = %q", out.String())
+	}
+	got, err := svc.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Status != types.PaymentStatusFail {
+		t.Errorf("Run(): payment must be rejected, status = %v", got.Status)
+	}
+}
+
+func TestShell_Run_audit(t *testing.T) {
+	//отмена платежа и выгрузка попадают в журнал аудита
+	sh, svc, out := newTestShell(t, false, "")
+	payment := svc.Payments()[0]
+	sh.SetInput(NewPlainReader(strings.NewReader("reject "+payment.ID+"\ny\nreject "+payment.ID+"\ny\nsave\n"), out))
+	var buf bytes.Buffer
+	log := audit.NewLog(&buf)
+	sh.SetAuditLog(log)
+
+	err := sh.Run()
+	if err != nil {
+		t.Errorf("Run(): error = %v", err)
+		return
+	}
+	var got []string
+	for _, entry := range log.Query(audit.Query{Actor: "shell"}) {
+		got = append(got, entry.Action+":"+entry.Result)
+	}
+	want := []string{"reject:ok", "reject:failed", "export:ok"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit: got %v, want %v", got, want)
+	}
+}
+
+func TestShell_Run_readOnly(t *testing.T) {
+	sh, svc, out := newTestShell(t, true, "")
+	payment := svc.Payments()[0]
+	sh.SetInput(NewPlainReader(strings.NewReader("reject "+payment.ID+"\ny\nrepeat "+payment.ID+"\nsave\n"), out))
+
+	err := sh.Run()
+	if err != nil {
+		t.Errorf("Run(): error = %v", err)
+		return
+	}
+	if strings.Count(out.String(), "error: "+errReadOnly.Error()) != 3 {
+		t.Errorf("Run(): read-only shell must refuse changes, output = %q", out.String())
+	}
+	if len(svc.Payments()) != 1 || svc.Payments()[0].Status != types.PaymentStatusInProgress {
+		t.Errorf("Run(): read-only shell changed payments: %v", svc.Payments())
+	}
+}
+
+func TestShell_Complete(t *testing.T) {
+	sh, svc, _ := newTestShell(t, false, "")
+	payment := svc.Payments()[0]
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"pa", []string{"payment", "payments"}},
+		{"re", []string{"reject", "repeat"}},
+		{"account ", []string{"1"}},
+		{"phone +99", []string{"+992000000001"}},
+		{"reject " + payment.ID[:4], []string{payment.ID}},
+		{"account 1 ", nil},
+		{"xyz", nil},
+	}
+	for _, tt := range tests {
+		got := sh.Complete(tt.line)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Complete(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestEditor_ReadLine(t *testing.T) {
+	sh, _, _ := newTestShell(t, false, "")
+	sh.history = []string{"account 1", "payments 1"}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"typing", "help\r", "help"},
+		{"backspace", "helx\x7fp\r", "help"},
+		{"history up", "\x1b[A\x1b[A\r", "account 1"},
+		{"history down", "\x1b[A\x1b[A\x1b[B\r", "payments 1"},
+		{"tab single", "acc\t1\r", "account 1"},
+		{"tab common prefix", "pay\t\r", "payment"},
+		{"ctrl-c", "acc\x03", ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		editor := NewEditor(strings.NewReader(tt.input), &out, sh.History, sh.Complete)
+		got, err := editor.ReadLine("> ")
+		if err != nil {
+			t.Errorf("%s: ReadLine(): error = %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: ReadLine() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditor_ReadLine_ctrlD(t *testing.T) {
+	var out bytes.Buffer
+	editor := NewEditor(strings.NewReader("\x04"), &out, func() []string { return nil }, func(string) []string { return nil })
+	_, err := editor.ReadLine("> ")
+	if err != io.EOF {
+		t.Errorf("ReadLine(): must return io.EOF, returned = %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package shell
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// MakeRaw переводит терминал fd в неканонический режим без эха, чтобы Editor
+// получал нажатия клавиш сразу, и возвращает функцию восстановления режима.
+// Если fd не терминал, возвращается ошибка.
+func MakeRaw(fd int) (func(), error) {
+	var old syscall.Termios
+	err := ioctl(fd, syscall.TCGETS, &old)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, syscall.TCSETS, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = ioctl(fd, syscall.TCSETS, &old)
+	}, nil
+}
+
+func ioctl(fd int, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package shell
+
+import (
+	"errors"
+)
+
+// MakeRaw на этой платформе не поддерживается; оболочка читает строки без редактирования.
+func MakeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
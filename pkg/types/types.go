@@ -23,15 +23,33 @@ type Payment struct {
 	Amount    Money
 	Category  PaymentCategory
 	Status    PaymentStatus
+	// Version увеличивается при каждом изменении платежа.
+	Version int64
 }
 
 type Phone string
 
+// AccountStatus представляет собой статус счёта.
+type AccountStatus string
+
+// Предопределённые статусы счетов. Пустой статус означает активный счёт.
+const (
+	AccountStatusActive  AccountStatus = "ACTIVE"
+	AccountStatusFrozen  AccountStatus = "FROZEN"
+	AccountStatusBlocked AccountStatus = "BLOCKED"
+	AccountStatusClosed  AccountStatus = "CLOSED"
+)
+
 // Account представляет информацию о счёте пользователя.
 type Account struct {
 	ID      int64
 	Phone   Phone
 	Balance Money
+	// Version увеличивается при каждом изменении счёта.
+	Version int64
+	Status  AccountStatus
+	// StatusReason — причина последней смены статуса.
+	StatusReason string
 }
 
 // Favorite представляет информацию об элементе "Избранное".
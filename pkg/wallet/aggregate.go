@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"context"
+	"github.com/bahrom656/wallet/pkg/types"
+	"sync"
+	"time"
+)
+
+// checkContextEvery — через сколько платежей горутина проверяет, не отменён ли контекст.
+const checkContextEvery = 1024
+
+// Aggregation описывает параллельную свёртку платежей. Каждая горутина начинает
+// со своего Zero() и добавляет в него платежи своей части через Map, после чего
+// частичные результаты объединяются через Reduce в порядке частей.
+type Aggregation struct {
+	Zero   func() interface{}
+	Map    func(acc interface{}, payment types.Payment) interface{}
+	Reduce func(acc interface{}, part interface{}) interface{}
+}
+
+// Aggregate делит платежи на goroutines частей и сворачивает их параллельно.
+// При отмене ctx горутины останавливаются и возвращается ошибка контекста.
+func (s *Service) Aggregate(ctx context.Context, goroutines int, aggregation Aggregation) (interface{}, error) {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	parts := splitParts(len(s.payments), goroutines)
+	results := make([]interface{}, len(parts))
+
+	wg := sync.WaitGroup{}
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, payments []*types.Payment) {
+			defer wg.Done()
+
+			acc := aggregation.Zero()
+			for j, payment := range payments {
+				if j%checkContextEvery == 0 && ctx.Err() != nil {
+					return
+				}
+				acc = aggregation.Map(acc, *payment)
+			}
+			results[i] = acc
+		}(i, s.payments[part.from:part.to])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := aggregation.Zero()
+	for _, part := range results {
+		result = aggregation.Reduce(result, part)
+	}
+	return result, nil
+}
+
+type part struct {
+	from int
+	to   int
+}
+
+// splitParts делит n элементов на не более чем count непустых частей.
+func splitParts(n int, count int) []part {
+	if count < 1 {
+		count = 1
+	}
+
+	size := n/count + 1
+	parts := make([]part, 0, count)
+	for from := 0; from < n; from += size {
+		to := from + size
+		if to > n {
+			to = n
+		}
+		parts = append(parts, part{from: from, to: to})
+	}
+	return parts
+}
+
+func (s *Service) SumPaymentsContext(ctx context.Context, goroutines int) (types.Money, error) {
+	start := time.Now()
+	result, err := s.Aggregate(ctx, goroutines, Aggregation{
+		Zero: func() interface{} { return types.Money(0) },
+		Map: func(acc interface{}, payment types.Payment) interface{} {
+			return acc.(types.Money) + payment.Amount
+		},
+		Reduce: func(acc interface{}, part interface{}) interface{} {
+			return acc.(types.Money) + part.(types.Money)
+		},
+	})
+	s.metrics.observe("sum", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return result.(types.Money), nil
+}
+
+// CountPayments считает платежи, подходящие под filter.
+func (s *Service) CountPayments(ctx context.Context, goroutines int, filter PaymentFilter) (int, error) {
+	result, err := s.Aggregate(ctx, goroutines, Aggregation{
+		Zero: func() interface{} { return 0 },
+		Map: func(acc interface{}, payment types.Payment) interface{} {
+			if filter(payment) {
+				return acc.(int) + 1
+			}
+			return acc
+		},
+		Reduce: func(acc interface{}, part interface{}) interface{} {
+			return acc.(int) + part.(int)
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+type minMax struct {
+	found bool
+	min   types.Money
+	max   types.Money
+}
+
+func (m minMax) add(amount types.Money) minMax {
+	if !m.found {
+		return minMax{found: true, min: amount, max: amount}
+	}
+	if amount < m.min {
+		m.min = amount
+	}
+	if amount > m.max {
+		m.max = amount
+	}
+	return m
+}
+
+// MinMaxPayments возвращает наименьшую и наибольшую сумму платежа.
+func (s *Service) MinMaxPayments(ctx context.Context, goroutines int) (min types.Money, max types.Money, err error) {
+	result, err := s.Aggregate(ctx, goroutines, Aggregation{
+		Zero: func() interface{} { return minMax{} },
+		Map: func(acc interface{}, payment types.Payment) interface{} {
+			return acc.(minMax).add(payment.Amount)
+		},
+		Reduce: func(acc interface{}, part interface{}) interface{} {
+			m := acc.(minMax)
+			p := part.(minMax)
+			if !p.found {
+				return m
+			}
+			return m.add(p.min).add(p.max)
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	m := result.(minMax)
+	if !m.found {
+		return 0, 0, ErrPaymentNotFound
+	}
+	return m.min, m.max, nil
+}
+
+// SumPaymentsBy группирует платежи по ключу key и считает сумму каждой группы.
+func (s *Service) SumPaymentsBy(ctx context.Context, goroutines int, key func(payment types.Payment) string) (map[string]types.Money, error) {
+	result, err := s.Aggregate(ctx, goroutines, Aggregation{
+		Zero: func() interface{} { return make(map[string]types.Money) },
+		Map: func(acc interface{}, payment types.Payment) interface{} {
+			acc.(map[string]types.Money)[key(payment)] += payment.Amount
+			return acc
+		},
+		Reduce: func(acc interface{}, part interface{}) interface{} {
+			groups := acc.(map[string]types.Money)
+			for k, v := range part.(map[string]types.Money) {
+				groups[k] += v
+			}
+			return groups
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]types.Money), nil
+}
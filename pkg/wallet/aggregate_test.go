@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"context"
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"testing"
+)
+
+func TestService_SumPaymentsContext(t *testing.T) {
+	svc := newPaymentsService(t, 1000, nil)
+
+	for _, goroutines := range []int{0, 1, 7, 1000, 2000} {
+		sum, err := svc.SumPaymentsContext(context.Background(), goroutines)
+		if err != nil {
+			t.Errorf("SumPaymentsContext(): error = %v", err)
+			return
+		}
+		if sum != 500500 {
+			t.Errorf("SumPaymentsContext(%v): got %v, want %v", goroutines, sum, 500500)
+		}
+	}
+}
+
+func TestService_SumPaymentsContext_cancel(t *testing.T) {
+	svc := newPaymentsService(t, 1000, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := svc.SumPaymentsContext(ctx, 4)
+	if err != context.Canceled {
+		t.Errorf("SumPaymentsContext(): must return context.Canceled, returned = %v", err)
+	}
+}
+
+func TestService_CountPayments(t *testing.T) {
+	svc := newPaymentsService(t, 1000, nil)
+
+	count, err := svc.CountPayments(context.Background(), 4, ByAmount(101, 200))
+	if err != nil {
+		t.Errorf("CountPayments(): error = %v", err)
+		return
+	}
+	if count != 100 {
+		t.Errorf("CountPayments(): got %v, want %v", count, 100)
+	}
+}
+
+func TestService_MinMaxPayments(t *testing.T) {
+	svc := newPaymentsService(t, 1000, nil)
+
+	min, max, err := svc.MinMaxPayments(context.Background(), 6)
+	if err != nil {
+		t.Errorf("MinMaxPayments(): error = %v", err)
+		return
+	}
+	if min != 1 || max != 1000 {
+		t.Errorf("MinMaxPayments(): got %v, %v, want %v, %v", min, max, 1, 1000)
+	}
+
+	_, _, err = (&Service{}).MinMaxPayments(context.Background(), 6)
+	if err != ErrPaymentNotFound {
+		t.Errorf("MinMaxPayments(): must return ErrPaymentNotFound, returned = %v", err)
+	}
+}
+
+func TestService_SumPaymentsBy(t *testing.T) {
+	svc := newPaymentsService(t, 1000, nil)
+
+	got, err := svc.SumPaymentsBy(context.Background(), 5, func(payment types.Payment) string {
+		return string(payment.Category)
+	})
+	if err != nil {
+		t.Errorf("SumPaymentsBy(): error = %v", err)
+		return
+	}
+	want := map[string]types.Money{}
+	for _, payment := range svc.Payments() {
+		want[string(payment.Category)] += payment.Amount
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SumPaymentsBy(): got %v, want %v", got, want)
+	}
+}
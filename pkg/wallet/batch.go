@@ -0,0 +1,219 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/google/uuid"
+	"io"
+)
+
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchMode определяет, что PayBatch делает, если часть платежей не прошла.
+type BatchMode int
+
+const (
+	// BatchAllOrNothing — при любой ошибке не проводится ни один платёж,
+	// балансы счетов остаются прежними.
+	BatchAllOrNothing BatchMode = iota
+	// BatchBestEffort — проводятся все платежи, которые удалось провести.
+	BatchBestEffort
+)
+
+// PaymentRequest представляет собой один платёж пакета.
+type PaymentRequest struct {
+	AccountID int64
+	Amount    types.Money
+	Category  types.PaymentCategory
+}
+
+// BatchResult представляет собой результат одного платежа пакета:
+// проведённый платёж или ошибку.
+type BatchResult struct {
+	Payment *types.Payment
+	Err     error
+}
+
+// PayBatch проводит пакет платежей под одной блокировкой затронутых счетов.
+// В режиме BatchAllOrNothing при первой ошибке все списания откатываются,
+// у платежа с ошибкой в результате стоит сама ошибка, у остальных — ErrBatchAborted,
+// и эта же ошибка возвращается из метода. Если журнал (SetJournal) не принял
+// платежи пакета, не проводится ни один из них в любом режиме.
+//
+// Порог подтверждения, проверка на мошенничество, номера платежей, контрольная
+// точка и очереди событий берутся один раз на пакет, а не на каждый платёж,
+// поэтому пакет быстрее тех же платежей через Pay (см. Benchmark_PayBatch),
+// хотя основное время и там, и там уходит на индексы платежей.
+func (s *Service) PayBatch(requests []PaymentRequest, mode BatchMode) ([]BatchResult, error) {
+	results, tickets, err := s.payBatch(requests, mode)
+	if err != nil {
+		return results, err
+	}
+
+	for _, t := range tickets {
+		s.events.deliver(t)
+	}
+	return results, nil
+}
+
+func (s *Service) payBatch(requests []PaymentRequest, mode BatchMode) ([]BatchResult, []*ticket, error) {
+	paymentIDs, err := newPaymentIDs(len(requests))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threshold := s.stepUp.limit()
+	checker := s.fraudChecker()
+	results := make([]BatchResult, len(requests))
+	decisions := make([]Decision, len(requests))
+	accounts := make([]*types.Account, len(requests))
+	ids := make([]int64, 0, len(requests))
+	// pending — уже проверенные платежи пакета по счетам: для правил частоты
+	// они проведены раньше следующих платежей того же счёта
+	pending := make(map[int64][]PaymentAttempt)
+	for i, request := range requests {
+		if request.Amount <= 0 {
+			results[i].Err = ErrAmountMustBePositive
+			continue
+		}
+		if !validText(string(request.Category)) {
+			results[i].Err = ErrInvalidCategory
+			continue
+		}
+		// ожидающий подтверждения платёж не может быть частью пакета
+		if threshold > 0 && request.Amount > threshold {
+			results[i].Err = ErrConfirmationRequired
+			continue
+		}
+		account, err := s.findAccountByID(request.AccountID)
+		if err == nil {
+			err = checkDebit(account)
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if checker != nil {
+			attempt := PaymentAttempt{
+				AccountID: request.AccountID,
+				Amount:    request.Amount,
+				Category:  request.Category,
+				Pending:   pending[request.AccountID],
+			}
+			decisions[i] = s.checkFraud(attempt)
+			if decisions[i].Verdict == VerdictBlock {
+				results[i].Err = ErrPaymentBlocked
+				continue
+			}
+			attempt.Pending = nil
+			pending[request.AccountID] = append(pending[request.AccountID], attempt)
+		}
+		accounts[i] = account
+		ids = append(ids, account.ID)
+	}
+
+	unlock := s.lockAccounts(ids...)
+	defer unlock()
+
+	// запоминаем состояние счетов, чтобы откатить его без потерь
+	saved := make(map[*types.Account]types.Account, len(ids))
+	for _, account := range accounts {
+		if _, ok := saved[account]; account != nil && !ok {
+			saved[account] = *account
+		}
+	}
+
+	var failed error
+	for i, request := range requests {
+		if results[i].Err == nil {
+			results[i].Payment, results[i].Err = debit(accounts[i], request.Amount, request.Category, paymentIDs[i])
+		}
+		if results[i].Err != nil && failed == nil {
+			failed = results[i].Err
+			if mode == BatchAllOrNothing {
+				break
+			}
+		}
+	}
+
+	if failed != nil && mode == BatchAllOrNothing {
+		for account, state := range saved {
+			*account = state
+		}
+		for i := range results {
+			results[i].Payment = nil
+			if results[i].Err == nil {
+				results[i].Err = ErrBatchAborted
+			}
+		}
+		return results, nil, failed
+	}
+
+	// платежи пакета сохраняются в журнале одной записью
+	events := make([]Event, 0, len(results))
+	for _, result := range results {
+		if result.Payment != nil {
+			events = append(events, PaymentCreated{Payment: *result.Payment})
+		}
+	}
+	if len(events) != 0 {
+		err := s.commit(events...)
+		if err != nil {
+			for account, state := range saved {
+				*account = state
+			}
+			for i := range results {
+				if results[i].Payment != nil {
+					results[i].Payment = nil
+					results[i].Err = err
+				}
+			}
+			return results, nil, err
+		}
+	}
+
+	tickets := s.events.reserveAll(events)
+	s.paymentsMu.Lock()
+	for i, result := range results {
+		if result.Payment != nil {
+			s.appendPayment(result.Payment)
+			s.touchAccount(result.Payment.AccountID)
+			s.touchPayment(result.Payment.ID)
+			// в результате — копия: платёж сервиса меняется под s.paymentsMu
+			payment := *result.Payment
+			results[i].Payment = &payment
+		}
+	}
+	s.paymentsMu.Unlock()
+	for i, result := range results {
+		if result.Payment != nil && decisions[i].Verdict == VerdictFlag {
+			s.flag(*result.Payment, decisions[i])
+		}
+	}
+
+	return results, tickets, nil
+}
+
+// newPaymentIDs возвращает n номеров платежей — случайных UUID версии 4,
+// прочитав случайные байты для всех сразу.
+func newPaymentIDs(n int) ([]string, error) {
+	random := make([]byte, 16*n)
+	_, err := io.ReadFull(rand.Reader, random)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, n)
+	for i := range ids {
+		var id uuid.UUID
+		copy(id[:], random[16*i:])
+		id[6] = id[6]&0x0f | 0x40
+		id[8] = id[8]&0x3f | 0x80
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
@@ -0,0 +1,149 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/types"
+	"testing"
+)
+
+func TestService_PayBatch_allOrNothing(t *testing.T) {
+	//создаем Сервис
+	s := newTestService()
+	first, err := s.addAccountWithBalance("992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.RegisterAccount("992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before := []types.Account{*first, *second}
+
+	// второй платёж не пройдёт: на втором счёте нет денег
+	results, err := s.PayBatch([]PaymentRequest{
+		{AccountID: first.ID, Amount: 100_00, Category: "salary"},
+		{AccountID: second.ID, Amount: 100_00, Category: "salary"},
+		{AccountID: first.ID, Amount: 200_00, Category: "salary"},
+	}, BatchAllOrNothing)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("PayBatch(): must return ErrNotEnoughBalance, returned = %v", err)
+		return
+	}
+	want := []error{ErrBatchAborted, ErrNotEnoughBalance, ErrBatchAborted}
+	for i, result := range results {
+		if result.Err != want[i] || result.Payment != nil {
+			t.Errorf("PayBatch(): result %v = %v, want error %v", i, result, want[i])
+		}
+	}
+
+	// балансы и версии откатились, платежей нет
+	if accounts := s.Accounts(); accounts[0] != before[0] || accounts[1] != before[1] {
+		t.Errorf("PayBatch(): accounts = %v, want %v", accounts, before)
+	}
+	if len(s.payments) != 0 {
+		t.Errorf("PayBatch(): payments saved = %v", s.payments)
+	}
+}
+
+func TestService_PayBatch_overdraft(t *testing.T) {
+	//пакет не может увести счёт в минус ни целиком, ни по частям
+	s := newTestService()
+	account, err := s.addAccountWithBalance("992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	results, err := s.PayBatch([]PaymentRequest{{AccountID: account.ID, Amount: 1_000, Category: "salary"}}, BatchAllOrNothing)
+	if err != ErrNotEnoughBalance || results[0].Err != ErrNotEnoughBalance {
+		t.Errorf("PayBatch(): error = %v, results = %v, want %v", err, results, ErrNotEnoughBalance)
+	}
+	results, err = s.PayBatch([]PaymentRequest{
+		{AccountID: account.ID, Amount: 60, Category: "salary"},
+		{AccountID: account.ID, Amount: 60, Category: "salary"},
+	}, BatchBestEffort)
+	if err != nil || results[0].Err != nil || results[1].Err != ErrNotEnoughBalance {
+		t.Errorf("PayBatch(): error = %v, results = %v", err, results)
+	}
+	if balance := s.balance(t, account.ID); balance != 40 {
+		t.Errorf("PayBatch(): balance = %v, want %v", balance, 40)
+	}
+	if _, err = s.Pay(account.ID, 41, "auto"); err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+}
+
+func TestService_PayBatch_bestEffort(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	results, err := s.PayBatch([]PaymentRequest{
+		{AccountID: first.ID, Amount: 100_00, Category: "salary"},
+		{AccountID: 404, Amount: 100_00, Category: "salary"},
+		{AccountID: first.ID, Amount: -1, Category: "salary"},
+		{AccountID: first.ID, Amount: 200_00, Category: "salary"},
+	}, BatchBestEffort)
+	if err != nil {
+		t.Errorf("PayBatch(): error = %v", err)
+		return
+	}
+	want := []error{nil, ErrAccountNotFound, ErrAmountMustBePositive, nil}
+	for i, result := range results {
+		if result.Err != want[i] {
+			t.Errorf("PayBatch(): result %v error = %v, want %v", i, result.Err, want[i])
+		}
+	}
+	if balance := s.balance(t, first.ID); balance != 700_00 {
+		t.Errorf("PayBatch(): balance = %v, want %v", balance, 700_00)
+	}
+	for _, i := range []int{0, 3} {
+		if _, err := s.FindPaymentByID(results[i].Payment.ID); err != nil {
+			t.Errorf("PayBatch(): payment %v not saved, error = %v", i, err)
+		}
+	}
+}
+
+func newBatchBenchmark(b *testing.B) (*testService, []PaymentRequest) {
+	s := newTestService()
+	requests := make([]PaymentRequest, 0, 500)
+	for i := 0; i < 50; i++ {
+		account, err := s.addAccountWithBalance(types.Phone(fmt.Sprintf("992%09d", i)), 1_000_000_000_00)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 10; j++ {
+			requests = append(requests, PaymentRequest{AccountID: account.ID, Amount: 1, Category: "salary"})
+		}
+	}
+	return s, requests
+}
+
+func Benchmark_PayBatch(b *testing.B) {
+	s, requests := newBatchBenchmark(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.PayBatch(requests, BatchAllOrNothing); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_PayBatch_loop(b *testing.B) {
+	s, requests := newBatchBenchmark(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, request := range requests {
+			if _, err := s.Pay(request.AccountID, request.Amount, request.Category); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
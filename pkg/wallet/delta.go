@@ -0,0 +1,280 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/bahrom656/wallet/pkg/types"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrDeltaOutOfOrder = errors.New("delta does not continue from current checkpoint")
+var ErrNotBaseDump = errors.New("dump is a delta, not a base export")
+
+// Checkpoint возвращает номер последнего изменения, учтённого сервисом.
+// Его передают в ExportDelta, чтобы выгрузить только то, что изменилось позже.
+func (s *Service) Checkpoint() int64 {
+	return atomic.LoadInt64(&s.seq)
+}
+
+// Номер изменения выделяется атомарно, без общей блокировки, а запоминается
+// под той же блокировкой, что и само изменение: номер счёта — под блокировкой
+// его шарда (или s.mu на запись), номер платежа — под s.paymentsMu, номер
+// избранного — под s.mu на запись. Выгрузка держит их все, поэтому изменения
+// с номером до её контрольной точки в неё уже попали.
+
+// touchAccount вызывается под блокировкой шарда счёта или под s.mu на запись.
+func (s *Service) touchAccount(accountID int64) {
+	sh := &s.shards[accountShard(accountID)]
+	if sh.seq == nil {
+		sh.seq = make(map[int64]int64)
+	}
+	sh.seq[accountID] = atomic.AddInt64(&s.seq, 1)
+}
+
+// accountSeq возвращает номер последнего изменения счёта. Вызывается под
+// блокировкой шарда счёта или под s.mu на запись.
+func (s *Service) accountSeq(accountID int64) int64 {
+	return s.shards[accountShard(accountID)].seq[accountID]
+}
+
+// touchFactors отмечает смену второго фактора. Дельта несёт все вторые
+// факторы, поэтому отдельный номер для них не хранится.
+func (s *Service) touchFactors() {
+	atomic.AddInt64(&s.seq, 1)
+}
+
+// touchPayment вызывается под s.paymentsMu на запись.
+func (s *Service) touchPayment(paymentID string) {
+	if s.paymentSeq == nil {
+		s.paymentSeq = make(map[string]int64)
+	}
+	s.paymentSeq[paymentID] = atomic.AddInt64(&s.seq, 1)
+}
+
+// touchFavorite вызывается под s.mu на запись.
+func (s *Service) touchFavorite(favoriteID string) {
+	if s.favoriteSeq == nil {
+		s.favoriteSeq = make(map[string]int64)
+	}
+	s.favoriteSeq[favoriteID] = atomic.AddInt64(&s.seq, 1)
+}
+
+const checkpointDump = "checkpoint.dump"
+
+// ExportDelta выгружает в dir счета, платежи и избранное, созданные или изменённые
+// после контрольной точки since, а также все вторые факторы счетов, и возвращает
+// новую контрольную точку.
+func (s *Service) ExportDelta(dir string, since int64) (int64, error) {
+	return s.ExportDeltaFS(DirFS(dir), since)
+}
+
+// ImportDelta применяет дельту, выгруженную ExportDelta, поверх текущего состояния.
+// Дельты должны применяться по порядку: первая начинается с контрольной точки
+// базовой выгрузки (см. Export), каждая следующая — там, где закончилась
+// предыдущая. Иначе возвращается ErrDeltaOutOfOrder.
+func (s *Service) ImportDelta(dir string) error {
+	return s.ImportDeltaFS(DirFS(dir))
+}
+
+func (s *Service) ExportDeltaFS(fsys FS, since int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	unlock := s.lockAllAccounts()
+	defer unlock()
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	upto := atomic.LoadInt64(&s.seq)
+
+	err := exportFile(s.log(), fsys, accountsDump, func(w io.Writer) error {
+		return writeAccounts(w, s.accounts, func(account *types.Account) bool {
+			return s.accountSeq(account.ID) > since
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = exportFile(s.log(), fsys, paymentsDump, func(w io.Writer) error {
+		return writePayments(w, s.payments, func(payment *types.Payment) bool {
+			return s.paymentSeq[payment.ID] > since
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = exportFile(s.log(), fsys, favoritesDump, func(w io.Writer) error {
+		return writeFavorites(w, s.favorites, func(favorite *types.Favorite) bool {
+			return s.favoriteSeq[favorite.ID] > since
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	// смена второго фактора двигает s.seq (см. touchFactors), но номера
+	// для каждого счёта не хранится: факторов немного, и дельта несёт их все
+	err = exportFile(s.log(), fsys, factorsDump, s.stepUp.exportFactors)
+	if err != nil {
+		return 0, err
+	}
+	err = exportFile(s.log(), fsys, checkpointDump, func(w io.Writer) error {
+		return writeCheckpoint(w, since, upto)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return upto, nil
+}
+
+func (s *Service) ImportDeltaFS(fsys FS) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paymentsMu.Lock()
+	defer s.paymentsMu.Unlock()
+
+	from, upto, err := readCheckpoint(fsys)
+	if err != nil {
+		return err
+	}
+	if atomic.LoadInt64(&s.seq) != from {
+		return ErrDeltaOutOfOrder
+	}
+
+	err = readDeltaFile(fsys, accountsDump, func(record string) error {
+		account, err := parseAccount(record)
+		if err != nil {
+			return err
+		}
+		s.upsertAccount(account)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readDeltaFile(fsys, paymentsDump, func(record string) error {
+		payment, err := parsePayment(record)
+		if err != nil {
+			return err
+		}
+		s.upsertPayment(payment)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = readDeltaFile(fsys, favoritesDump, func(record string) error {
+		favorite, err := parseFavorite(record)
+		if err != nil {
+			return err
+		}
+		s.upsertFavorite(favorite)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = importFile(s.log(), fsys, factorsDump, s.stepUp.importFactors)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&s.seq, upto)
+	return nil
+}
+
+// Squash собирает базовую выгрузку base и цепочку дельт в новую базовую выгрузку в dir.
+func Squash(dir string, base string, deltas ...string) error {
+	svc := &Service{}
+	if err := svc.Import(base); err != nil {
+		return err
+	}
+	for _, delta := range deltas {
+		if err := svc.ImportDelta(delta); err != nil {
+			return err
+		}
+	}
+	return svc.Export(dir)
+}
+
+func (s *Service) upsertAccount(account *types.Account) {
+	if account.ID > s.nextAccountID {
+		s.nextAccountID = account.ID
+	}
+	if acc, err := s.findAccountByID(account.ID); err == nil {
+		old := acc.Phone
+		*acc = *account
+		s.reindexPhone(acc, old)
+		return
+	}
+	s.appendAccount(account)
+}
+
+func (s *Service) upsertPayment(payment *types.Payment) {
+	if pay, err := s.findPaymentByID(payment.ID); err == nil {
+		*pay = *payment
+		return
+	}
+	s.appendPayment(payment)
+}
+
+func (s *Service) upsertFavorite(favorite *types.Favorite) {
+	if fav, err := s.findFavoriteByID(favorite.ID); err == nil {
+		*fav = *favorite
+		return
+	}
+	s.appendFavorite(favorite)
+}
+
+// writeCheckpoint записывает контрольную точку выгрузки: она содержит изменения
+// после from до upto включительно. У базовой выгрузки from равен нулю.
+func writeCheckpoint(w io.Writer, from int64, upto int64) error {
+	_, err := io.WriteString(w, strconv.FormatInt(from, 10)+";"+strconv.FormatInt(upto, 10)+"|")
+	return err
+}
+
+func readCheckpoint(fsys FS) (from int64, upto int64, err error) {
+	err = readDeltaFile(fsys, checkpointDump, func(record string) error {
+		from, upto, err = parseCheckpoint(record)
+		return err
+	})
+	return from, upto, err
+}
+
+func parseCheckpoint(record string) (from int64, upto int64, err error) {
+	value := strings.Split(record, ";")
+	if len(value) != 2 {
+		return 0, 0, ErrInvalidDump
+	}
+	from, err = strconv.ParseInt(value[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	upto, err = strconv.ParseInt(value[1], 10, 64)
+	return from, upto, err
+}
+
+// readDeltaFile в отличие от importFile требует, чтобы файл существовал:
+// дельта всегда выгружается целиком.
+func readDeltaFile(fsys FS, name string, apply func(record string) error) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	records, err := readRecords(file)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		err = apply(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestService_ExportDelta_chain(t *testing.T) {
+	//создаем Сервис и делаем базовую выгрузку
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := t.TempDir()
+	err = s.Export(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoint := s.Checkpoint()
+
+	// первая дельта: отмена платежа и новый счёт
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RegisterAccount("992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	first := t.TempDir()
+	checkpoint, err = s.ExportDelta(first, checkpoint)
+	if err != nil {
+		t.Errorf("ExportDelta(): error = %v", err)
+		return
+	}
+
+	// вторая дельта: платёж и избранное
+	payment, err := s.Pay(account.ID, 500_00, "mobile")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payment.ID, "Tcell")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second := t.TempDir()
+	_, err = s.ExportDelta(second, checkpoint)
+	if err != nil {
+		t.Errorf("ExportDelta(): error = %v", err)
+		return
+	}
+
+	// восстанавливаем состояние из базы и цепочки дельт
+	got := &Service{}
+	err = got.Import(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, dir := range []string{first, second} {
+		err = got.ImportDelta(dir)
+		if err != nil {
+			t.Errorf("ImportDelta(): error = %v", err)
+			return
+		}
+	}
+	if !reflect.DeepEqual(s.accounts, got.accounts) {
+		t.Errorf("ImportDelta(): accounts = %v, want %v", got.accounts, s.accounts)
+	}
+	if !reflect.DeepEqual(s.payments, got.payments) {
+		t.Errorf("ImportDelta(): payments = %v, want %v", got.payments, s.payments)
+	}
+	if !reflect.DeepEqual(s.favorites, got.favorites) {
+		t.Errorf("ImportDelta(): favorites = %v, want %v", got.favorites, s.favorites)
+	}
+	if got.nextAccountID != s.nextAccountID {
+		t.Errorf("ImportDelta(): nextAccountID = %v, want %v", got.nextAccountID, s.nextAccountID)
+	}
+
+	// дельты нельзя применять не по порядку
+	err = got.ImportDelta(first)
+	if err != ErrDeltaOutOfOrder {
+		t.Errorf("ImportDelta(): must return ErrDeltaOutOfOrder, returned = %v", err)
+	}
+
+	// склеиваем цепочку в новую базу
+	squashed := t.TempDir()
+	err = Squash(squashed, base, first, second)
+	if err != nil {
+		t.Errorf("Squash(): error = %v", err)
+		return
+	}
+	fromSquash := &Service{}
+	err = fromSquash.Import(squashed)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(s.accounts, fromSquash.accounts) {
+		t.Errorf("Squash(): accounts = %v, want %v", fromSquash.accounts, s.accounts)
+	}
+	if !reflect.DeepEqual(s.payments, fromSquash.payments) {
+		t.Errorf("Squash(): payments = %v, want %v", fromSquash.payments, s.payments)
+	}
+}
+
+func TestService_ExportDelta_empty(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := t.TempDir()
+	err = s.Export(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	_, err = s.ExportDelta(dir, s.Checkpoint())
+	if err != nil {
+		t.Errorf("ExportDelta(): error = %v", err)
+		return
+	}
+	got := &Service{}
+	err = got.Import(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = got.ImportDelta(dir)
+	if err != nil {
+		t.Errorf("ImportDelta(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(s.accounts, got.accounts) || !reflect.DeepEqual(s.payments, got.payments) {
+		t.Errorf("ExportDelta(): delta must be empty, got %v %v", got.accounts, got.payments)
+	}
+}
+
+func TestService_ImportDelta_stale(t *testing.T) {
+	//дельта, выгруженная после базы, не применяется к пустому сервису
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base := t.TempDir()
+	err = s.Export(base)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoint := s.Checkpoint()
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	delta := t.TempDir()
+	_, err = s.ExportDelta(delta, checkpoint)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = (&Service{}).ImportDelta(delta)
+	if err != ErrDeltaOutOfOrder {
+		t.Errorf("ImportDelta(): error = %v, want %v", err, ErrDeltaOutOfOrder)
+	}
+
+	//база после новых изменений не продолжается старой дельтой
+	_, err = s.Pay(payments[0].AccountID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	newer := t.TempDir()
+	err = s.Export(newer)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got := &Service{}
+	err = got.Import(newer)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = got.ImportDelta(delta)
+	if err != ErrDeltaOutOfOrder {
+		t.Errorf("ImportDelta(): error = %v, want %v", err, ErrDeltaOutOfOrder)
+	}
+
+	//дельту нельзя загрузить как базу
+	err = (&Service{}).Import(delta)
+	if err != ErrNotBaseDump {
+		t.Errorf("Import(): error = %v, want %v", err, ErrNotBaseDump)
+	}
+}
+
+func TestService_ExportDelta_concurrent(t *testing.T) {
+	//создаем Сервис со счетами в разных шардах и делаем базовую выгрузку
+	s := newTestService()
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		account, err := s.addAccountWithBalance(types.Phone(fmt.Sprintf("992%09d", i+1)), 1_000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, account.ID)
+	}
+	base := t.TempDir()
+	if err := s.Export(base); err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+
+	//дельты выгружаются, пока идут платежи
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := s.Pay(id, 1, "auto"); err != nil {
+					t.Errorf("Pay(): error = %v", err)
+					return
+				}
+			}
+		}(id)
+	}
+	var deltas []string
+	since := s.Checkpoint()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		delta := t.TempDir()
+		upto, err := s.ExportDelta(delta, since)
+		if err != nil {
+			t.Errorf("ExportDelta(): error = %v", err)
+			return
+		}
+		deltas = append(deltas, delta)
+		since = upto
+	}
+
+	//база и дельты дают то же состояние
+	restored := &Service{}
+	if err := restored.Import(base); err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	for _, delta := range deltas {
+		if err := restored.ImportDelta(delta); err != nil {
+			t.Errorf("ImportDelta(): error = %v", err)
+			return
+		}
+	}
+	if !reflect.DeepEqual(restored.Accounts(), s.Accounts()) || len(restored.Payments()) != len(s.Payments()) {
+		t.Errorf("ImportDelta(): accounts = %v, want %v", restored.Accounts(), s.Accounts())
+	}
+}
@@ -0,0 +1,548 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	accountsDump  = "accounts.dump"
+	paymentsDump  = "payments.dump"
+	favoritesDump = "favorites.dump"
+	factorsDump   = "factors.dump"
+)
+
+// FS представляет собой каталог, в котором лежат выгрузки сервиса.
+type FS interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirFS представляет собой каталог в файловой системе ОС.
+type DirFS string
+
+func (d DirFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(string(d) + "/" + name)
+}
+
+// Create пишет во временный файл рядом с name и заменяет им name при Close,
+// поэтому сбой посреди выгрузки не портит прежний файл.
+func (d DirFS) Create(name string) (io.WriteCloser, error) {
+	file, err := ioutil.TempFile(string(d), name+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	err = file.Chmod(0644)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return &dirFile{File: file, path: string(d) + "/" + name}, nil
+}
+
+type dirFile struct {
+	*os.File
+	path string
+	err  error
+}
+
+func (f *dirFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n, err
+}
+
+// Close сбрасывает файл на диск и переименовывает его. Если запись не удалась,
+// временный файл удаляется, а прежний остаётся на месте.
+func (f *dirFile) Close() error {
+	err := f.err
+	if err == nil {
+		err = f.File.Sync()
+	}
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.path)
+}
+
+// MemFS представляет собой каталог в памяти, например для тестов.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fs: m, name: name}, nil
+}
+
+// File возвращает содержимое файла name.
+func (m *MemFS) File(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, ok := m.files[name]
+	return content, ok
+}
+
+type memFile struct {
+	bytes.Buffer
+	fs   *MemFS
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.fs.files == nil {
+		f.fs.files = make(map[string][]byte)
+	}
+	f.fs.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (s *Service) ExportAccounts(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	unlock := s.lockAllAccounts()
+	defer unlock()
+
+	return s.exportAccounts(w)
+}
+
+func (s *Service) exportAccounts(w io.Writer) error {
+	return writeAccounts(w, s.accounts, func(*types.Account) bool { return true })
+}
+
+func (s *Service) ExportPayments(w io.Writer) error {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	return s.exportPayments(w)
+}
+
+func (s *Service) exportPayments(w io.Writer) error {
+	return writePayments(w, s.payments, func(*types.Payment) bool { return true })
+}
+
+func (s *Service) ExportFavorites(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.exportFavorites(w)
+}
+
+func (s *Service) exportFavorites(w io.Writer) error {
+	return writeFavorites(w, s.favorites, func(*types.Favorite) bool { return true })
+}
+
+func (s *Service) ImportAccounts(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.importAccounts(r)
+}
+
+func (s *Service) importAccounts(r io.Reader) error {
+	records, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		account, err := parseAccount(record)
+		if err != nil {
+			return err
+		}
+		s.appendAccount(account)
+		if account.ID > s.nextAccountID {
+			s.nextAccountID = account.ID
+		}
+	}
+	return nil
+}
+
+func (s *Service) ImportPayments(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paymentsMu.Lock()
+	defer s.paymentsMu.Unlock()
+
+	return s.importPayments(r)
+}
+
+func (s *Service) importPayments(r io.Reader) error {
+	records, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		payment, err := parsePayment(record)
+		if err != nil {
+			return err
+		}
+		s.appendPayment(payment)
+	}
+	return nil
+}
+
+func (s *Service) ImportFavorites(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.importFavorites(r)
+}
+
+func (s *Service) importFavorites(r io.Reader) error {
+	records, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		favorite, err := parseFavorite(record)
+		if err != nil {
+			return err
+		}
+		s.appendFavorite(favorite)
+	}
+	return nil
+}
+
+// ExportFS выгружает непустые счета, платежи и избранное в файлы каталога fsys
+// вместе со вторыми факторами счетов (factors.dump) и контрольной точкой,
+// с которой продолжаются дельты (см. ExportDelta).
+func (s *Service) ExportFS(fsys FS) error {
+	start := time.Now()
+	err := s.exportFS(fsys)
+	s.metrics.observe("export", start, err)
+	return err
+}
+
+func (s *Service) exportFS(fsys FS) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	unlock := s.lockAllAccounts()
+	defer unlock()
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	if len(s.accounts) != 0 {
+		err := exportFile(s.log(), fsys, accountsDump, s.exportAccounts)
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.payments) != 0 {
+		err := exportFile(s.log(), fsys, paymentsDump, s.exportPayments)
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.favorites) != 0 {
+		err := exportFile(s.log(), fsys, favoritesDump, s.exportFavorites)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := exportFile(s.log(), fsys, factorsDump, s.stepUp.exportFactors)
+	if err != nil {
+		return err
+	}
+
+	return exportFile(s.log(), fsys, checkpointDump, func(w io.Writer) error {
+		return writeCheckpoint(w, 0, atomic.LoadInt64(&s.seq))
+	})
+}
+
+// ImportFS загружает счета, платежи, избранное и вторые факторы из каталога fsys.
+// Отсутствующие файлы пропускаются. Контрольная точка выгрузки становится
+// контрольной точкой сервиса; каталог с дельтой вместо базы даёт ErrNotBaseDump.
+func (s *Service) ImportFS(fsys FS) error {
+	start := time.Now()
+	err := s.importFS(fsys)
+	s.metrics.observe("import", start, err)
+	return err
+}
+
+func (s *Service) importFS(fsys FS) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paymentsMu.Lock()
+	defer s.paymentsMu.Unlock()
+
+	// в старых выгрузках контрольной точки нет
+	err := importFile(s.log(), fsys, checkpointDump, s.importCheckpoint)
+	if err != nil {
+		return err
+	}
+	err = importFile(s.log(), fsys, accountsDump, s.importAccounts)
+	if err != nil {
+		return err
+	}
+	err = importFile(s.log(), fsys, paymentsDump, s.importPayments)
+	if err != nil {
+		return err
+	}
+	err = importFile(s.log(), fsys, favoritesDump, s.importFavorites)
+	if err != nil {
+		return err
+	}
+	return importFile(s.log(), fsys, factorsDump, s.stepUp.importFactors)
+}
+
+// importCheckpoint вызывается под s.mu на запись.
+func (s *Service) importCheckpoint(r io.Reader) error {
+	records, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	if len(records) != 1 {
+		return ErrInvalidDump
+	}
+	from, upto, err := parseCheckpoint(records[0])
+	if err != nil {
+		return err
+	}
+	if from != 0 {
+		return ErrNotBaseDump
+	}
+	if upto > atomic.LoadInt64(&s.seq) {
+		atomic.StoreInt64(&s.seq, upto)
+	}
+	return nil
+}
+
+// exportFile пишет файл name через export. Ошибка Close тоже возвращается:
+// у DirFS файл сбрасывается на диск и встаёт на место только при Close.
+func exportFile(lg *logger.Logger, fsys FS, name string, export func(io.Writer) error) (err error) {
+	file, err := fsys.Create(name)
+	if err != nil {
+		lg.Error("create dump file", logger.String("file", name), logger.Err(err))
+		return ErrFileNotFound
+	}
+
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			lg.Error("close dump file", logger.String("file", name), logger.Err(cerr))
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	err = export(file)
+	if err != nil {
+		lg.Error("write dump file", logger.String("file", name), logger.Err(err))
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+func importFile(lg *logger.Logger, fsys FS, name string, load func(io.Reader) error) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		lg.Info("dump file skipped", logger.String("file", name), logger.Err(err))
+		return nil
+	}
+
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			lg.Error("close dump file", logger.String("file", name), logger.Err(cerr))
+		}
+	}()
+
+	err = load(file)
+	if err != nil {
+		lg.Error("read dump file", logger.String("file", name), logger.Err(err))
+	}
+	return err
+}
+
+func writeAccounts(w io.Writer, accounts []*types.Account, filter func(*types.Account) bool) error {
+	buf := bufio.NewWriter(w)
+	for _, account := range accounts {
+		if filter(account) {
+			_, _ = buf.WriteString(formatAccount(account))
+		}
+	}
+	return buf.Flush()
+}
+
+func writePayments(w io.Writer, payments []*types.Payment, filter func(*types.Payment) bool) error {
+	buf := bufio.NewWriter(w)
+	for _, payment := range payments {
+		if filter(payment) {
+			_, _ = buf.WriteString(formatPayment(payment))
+		}
+	}
+	return buf.Flush()
+}
+
+func writeFavorites(w io.Writer, favorites []*types.Favorite, filter func(*types.Favorite) bool) error {
+	buf := bufio.NewWriter(w)
+	for _, favorite := range favorites {
+		if filter(favorite) {
+			_, _ = buf.WriteString(formatFavorite(favorite))
+		}
+	}
+	return buf.Flush()
+}
+
+func readRecords(r io.Reader) ([]string, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	records := strings.Split(string(content), "|")
+	return records[:len(records)-1], nil
+}
+
+func formatAccount(account *types.Account) string {
+	return strconv.FormatInt(account.ID, 10) + ";" +
+		string(account.Phone) + ";" +
+		strconv.FormatInt(int64(account.Balance), 10) + ";" +
+		strconv.FormatInt(account.Version, 10) +
+		formatStatus(account) + "|"
+}
+
+// formatStatus дописывает статус и причину только счетам, статус которых
+// меняли, поэтому записи остальных счетов совпадают со старым форматом.
+func formatStatus(account *types.Account) string {
+	if account.Status == "" && account.StatusReason == "" {
+		return ""
+	}
+	return ";" + string(account.Status) + ";" + account.StatusReason
+}
+
+func formatPayment(payment *types.Payment) string {
+	return payment.ID + ";" +
+		strconv.FormatInt(payment.AccountID, 10) + ";" +
+		strconv.FormatInt(int64(payment.Amount), 10) + ";" +
+		string(payment.Category) + ";" +
+		string(payment.Status) + ";" +
+		strconv.FormatInt(payment.Version, 10) + "|"
+}
+
+func formatFavorite(favorite *types.Favorite) string {
+	return favorite.ID + ";" +
+		strconv.FormatInt(favorite.AccountID, 10) + ";" +
+		favorite.Name + ";" +
+		strconv.FormatInt(int64(favorite.Amount), 10) + ";" +
+		string(favorite.Category) + "|"
+}
+
+func parseAccount(record string) (*types.Account, error) {
+	value := strings.Split(record, ";")
+	if len(value) < 3 {
+		return nil, ErrInvalidDump
+	}
+	id, err := strconv.ParseInt(value[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := strconv.ParseInt(value[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	account := &types.Account{
+		ID:      id,
+		Phone:   types.Phone(value[1]),
+		Balance: types.Money(balance),
+	}
+	// в старых выгрузках версии нет
+	if len(value) > 3 {
+		account.Version, err = strconv.ParseInt(value[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// статус и причина есть только у счетов, статус которых меняли
+	if len(value) > 4 {
+		if len(value) != 6 || !validStatus(types.AccountStatus(value[4])) {
+			return nil, ErrInvalidDump
+		}
+		account.Status = types.AccountStatus(value[4])
+		account.StatusReason = value[5]
+	}
+	return account, nil
+}
+
+func parsePayment(record string) (*types.Payment, error) {
+	value := strings.Split(record, ";")
+	if len(value) < 5 {
+		return nil, ErrInvalidDump
+	}
+	accountID, err := strconv.ParseInt(value[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(value[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	payment := &types.Payment{
+		ID:        value[0],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(value[3]),
+		Status:    types.PaymentStatus(value[4]),
+	}
+	// в старых выгрузках версии нет
+	if len(value) > 5 {
+		payment.Version, err = strconv.ParseInt(value[5], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payment, nil
+}
+
+func parseFavorite(record string) (*types.Favorite, error) {
+	value := strings.Split(record, ";")
+	if len(value) < 5 {
+		return nil, ErrInvalidDump
+	}
+	accountID, err := strconv.ParseInt(value[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseInt(value[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Favorite{
+		ID:        value[0],
+		AccountID: accountID,
+		Name:      value[2],
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(value[4]),
+	}, nil
+}
@@ -0,0 +1,165 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService_ExportAccounts(t *testing.T) {
+	s := newTestService()
+	err := s.ImportAccounts(strings.NewReader("1;+992000000000;100;3|2;+992000000001;0;0|"))
+	if err != nil {
+		t.Errorf("ImportAccounts(): error = %v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = s.ExportAccounts(&buf)
+	if err != nil {
+		t.Errorf("ExportAccounts(): error = %v", err)
+		return
+	}
+	want := "1;+992000000000;100;3|2;+992000000001;0;0|"
+	if buf.String() != want {
+		t.Errorf("ExportAccounts(): got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestService_ImportPayments_invalid(t *testing.T) {
+	s := newTestService()
+	err := s.ImportPayments(strings.NewReader("b097a93a;1;4050|"))
+	if err != ErrInvalidDump {
+		t.Errorf("ImportPayments(): must return ErrInvalidDump, returned = %v", err)
+	}
+}
+
+// closeFailFS — каталог, файлы которого не сохраняются при Close,
+// как у DirFS при ошибке сброса на диск или переименования.
+type closeFailFS struct {
+	MemFS
+}
+
+type closeFailFile struct {
+	io.WriteCloser
+}
+
+func (f closeFailFile) Close() error {
+	return errors.New("rename failed")
+}
+
+func (fsys *closeFailFS) Create(name string) (io.WriteCloser, error) {
+	file, err := fsys.MemFS.Create(name)
+	return closeFailFile{file}, err
+}
+
+func TestService_ExportFS_closeError(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsys := &closeFailFS{}
+	err = s.ExportFS(fsys)
+	if err == nil || err.Error() != "rename failed" {
+		t.Errorf("ExportFS(): error = %v, want close error", err)
+	}
+	if _, ok := fsys.File(accountsDump); ok {
+		t.Errorf("ExportFS(): %v must not be saved", accountsDump)
+	}
+}
+
+func TestService_ExportFS_MemFS(t *testing.T) {
+	//создаем Сервис
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "Beeline")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsys := &MemFS{}
+	err = s.ExportFS(fsys)
+	if err != nil {
+		t.Errorf("ExportFS(): error = %v", err)
+		return
+	}
+	for _, name := range []string{"accounts.dump", "payments.dump", "favorites.dump"} {
+		if _, ok := fsys.File(name); !ok {
+			t.Errorf("ExportFS(): %v not written", name)
+		}
+	}
+
+	got := &Service{}
+	err = got.ImportFS(fsys)
+	if err != nil {
+		t.Errorf("ImportFS(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(s.accounts, got.accounts) {
+		t.Errorf("ImportFS(): accounts = %v, want %v", got.accounts, s.accounts)
+	}
+	if !reflect.DeepEqual(s.payments, got.payments) {
+		t.Errorf("ImportFS(): payments = %v, want %v", got.payments, s.payments)
+	}
+	if !reflect.DeepEqual(s.favorites, got.favorites) {
+		t.Errorf("ImportFS(): favorites = %v, want %v", got.favorites, s.favorites)
+	}
+}
+
+func TestService_ImportFS_missingFiles(t *testing.T) {
+	s := newTestService()
+	err := s.ImportFS(&MemFS{})
+	if err != nil {
+		t.Errorf("ImportFS(): missing files must be skipped, error = %v", err)
+	}
+}
+
+func TestDirFS_Create(t *testing.T) {
+	//прежний файл заменяется только при закрытии нового
+	dir := t.TempDir()
+	fsys := DirFS(dir)
+	err := ioutil.WriteFile(dir+"/accounts.dump", []byte("old"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	file, err := fsys.Create("accounts.dump")
+	if err != nil {
+		t.Errorf("Create(): error = %v", err)
+		return
+	}
+	_, err = file.Write([]byte("new"))
+	if err != nil {
+		t.Errorf("Write(): error = %v", err)
+	}
+	content, _ := ioutil.ReadFile(dir + "/accounts.dump")
+	if string(content) != "old" {
+		t.Errorf("Write(): file = %q before Close, want %q", content, "old")
+	}
+	err = file.Close()
+	if err != nil {
+		t.Errorf("Close(): error = %v", err)
+	}
+	content, _ = ioutil.ReadFile(dir + "/accounts.dump")
+	if string(content) != "new" {
+		t.Errorf("Close(): file = %q, want %q", content, "new")
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Close(): temporary files left in %v", files)
+	}
+}
@@ -0,0 +1,326 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"sync"
+	"sync/atomic"
+)
+
+// Event — событие сервиса. События одного счёта доставляются подписчикам
+// в том порядке, в каком изменения были применены.
+type Event interface {
+	AccountID() int64
+}
+
+// AccountRegistered — зарегистрирован новый счёт.
+type AccountRegistered struct {
+	Account types.Account
+}
+
+// Deposited — счёт пополнен на Amount, Account — состояние после пополнения.
+type Deposited struct {
+	Account types.Account
+	Amount  types.Money
+}
+
+// TransferSent — со счёта переведено Amount на счёт To (Transfer), Account —
+// состояние после списания.
+type TransferSent struct {
+	Account types.Account
+	To      int64
+	Amount  types.Money
+}
+
+// TransferReceived — на счёт зачислено Amount со счёта From (Transfer),
+// Account — состояние после зачисления.
+type TransferReceived struct {
+	Account types.Account
+	From    int64
+	Amount  types.Money
+}
+
+// AccountUpdated — счёт изменён через UpdateAccount или MigratePhones,
+// Account — состояние после изменения.
+type AccountUpdated struct {
+	Account types.Account
+}
+
+// PaymentCreated — совершён платёж. RepeatOf заполнен, если платёж повторяет
+// другой (Repeat), FavoriteID — если он совершён из избранного.
+type PaymentCreated struct {
+	Payment    types.Payment
+	RepeatOf   string
+	FavoriteID string
+}
+
+// PaymentRejected — платёж отменён, средства вернулись на счёт.
+type PaymentRejected struct {
+	Payment types.Payment
+}
+
+// PaymentUpdated — платёж изменён через UpdatePayment.
+type PaymentUpdated struct {
+	Payment types.Payment
+}
+
+// FavoriteCreated — платёж добавлен в избранное.
+type FavoriteCreated struct {
+	Favorite types.Favorite
+}
+
+// AccountStatusChanged — статус счёта сменился с From, Account — состояние
+// после смены (см. SetAccountStatus).
+type AccountStatusChanged struct {
+	Account types.Account
+	From    types.AccountStatus
+}
+
+// SecondFactorChanged — у счёта Account задан PIN (SetPIN), включён или
+// выключен TOTP. Salt и Secret — соль и хэш PIN или ключ TOTP; пустой Secret
+// означает, что фактор выключен. Событие только сохраняется в журнале
+// (SetJournal) и подписчикам шины не рассылается.
+type SecondFactorChanged struct {
+	Account int64
+	Factor  Factor
+	Salt    []byte
+	Secret  []byte
+}
+
+func (e AccountRegistered) AccountID() int64    { return e.Account.ID }
+func (e Deposited) AccountID() int64            { return e.Account.ID }
+func (e TransferSent) AccountID() int64         { return e.Account.ID }
+func (e TransferReceived) AccountID() int64     { return e.Account.ID }
+func (e AccountUpdated) AccountID() int64       { return e.Account.ID }
+func (e PaymentCreated) AccountID() int64       { return e.Payment.AccountID }
+func (e PaymentRejected) AccountID() int64      { return e.Payment.AccountID }
+func (e PaymentUpdated) AccountID() int64       { return e.Payment.AccountID }
+func (e FavoriteCreated) AccountID() int64      { return e.Favorite.AccountID }
+func (e AccountStatusChanged) AccountID() int64 { return e.Account.ID }
+func (e SecondFactorChanged) AccountID() int64  { return e.Account }
+
+// Bus рассылает события подписчикам. Нулевое значение готово к работе.
+//
+// Синхронные подписчики вызываются в горутине, совершившей операцию, до
+// возврата из метода сервиса и уже без его блокировок. Синхронный подписчик
+// не должен менять счёт, событие которого обрабатывает: события этого счёта
+// ждут окончания обработки. Асинхронные подписчики получают события через
+// очереди в своих горутинах.
+//
+// Очереди счетов распределены по шардам, как блокировки счетов сервиса,
+// так что события разных счетов резервируются и доставляются без общей
+// блокировки. mu нужна только для смены подписчиков: их список заменяется
+// целиком и читается без неё.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers atomic.Value // []*subscriber
+	shards      [accountShards]streamShard
+}
+
+// streamShard — очереди счетов одного шарда и блокировка, под которой
+// они меняются.
+type streamShard struct {
+	mu      sync.Mutex
+	streams map[int64]*stream
+}
+
+// stream упорядочивает доставку событий одного счёта: событие с номером seq
+// доставляется после события seq-1. Его cond.L — блокировка шарда.
+type stream struct {
+	cond      *sync.Cond
+	assigned  int64
+	delivered int64
+}
+
+// ticket — событие, которому уже назначено место в очереди счёта.
+type ticket struct {
+	event  Event
+	stream *stream
+	seq    int64
+}
+
+type subscriber struct {
+	handler func(Event)
+	queues  []*eventQueue
+}
+
+// Subscribe добавляет синхронного подписчика и возвращает функцию отписки.
+func (b *Bus) Subscribe(handler func(Event)) func() {
+	return b.subscribe(&subscriber{handler: handler})
+}
+
+// SubscribeAsync добавляет асинхронного подписчика, обрабатывающего события
+// в partitions горутинах. События одного счёта всегда попадают в одну горутину
+// и обрабатываются по порядку. Функция отписки дожидается обработки уже
+// принятых событий.
+func (b *Bus) SubscribeAsync(handler func(Event), partitions int) func() {
+	if partitions < 1 {
+		partitions = 1
+	}
+
+	sub := &subscriber{handler: handler, queues: make([]*eventQueue, partitions)}
+	for i := range sub.queues {
+		sub.queues[i] = newEventQueue()
+		go sub.queues[i].run(handler)
+	}
+	return b.subscribe(sub)
+}
+
+// loadSubscribers возвращает текущий список подписчиков; менять его нельзя.
+func (b *Bus) loadSubscribers() []*subscriber {
+	subscribers, _ := b.subscribers.Load().([]*subscriber)
+	return subscribers
+}
+
+func (b *Bus) subscribe(sub *subscriber) func() {
+	b.mu.Lock()
+	subscribers := b.loadSubscribers()
+	b.subscribers.Store(append(subscribers[:len(subscribers):len(subscribers)], sub))
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.unsubscribe(sub)
+			for _, queue := range sub.queues {
+				queue.close()
+			}
+		})
+	}
+}
+
+func (b *Bus) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.loadSubscribers()
+	subscribers := make([]*subscriber, 0, len(current))
+	for _, other := range current {
+		if other != sub {
+			subscribers = append(subscribers, other)
+		}
+	}
+	b.subscribers.Store(subscribers)
+}
+
+// Publish доставляет событие подписчикам.
+func (b *Bus) Publish(event Event) {
+	b.deliver(b.reserve(event))
+}
+
+// reserve назначает событию место в очереди его счёта. Сервис вызывает её
+// под блокировкой счёта, поэтому порядок событий совпадает с порядком изменений.
+func (b *Bus) reserve(event Event) *ticket {
+	shard := &b.shards[accountShard(event.AccountID())]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.streams == nil {
+		shard.streams = make(map[int64]*stream)
+	}
+	st, ok := shard.streams[event.AccountID()]
+	if !ok {
+		st = &stream{cond: sync.NewCond(&shard.mu)}
+		shard.streams[event.AccountID()] = st
+	}
+	st.assigned++
+	return &ticket{event: event, stream: st, seq: st.assigned}
+}
+
+// reserveAll резервирует места для событий events по порядку.
+func (b *Bus) reserveAll(events []Event) []*ticket {
+	tickets := make([]*ticket, 0, len(events))
+	for _, event := range events {
+		tickets = append(tickets, b.reserve(event))
+	}
+	return tickets
+}
+
+// deliver вызывается без блокировок сервиса.
+func (b *Bus) deliver(t *ticket) {
+	cond := t.stream.cond
+	cond.L.Lock()
+	for t.stream.delivered != t.seq-1 {
+		cond.Wait()
+	}
+	cond.L.Unlock()
+
+	subscribers := b.loadSubscribers()
+
+	for _, sub := range subscribers {
+		if sub.queues == nil {
+			sub.handler(t.event)
+			continue
+		}
+		partition := uint64(t.event.AccountID()) % uint64(len(sub.queues))
+		sub.queues[partition].push(t.event)
+	}
+
+	cond.L.Lock()
+	t.stream.delivered = t.seq
+	cond.Broadcast()
+	cond.L.Unlock()
+}
+
+// eventQueue — неограниченная очередь асинхронного подписчика,
+// чтобы медленный подписчик не задерживал операции сервиса.
+type eventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+	closed bool
+	done   chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *eventQueue) push(event Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.events = append(q.events, event)
+	q.cond.Signal()
+}
+
+func (q *eventQueue) run(handler func(Event)) {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		events := q.events
+		q.events = nil
+		q.mu.Unlock()
+
+		for _, event := range events {
+			handler(event)
+		}
+	}
+}
+
+// close прекращает приём событий и ждёт обработки принятых.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	<-q.done
+}
+
+// Events возвращает шину событий сервиса.
+func (s *Service) Events() *Bus {
+	return &s.events
+}
@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestService_Events_sync(t *testing.T) {
+	//создаем Сервис и подписываемся на события
+	s := newTestService()
+	var events []Event
+	unsubscribe := s.Events().Subscribe(func(event Event) {
+		events = append(events, event)
+	})
+
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fromFavorite, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	unsubscribe()
+	_, err = s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []Event{
+		AccountRegistered{Account: types.Account{ID: 1, Phone: "+992000000001", Version: 1}},
+		Deposited{Account: types.Account{ID: 1, Phone: "+992000000001", Balance: 10_000, Version: 2}, Amount: 10_000},
+		PaymentCreated{Payment: types.Payment{ID: payment.ID, AccountID: 1, Amount: 1_000, Category: "auto", Status: types.PaymentStatusInProgress, Version: 1}},
+		PaymentRejected{Payment: types.Payment{ID: payment.ID, AccountID: 1, Amount: 1_000, Category: "auto", Status: types.PaymentStatusFail, Version: 2}},
+		FavoriteCreated{Favorite: *favorite},
+		PaymentCreated{Payment: *repeated, RepeatOf: payment.ID},
+		PaymentCreated{Payment: *fromFavorite, FavoriteID: favorite.ID},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Subscribe(): events = %v, want %v", events, want)
+	}
+}
+
+func TestService_Events_asyncOrderedPerAccount(t *testing.T) {
+	//создаем Сервис с несколькими счетами
+	s := newTestService()
+	var accounts []*types.Account
+	for _, phone := range []types.Phone{"+992000000001", "+992000000002", "+992000000003"} {
+		account, err := s.addAccountWithBalance(phone, 1_000_000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accounts = append(accounts, account)
+	}
+
+	var mu sync.Mutex
+	versions := make(map[int64][]int64)
+	unsubscribe := s.Events().SubscribeAsync(func(event Event) {
+		deposited, ok := event.(Deposited)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		versions[event.AccountID()] = append(versions[event.AccountID()], deposited.Account.Version)
+	}, 2)
+
+	//пополняем счета параллельно, версия счёта растёт с каждым пополнением
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		account := account
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					err := s.Deposit(account.ID, 1)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	unsubscribe()
+
+	for _, account := range accounts {
+		got := versions[account.ID]
+		if len(got) != 200 {
+			t.Errorf("SubscribeAsync(): account %v got %v events, want 200", account.ID, len(got))
+			continue
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i] <= got[i-1] {
+				t.Errorf("SubscribeAsync(): account %v events out of order: %v after %v", account.ID, got[i], got[i-1])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrEventNotFound = errors.New("event not found")
+var ErrUnknownEvent = errors.New("unknown event")
+var ErrJournalBroken = errors.New("event journal broken by a failed write")
+
+const (
+	eventAccountRegistered = "account.registered"
+	eventDeposited         = "deposited"
+	eventTransferSent      = "transfer.sent"
+	eventTransferReceived  = "transfer.received"
+	eventAccountUpdated    = "account.updated"
+	eventPaymentCreated    = "payment.created"
+	eventPaymentRejected   = "payment.rejected"
+	eventPaymentUpdated    = "payment.updated"
+	eventFavoriteCreated   = "favorite.created"
+	eventAccountStatus     = "account.status"
+	eventSecondFactor      = "factor.changed"
+)
+
+// EventStore — журнал событий сервиса, который только дописывается.
+// Состояние сервиса на момент любого события восстанавливается повтором
+// событий журнала (Rebuild).
+//
+// Записи журнала имеют формат выгрузок: номер;вид;поля сущности|.
+//
+// Чтобы журнал был надёжным хранилищем состояния, его подключают к сервису
+// через SetJournal: событие сохраняется до того, как изменение станет видно,
+// и если сохранить его не удалось, операция возвращает ошибку.
+type EventStore struct {
+	mu     sync.Mutex
+	w      io.Writer
+	events []Event
+	// err — ошибка записи, после которой конец w мог остаться недописанным.
+	err error
+}
+
+// NewEventStore создаёт пустой журнал. Если w не nil, каждое событие
+// дописывается в w.
+func NewEventStore(w io.Writer) *EventStore {
+	return &EventStore{w: w}
+}
+
+// LoadEventStore читает журнал из r; новые события дописываются в w.
+func LoadEventStore(r io.Reader, w io.Writer) (*EventStore, error) {
+	records, err := readRecords(r)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &EventStore{w: w, events: make([]Event, 0, len(records))}
+	for i, record := range records {
+		number, event, err := parseEvent(record)
+		if err != nil {
+			return nil, err
+		}
+		if number != int64(i+1) {
+			return nil, ErrInvalidDump
+		}
+		store.events = append(store.events, event)
+	}
+	return store, nil
+}
+
+// Journal сохраняет события до того, как изменения сервиса станут видны.
+// Если Append вернул ошибку, операция сервиса отменяется и возвращает её.
+type Journal interface {
+	Append(events ...Event) (int64, error)
+}
+
+// SetJournal подключает журнал, например EventStore. Вызывается до начала
+// работы с сервисом.
+func (s *Service) SetJournal(journal Journal) {
+	s.journal = journal
+}
+
+// commit сохраняет события операции в журнале. Вызывается под блокировками
+// счетов операции, до изменения состояния.
+func (s *Service) commit(events ...Event) error {
+	if s.journal == nil {
+		return nil
+	}
+	_, err := s.journal.Append(events...)
+	if err != nil {
+		s.log().Error("journal append", logger.String("event", EventName(events[0])), logger.AccountID(events[0].AccountID()), logger.Err(err))
+	}
+	return err
+}
+
+// Append дописывает события в журнал одной записью в w и возвращает номер
+// последнего (с единицы). Если w умеет Sync (как *os.File), Append
+// возвращается только после сброса записи на диск. После ошибки записи
+// журнал больше ничего не принимает и возвращает ErrJournalBroken: его
+// нужно загрузить заново. LoadEventStore отбрасывает оборванную запись,
+// но из нескольких событий одного Append в файле могли остаться первые.
+func (e *EventStore) Append(events ...Event) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.err != nil {
+		return 0, ErrJournalBroken
+	}
+
+	var records strings.Builder
+	for i, event := range events {
+		record, err := formatEvent(int64(len(e.events)+i+1), event)
+		if err != nil {
+			return 0, err
+		}
+		records.WriteString(record)
+	}
+	if e.w != nil {
+		_, err := io.WriteString(e.w, records.String())
+		if err == nil {
+			if syncer, ok := e.w.(interface{ Sync() error }); ok {
+				err = syncer.Sync()
+			}
+		}
+		if err != nil {
+			e.err = err
+			return 0, err
+		}
+	}
+	e.events = append(e.events, events...)
+	return int64(len(e.events)), nil
+}
+
+// Len возвращает номер последнего события.
+func (e *EventStore) Len() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return int64(len(e.events))
+}
+
+// Events возвращает события с номерами от from до upto включительно.
+func (e *EventStore) Events(from int64, upto int64) ([]Event, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if from < 1 || upto < from-1 || upto > int64(len(e.events)) {
+		return nil, ErrEventNotFound
+	}
+	return append([]Event(nil), e.events[from-1:upto]...), nil
+}
+
+// Rebuild восстанавливает сервис в состоянии после события upto;
+// Rebuild(Len()) даёт текущее состояние.
+func (e *EventStore) Rebuild(upto int64) (*Service, error) {
+	events, err := e.Events(1, upto)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{}
+	for _, event := range events {
+		err = s.Apply(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Apply применяет событие к состоянию сервиса, не публикуя его заново.
+// Так из журнала строятся представления счетов, платежей и избранного.
+func (s *Service) Apply(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock := s.lockAccounts(event.AccountID())
+	defer unlock()
+	s.paymentsMu.Lock()
+	defer s.paymentsMu.Unlock()
+
+	switch e := event.(type) {
+	case AccountRegistered:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case Deposited:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case TransferSent:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case TransferReceived:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case AccountUpdated:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case PaymentCreated:
+		account, err := s.findAccountByID(e.Payment.AccountID)
+		if err != nil {
+			return err
+		}
+		account.Balance -= e.Payment.Amount
+		account.Version++
+		payment := e.Payment
+		s.upsertPayment(&payment)
+		s.touchAccount(account.ID)
+		s.touchPayment(payment.ID)
+	case PaymentRejected:
+		account, err := s.findAccountByID(e.Payment.AccountID)
+		if err != nil {
+			return err
+		}
+		account.Balance += e.Payment.Amount
+		account.Version++
+		payment := e.Payment
+		s.upsertPayment(&payment)
+		s.touchAccount(account.ID)
+		s.touchPayment(payment.ID)
+	case PaymentUpdated:
+		payment := e.Payment
+		s.upsertPayment(&payment)
+		s.touchPayment(payment.ID)
+	case FavoriteCreated:
+		favorite := e.Favorite
+		s.upsertFavorite(&favorite)
+		s.touchFavorite(favorite.ID)
+	case AccountStatusChanged:
+		account := e.Account
+		s.upsertAccount(&account)
+		s.touchAccount(account.ID)
+	case SecondFactorChanged:
+		s.stepUp.mu.Lock()
+		s.stepUp.apply(e)
+		s.stepUp.mu.Unlock()
+		s.touchFactors()
+	default:
+		return ErrUnknownEvent
+	}
+	return nil
+}
+
+// EventName возвращает вид события, под которым оно записано в журнал,
+// например "payment.created".
+func EventName(event Event) string {
+	switch event.(type) {
+	case AccountRegistered:
+		return eventAccountRegistered
+	case Deposited:
+		return eventDeposited
+	case TransferSent:
+		return eventTransferSent
+	case TransferReceived:
+		return eventTransferReceived
+	case AccountUpdated:
+		return eventAccountUpdated
+	case PaymentCreated:
+		return eventPaymentCreated
+	case PaymentRejected:
+		return eventPaymentRejected
+	case PaymentUpdated:
+		return eventPaymentUpdated
+	case FavoriteCreated:
+		return eventFavoriteCreated
+	case AccountStatusChanged:
+		return eventAccountStatus
+	case SecondFactorChanged:
+		return eventSecondFactor
+	}
+	return ""
+}
+
+func formatEvent(number int64, event Event) (string, error) {
+	prefix := strconv.FormatInt(number, 10) + ";"
+	switch e := event.(type) {
+	case AccountRegistered:
+		return prefix + eventAccountRegistered + ";" + formatAccount(&e.Account), nil
+	case Deposited:
+		return prefix + eventDeposited + ";" +
+			strings.TrimSuffix(formatAccount(&e.Account), "|") + ";" +
+			strconv.FormatInt(int64(e.Amount), 10) + "|", nil
+	case TransferSent:
+		return prefix + eventTransferSent + ";" +
+			strings.TrimSuffix(formatAccount(&e.Account), "|") + ";" +
+			strconv.FormatInt(e.To, 10) + ";" +
+			strconv.FormatInt(int64(e.Amount), 10) + "|", nil
+	case TransferReceived:
+		return prefix + eventTransferReceived + ";" +
+			strings.TrimSuffix(formatAccount(&e.Account), "|") + ";" +
+			strconv.FormatInt(e.From, 10) + ";" +
+			strconv.FormatInt(int64(e.Amount), 10) + "|", nil
+	case AccountUpdated:
+		return prefix + eventAccountUpdated + ";" + formatAccount(&e.Account), nil
+	case PaymentCreated:
+		return prefix + eventPaymentCreated + ";" +
+			strings.TrimSuffix(formatPayment(&e.Payment), "|") + ";" +
+			e.RepeatOf + ";" +
+			e.FavoriteID + "|", nil
+	case PaymentRejected:
+		return prefix + eventPaymentRejected + ";" + formatPayment(&e.Payment), nil
+	case PaymentUpdated:
+		return prefix + eventPaymentUpdated + ";" + formatPayment(&e.Payment), nil
+	case FavoriteCreated:
+		return prefix + eventFavoriteCreated + ";" + formatFavorite(&e.Favorite), nil
+	case AccountStatusChanged:
+		return prefix + eventAccountStatus + ";" +
+			strings.TrimSuffix(formatAccount(&e.Account), "|") + ";" +
+			string(e.From) + "|", nil
+	case SecondFactorChanged:
+		return prefix + eventSecondFactor + ";" + formatFactor(e), nil
+	}
+	return "", ErrUnknownEvent
+}
+
+func parseEvent(record string) (int64, Event, error) {
+	value := strings.Split(record, ";")
+	if len(value) < 3 {
+		return 0, nil, ErrInvalidDump
+	}
+	number, err := strconv.ParseInt(value[0], 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	fields := value[2:]
+
+	switch value[1] {
+	case eventAccountRegistered:
+		account, err := parseAccount(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, AccountRegistered{Account: *account}, nil
+	case eventDeposited:
+		// сумма — последнее поле: у счёта в записи может быть статус
+		if len(fields) != 5 && len(fields) != 7 {
+			return 0, nil, ErrInvalidDump
+		}
+		account, err := parseAccount(strings.Join(fields[:len(fields)-1], ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		amount, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, Deposited{Account: *account, Amount: types.Money(amount)}, nil
+	case eventTransferSent, eventTransferReceived:
+		// номер второго счёта и сумма — два последних поля
+		if len(fields) != 6 && len(fields) != 8 {
+			return 0, nil, ErrInvalidDump
+		}
+		account, err := parseAccount(strings.Join(fields[:len(fields)-2], ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		other, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		amount, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		if value[1] == eventTransferSent {
+			return number, TransferSent{Account: *account, To: other, Amount: types.Money(amount)}, nil
+		}
+		return number, TransferReceived{Account: *account, From: other, Amount: types.Money(amount)}, nil
+	case eventAccountUpdated:
+		account, err := parseAccount(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, AccountUpdated{Account: *account}, nil
+	case eventPaymentCreated:
+		if len(fields) != 8 {
+			return 0, nil, ErrInvalidDump
+		}
+		payment, err := parsePayment(strings.Join(fields[:6], ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, PaymentCreated{Payment: *payment, RepeatOf: fields[6], FavoriteID: fields[7]}, nil
+	case eventPaymentRejected:
+		payment, err := parsePayment(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, PaymentRejected{Payment: *payment}, nil
+	case eventPaymentUpdated:
+		payment, err := parsePayment(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, PaymentUpdated{Payment: *payment}, nil
+	case eventFavoriteCreated:
+		favorite, err := parseFavorite(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, FavoriteCreated{Favorite: *favorite}, nil
+	case eventAccountStatus:
+		if len(fields) != 5 && len(fields) != 7 {
+			return 0, nil, ErrInvalidDump
+		}
+		account, err := parseAccount(strings.Join(fields[:len(fields)-1], ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		from := types.AccountStatus(fields[len(fields)-1])
+		if !validStatus(from) {
+			return 0, nil, ErrInvalidDump
+		}
+		return number, AccountStatusChanged{Account: *account, From: from}, nil
+	case eventSecondFactor:
+		event, err := parseFactor(strings.Join(fields, ";"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return number, event, nil
+	}
+	return 0, nil, ErrUnknownEvent
+}
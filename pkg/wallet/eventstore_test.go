@@ -0,0 +1,277 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventStore_Rebuild(t *testing.T) {
+	//создаем Сервис и пишем его события в журнал
+	s := newTestService()
+	var journal bytes.Buffer
+	store := NewEventStore(&journal)
+	s.SetJournal(store)
+
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	afterDeposit := int64(2)
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "Beeline")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//восстанавливаем сервис из записанного журнала
+	loaded, err := LoadEventStore(bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Errorf("LoadEventStore(): error = %v", err)
+		return
+	}
+	if loaded.Len() != store.Len() {
+		t.Errorf("LoadEventStore(): Len() = %v, want %v", loaded.Len(), store.Len())
+	}
+	got, err := loaded.Rebuild(loaded.Len())
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got.Accounts(), s.Accounts()) {
+		t.Errorf("Rebuild(): accounts = %v, want %v", got.Accounts(), s.Accounts())
+	}
+	if !reflect.DeepEqual(got.Payments(), s.Payments()) {
+		t.Errorf("Rebuild(): payments = %v, want %v", got.Payments(), s.Payments())
+	}
+	if !reflect.DeepEqual(got.Favorites(), s.Favorites()) {
+		t.Errorf("Rebuild(): favorites = %v, want %v", got.Favorites(), s.Favorites())
+	}
+
+	//состояние сразу после пополнения
+	past, err := loaded.Rebuild(afterDeposit)
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+	accounts := past.Accounts()
+	if len(accounts) != 1 || accounts[0].Balance != defaultTestAccount.balance || len(past.Payments()) != 0 {
+		t.Errorf("Rebuild(%v): accounts = %v, payments = %v", afterDeposit, accounts, past.Payments())
+	}
+	if accounts[0].ID != account.ID {
+		t.Errorf("Rebuild(%v): wrong account %v", afterDeposit, accounts[0])
+	}
+}
+
+func TestEventStore_Rebuild_updates(t *testing.T) {
+	//переводы и изменения через Update* тоже попадают в журнал
+	s := newTestService()
+	var journal bytes.Buffer
+	store := NewEventStore(&journal)
+	s.SetJournal(store)
+
+	first, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Transfer(first.ID, second.ID, 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err = s.FindAccountByID(second.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	updated := *second
+	updated.Phone = "+992000000003"
+	updated.Balance = 5_000
+	_, err = s.UpdateAccount(updated)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment := *payments[0]
+	payment.Category = "mobile"
+	_, err = s.UpdatePayment(payment)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loaded, err := LoadEventStore(bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Errorf("LoadEventStore(): error = %v", err)
+		return
+	}
+	got, err := loaded.Rebuild(loaded.Len())
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got.Accounts(), s.Accounts()) {
+		t.Errorf("Rebuild(): accounts = %v, want %v", got.Accounts(), s.Accounts())
+	}
+	if !reflect.DeepEqual(got.Payments(), s.Payments()) {
+		t.Errorf("Rebuild(): payments = %v, want %v", got.Payments(), s.Payments())
+	}
+	if _, err := got.FindAccountByPhone("+992000000003"); err != nil {
+		t.Errorf("Rebuild(): updated phone not indexed: %v", err)
+	}
+}
+
+func TestEventStore_Rebuild_notFound(t *testing.T) {
+	store := NewEventStore(nil)
+	_, err := store.Rebuild(1)
+	if err != ErrEventNotFound {
+		t.Errorf("Rebuild(): must return ErrEventNotFound, returned = %v", err)
+	}
+}
+
+func TestLoadEventStore_invalid(t *testing.T) {
+	tests := []string{
+		"1;account.registered;1;+992000000001;0;1|3;deposited;1;+992000000001;100;2;100|",
+		"1;account.closed;1|",
+		"1;deposited;1;+992000000001|",
+	}
+	for _, journal := range tests {
+		_, err := LoadEventStore(strings.NewReader(journal), nil)
+		if err == nil {
+			t.Errorf("LoadEventStore(%q): must return error", journal)
+		}
+	}
+}
+
+func TestEventStore_Rebuild_separators(t *testing.T) {
+	//разделители журнала в категории и имени не принимаются, и журнал остаётся читаемым
+	s := newTestService()
+	var journal bytes.Buffer
+	s.SetJournal(NewEventStore(&journal))
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, category := range []types.PaymentCategory{"food;x", "food|x", "food\nx"} {
+		if _, err = s.Pay(account.ID, 100, category); err != ErrInvalidCategory {
+			t.Errorf("Pay(%q): error = %v, want %v", category, err, ErrInvalidCategory)
+		}
+	}
+	results, _ := s.PayBatch([]PaymentRequest{{AccountID: account.ID, Amount: 100, Category: "food;x"}}, BatchBestEffort)
+	if results[0].Err != ErrInvalidCategory {
+		t.Errorf("PayBatch(): error = %v, want %v", results[0].Err, ErrInvalidCategory)
+	}
+	payment, err := s.Pay(account.ID, 100, "food")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	update := *payment
+	update.Category = "food;x"
+	if _, err = s.UpdatePayment(update); err != ErrInvalidCategory {
+		t.Errorf("UpdatePayment(): error = %v, want %v", err, ErrInvalidCategory)
+	}
+	if _, err = s.FavoritePayment(payment.ID, "lunch|x"); err != ErrInvalidName {
+		t.Errorf("FavoritePayment(): error = %v, want %v", err, ErrInvalidName)
+	}
+	if _, err = s.FavoritePayment(payment.ID, "lunch"); err != nil {
+		t.Errorf("FavoritePayment(): error = %v", err)
+	}
+
+	loaded, err := LoadEventStore(bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Errorf("LoadEventStore(): error = %v", err)
+		return
+	}
+	got, err := loaded.Rebuild(loaded.Len())
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got.Payments(), s.Payments()) || !reflect.DeepEqual(got.Favorites(), s.Favorites()) {
+		t.Errorf("Rebuild(): payments = %v, favorites = %v", got.Payments(), got.Favorites())
+	}
+}
+
+// brokenWriter перестаёт писать, когда fail истинно, и считает вызовы Sync.
+type brokenWriter struct {
+	buf   bytes.Buffer
+	fail  bool
+	syncs int
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("disk full")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *brokenWriter) Sync() error {
+	w.syncs++
+	return nil
+}
+
+func TestService_SetJournal_failure(t *testing.T) {
+	//операция, событие которой не записалось в журнал, не проходит
+	s := newTestService()
+	var journal brokenWriter
+	store := NewEventStore(&journal)
+	s.SetJournal(store)
+
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if journal.syncs != 2 {
+		t.Errorf("Append(): syncs = %v, want 2", journal.syncs)
+	}
+
+	journal.fail = true
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err == nil {
+		t.Errorf("Pay(): error = %v, want write error", err)
+	}
+	results, err := s.PayBatch([]PaymentRequest{{AccountID: account.ID, Amount: 100, Category: "auto"}}, BatchBestEffort)
+	if err != ErrJournalBroken || results[0].Err != ErrJournalBroken || results[0].Payment != nil {
+		t.Errorf("PayBatch(): error = %v, results = %v, want %v", err, results, ErrJournalBroken)
+	}
+
+	//после ошибки записи журнал ничего не принимает
+	journal.fail = false
+	err = s.Deposit(account.ID, 100)
+	if err != ErrJournalBroken {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrJournalBroken)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 1_000 || account.Version != 2 || len(s.Payments()) != 0 || store.Len() != 2 {
+		t.Errorf("account = %v, payments = %v, events = %v", account, s.Payments(), store.Len())
+	}
+}
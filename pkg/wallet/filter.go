@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"context"
+	"github.com/bahrom656/wallet/pkg/types"
+)
+
+// PaymentFilter решает, попадает ли платёж в выборку.
+type PaymentFilter func(payment types.Payment) bool
+
+func ByAccount(accountID int64) PaymentFilter {
+	return func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}
+}
+
+func ByCategory(category types.PaymentCategory) PaymentFilter {
+	return func(payment types.Payment) bool {
+		return payment.Category == category
+	}
+}
+
+func ByStatus(status types.PaymentStatus) PaymentFilter {
+	return func(payment types.Payment) bool {
+		return payment.Status == status
+	}
+}
+
+// ByAmount отбирает платежи с суммой от min до max включительно.
+func ByAmount(min types.Money, max types.Money) PaymentFilter {
+	return func(payment types.Payment) bool {
+		return payment.Amount >= min && payment.Amount <= max
+	}
+}
+
+// AllOf отбирает платежи, подходящие под все фильтры сразу.
+func AllOf(filters ...PaymentFilter) PaymentFilter {
+	return func(payment types.Payment) bool {
+		for _, filter := range filters {
+			if !filter(payment) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FilterPaymentsByFn(ByAccount(accountID), goroutines)
+}
+
+// FilterPaymentsByFn делит платежи на goroutines частей и фильтрует их параллельно.
+// Платежи возвращаются в том же порядке, в котором они были совершены.
+func (s *Service) FilterPaymentsByFn(filter PaymentFilter, goroutines int) ([]types.Payment, error) {
+	result, err := s.Aggregate(context.Background(), goroutines, Aggregation{
+		Zero: func() interface{} { return make([]types.Payment, 0) },
+		Map: func(acc interface{}, payment types.Payment) interface{} {
+			if filter(payment) {
+				return append(acc.([]types.Payment), payment)
+			}
+			return acc
+		},
+		Reduce: func(acc interface{}, part interface{}) interface{} {
+			return append(acc.([]types.Payment), part.([]types.Payment)...)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]types.Payment), nil
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService_FilterPayments(t *testing.T) {
+	//создаем Сервис
+	s := newTestService()
+	first, err := s.addAccountWithBalance("992000000001", 1_000_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("992000000002", 1_000_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var want []types.Payment
+	for i := 0; i < 103; i++ {
+		account := first
+		if i%3 == 0 {
+			account = second
+		}
+		payment, err := s.Pay(account.ID, types.Money(i+1), "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if account == first {
+			want = append(want, *payment)
+		}
+	}
+
+	for _, goroutines := range []int{1, 2, 10, 200} {
+		got, err := s.FilterPayments(first.ID, goroutines)
+		if err != nil {
+			t.Errorf("FilterPayments(): error = %v", err)
+			return
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FilterPayments(%v): got %v payments, want %v in the same order", goroutines, len(got), len(want))
+		}
+	}
+}
+
+func TestService_FilterPayments_accountNotFound(t *testing.T) {
+	s := newTestService()
+	_, err := s.FilterPayments(1, 10)
+	if err != ErrAccountNotFound {
+		t.Errorf("FilterPayments(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
+
+func TestService_FilterPaymentsByFn(t *testing.T) {
+	s := newTestService()
+	err := s.ImportPayments(strings.NewReader("1;1;100;auto;OK;1|2;1;200;auto;FAIL;1|3;1;300;food;OK;1|4;1;400;auto;OK;1|"))
+	if err != nil {
+		t.Errorf("ImportPayments(): error = %v", err)
+		return
+	}
+
+	got, err := s.FilterPaymentsByFn(AllOf(
+		ByCategory("auto"),
+		ByStatus(types.PaymentStatusOk),
+		ByAmount(50, 300),
+	), 3)
+	if err != nil {
+		t.Errorf("FilterPaymentsByFn(): error = %v", err)
+		return
+	}
+	if len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("FilterPaymentsByFn(): got %v, want payment 1", got)
+	}
+}
+
+func Benchmark_FilterPayments(b *testing.B) {
+	svc := newPaymentsService(b, 1_000_000, nil)
+	filter := ByAccount(7)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		got, _ := svc.FilterPaymentsByFn(filter, 8)
+		if len(got) != 10_000 {
+			b.Fatalf("invalid result, got %v, want %v", len(got), 10_000)
+		}
+	}
+}
+
+func Benchmark_FilterPayments_sequential(b *testing.B) {
+	payments := newPaymentsService(b, 1_000_000, nil).Payments()
+	filter := ByAccount(7)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		got := make([]types.Payment, 0)
+		for _, payment := range payments {
+			if filter(payment) {
+				got = append(got, payment)
+			}
+		}
+		if len(got) != 10_000 {
+			b.Fatalf("invalid result, got %v, want %v", len(got), 10_000)
+		}
+	}
+}
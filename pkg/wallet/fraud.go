@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrPaymentBlocked = errors.New("payment blocked by fraud rules")
+var ErrReviewNotFound = errors.New("review not found")
+
+// FraudHistory — сколько последних платежей счёта попадает в PaymentAttempt.History.
+const FraudHistory = 100
+
+// Verdict — решение проверки платежа на мошенничество.
+type Verdict int
+
+const (
+	// VerdictAllow — платёж проводится.
+	VerdictAllow Verdict = iota
+	// VerdictFlag — платёж проводится и попадает в очередь проверки (Reviews).
+	VerdictFlag
+	// VerdictBlock — платёж не проводится, возвращается ErrPaymentBlocked.
+	VerdictBlock
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictAllow:
+		return "allow"
+	case VerdictFlag:
+		return "flag"
+	case VerdictBlock:
+		return "block"
+	}
+	return "verdict(" + strconv.Itoa(int(v)) + ")"
+}
+
+// PaymentAttempt — платёж или перевод, который собираются провести.
+type PaymentAttempt struct {
+	AccountID int64
+	// To — счёт получателя перевода (Transfer); у платежа ноль. У перевода
+	// нет категории.
+	To         int64
+	Amount     types.Money
+	Category   types.PaymentCategory
+	RepeatOf   string
+	FavoriteID string
+	// History — последние платежи счёта (не больше FraudHistory), старые первыми.
+	History []types.Payment
+	// Pending — платежи счёта, проверенные раньше в том же пакете (PayBatch).
+	// Они проводятся вместе с этим платежом, поэтому правила частоты должны
+	// считать их уже проведёнными.
+	Pending []PaymentAttempt
+}
+
+// Decision — решение проверки и причины, по которым платёж отмечен или заблокирован.
+type Decision struct {
+	Verdict Verdict
+	Reasons []string
+}
+
+// FraudChecker проверяет платёж до списания. Check вызывается под
+// блокировкой сервиса на чтение и не должен вызывать методы сервиса.
+type FraudChecker interface {
+	Check(attempt PaymentAttempt) Decision
+}
+
+// Review — отмеченный платёж, ожидающий решения в очереди проверки.
+type Review struct {
+	Payment types.Payment
+	Reasons []string
+}
+
+// reviewQueue — очередь проверки; её mu берётся без других блокировок сервиса.
+// Проверка (checker) читается без mu, каждым платежом.
+type reviewQueue struct {
+	mu      sync.Mutex
+	checker atomic.Value // checkerValue
+	reviews []Review
+}
+
+// checkerValue позволяет хранить в atomic.Value и nil.
+type checkerValue struct {
+	checker FraudChecker
+}
+
+// SetFraudChecker включает проверку платежей Pay, Repeat, PayFromFavorite,
+// ConfirmPayment и PayBatch и переводов Transfer и ConfirmTransfer.
+// Вызывается до начала работы с сервисом.
+func (s *Service) SetFraudChecker(checker FraudChecker) {
+	s.fraud.checker.Store(checkerValue{checker})
+}
+
+// Reviews возвращает отмеченные платежи в порядке их проведения.
+func (s *Service) Reviews() []Review {
+	s.fraud.mu.Lock()
+	defer s.fraud.mu.Unlock()
+
+	return append([]Review(nil), s.fraud.reviews...)
+}
+
+// ResolveReview убирает платёж paymentID из очереди проверки. Если approve
+// ложно, платёж отменяется (см. Reject) и деньги возвращаются на счёт.
+func (s *Service) ResolveReview(paymentID string, approve bool) error {
+	s.fraud.mu.Lock()
+	index := -1
+	for i, review := range s.fraud.reviews {
+		if review.Payment.ID == paymentID {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		s.fraud.mu.Unlock()
+		return ErrReviewNotFound
+	}
+	s.fraud.reviews = append(s.fraud.reviews[:index], s.fraud.reviews[index+1:]...)
+	s.fraud.mu.Unlock()
+
+	s.log().Info("review resolved", logger.PaymentID(paymentID), logger.String("approved", strconv.FormatBool(approve)))
+	if approve {
+		return nil
+	}
+	return s.Reject(paymentID)
+}
+
+// checkFraud проверяет платёж до списания, дополнив attempt историей счёта.
+// Вызывается под s.mu без блокировок счетов.
+func (s *Service) checkFraud(attempt PaymentAttempt) Decision {
+	checker := s.fraudChecker()
+	if checker == nil {
+		return Decision{}
+	}
+
+	s.paymentsMu.RLock()
+	payments := s.accountPayments(attempt.AccountID)
+	if len(payments) > FraudHistory {
+		payments = payments[len(payments)-FraudHistory:]
+	}
+	attempt.History = make([]types.Payment, 0, len(payments))
+	for _, payment := range payments {
+		attempt.History = append(attempt.History, *payment)
+	}
+	s.paymentsMu.RUnlock()
+
+	decision := checker.Check(attempt)
+	if decision.Verdict == VerdictBlock {
+		s.log().Warn("payment blocked", logger.AccountID(attempt.AccountID), logger.String("reasons", strings.Join(decision.Reasons, ",")))
+	}
+	return decision
+}
+
+func (s *Service) fraudChecker() FraudChecker {
+	value, _ := s.fraud.checker.Load().(checkerValue)
+	return value.checker
+}
+
+// flag ставит проведённый платёж в очередь проверки.
+func (s *Service) flag(payment types.Payment, decision Decision) {
+	s.fraud.mu.Lock()
+	s.fraud.reviews = append(s.fraud.reviews, Review{Payment: payment, Reasons: decision.Reasons})
+	s.fraud.mu.Unlock()
+
+	s.log().Warn("payment flagged", logger.AccountID(payment.AccountID), logger.PaymentID(payment.ID),
+		logger.String("reasons", strings.Join(decision.Reasons, ",")))
+}
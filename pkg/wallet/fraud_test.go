@@ -0,0 +1,174 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+	"testing"
+)
+
+// stubChecker блокирует категорию blocked и отмечает платежи больше flagAbove.
+type stubChecker struct {
+	blocked   types.PaymentCategory
+	flagAbove types.Money
+	attempts  []PaymentAttempt
+}
+
+func (c *stubChecker) Check(attempt PaymentAttempt) Decision {
+	c.attempts = append(c.attempts, attempt)
+	if attempt.Category == c.blocked {
+		return Decision{Verdict: VerdictBlock, Reasons: []string{"blocked category"}}
+	}
+	if attempt.Amount > c.flagAbove {
+		return Decision{Verdict: VerdictFlag, Reasons: []string{"large amount"}}
+	}
+	return Decision{}
+}
+
+func TestService_SetFraudChecker(t *testing.T) {
+	//создаем Сервис с проверкой платежей
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checker := &stubChecker{blocked: "casino", flagAbove: 1_000}
+	s.SetFraudChecker(checker)
+
+	_, err = s.Pay(account.ID, 100, "casino")
+	if err != ErrPaymentBlocked {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrPaymentBlocked)
+	}
+	if balance := s.balance(t, account.ID); balance != 10_000 {
+		t.Errorf("Pay(): balance = %v, want %v", balance, 10_000)
+	}
+
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Errorf("FavoritePayment(): error = %v", err)
+		return
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Errorf("PayFromFavorite(): error = %v", err)
+	}
+	last := checker.attempts[len(checker.attempts)-1]
+	if last.FavoriteID != favorite.ID || len(last.History) != 1 {
+		t.Errorf("PayFromFavorite(): attempt = %v", last)
+	}
+	if len(s.Reviews()) != 0 {
+		t.Errorf("Reviews(): got %v, want none", s.Reviews())
+	}
+
+	flagged, err := s.Pay(account.ID, 2_000, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	reviews := s.Reviews()
+	if len(reviews) != 1 || reviews[0].Payment.ID != flagged.ID || reviews[0].Reasons[0] != "large amount" {
+		t.Errorf("Reviews(): got %v", reviews)
+		return
+	}
+
+	//отклонённый при проверке платёж отменяется
+	err = s.ResolveReview(flagged.ID, false)
+	if err != nil {
+		t.Errorf("ResolveReview(): error = %v", err)
+	}
+	flagged, err = s.FindPaymentByID(flagged.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if balance := s.balance(t, account.ID); flagged.Status != types.PaymentStatusFail || balance != 9_800 {
+		t.Errorf("ResolveReview(): status = %v, balance = %v", flagged.Status, balance)
+	}
+	err = s.ResolveReview(flagged.ID, true)
+	if err != ErrReviewNotFound {
+		t.Errorf("ResolveReview(): error = %v, want %v", err, ErrReviewNotFound)
+	}
+
+	results, _ := s.PayBatch([]PaymentRequest{
+		{AccountID: account.ID, Amount: 100, Category: "casino"},
+		{AccountID: account.ID, Amount: 1_500, Category: "auto"},
+	}, BatchBestEffort)
+	if results[0].Err != ErrPaymentBlocked || results[1].Err != nil {
+		t.Errorf("PayBatch(): results = %v", results)
+	}
+	if len(s.Reviews()) != 1 {
+		t.Errorf("Reviews(): got %v, want 1", s.Reviews())
+	}
+}
+
+func TestService_SetFraudChecker_transfer(t *testing.T) {
+	//создаем Сервис с проверкой платежей и переводов
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checker := &stubChecker{blocked: "casino", flagAbove: 1_000}
+	s.SetFraudChecker(checker)
+
+	err = s.Transfer(first.ID, second.ID, 500)
+	if err != nil {
+		t.Errorf("Transfer(): error = %v", err)
+		return
+	}
+	last := checker.attempts[len(checker.attempts)-1]
+	if last.AccountID != first.ID || last.To != second.ID || last.Amount != 500 {
+		t.Errorf("Transfer(): attempt = %v", last)
+	}
+
+	//перевод не отменить, поэтому отмеченный перевод не проводится
+	err = s.Transfer(first.ID, second.ID, 2_000)
+	if err != ErrPaymentBlocked {
+		t.Errorf("Transfer(): error = %v, want %v", err, ErrPaymentBlocked)
+	}
+	if s.balance(t, first.ID) != 9_500 || s.balance(t, second.ID) != 500 {
+		t.Errorf("Transfer(): balances = %v, %v", s.balance(t, first.ID), s.balance(t, second.ID))
+	}
+	if len(s.Reviews()) != 0 {
+		t.Errorf("Reviews(): got %v, want none", s.Reviews())
+	}
+}
+
+func TestService_SetFraudChecker_history(t *testing.T) {
+	//в проверку попадают только последние FraudHistory платежей счёта
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < FraudHistory+5; i++ {
+		_, err = s.Pay(account.ID, types.Money(i+1), "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	checker := &stubChecker{flagAbove: 1_000_000}
+	s.SetFraudChecker(checker)
+
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	history := checker.attempts[0].History
+	if len(history) != FraudHistory || history[0].Amount != 6 || history[len(history)-1].Amount != FraudHistory+5 {
+		t.Errorf("Pay(): history = %v payments from %v to %v", len(history), history[0].Amount, history[len(history)-1].Amount)
+	}
+}
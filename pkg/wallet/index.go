@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/types"
+)
+
+// Индексы по ID, телефону и счёту платежа. Индексы счетов и избранного меняются
+// под s.mu, индексы платежей — под s.paymentsMu.
+//
+// В старых выгрузках встречаются повторяющиеся ID, поэтому в индексе остаётся
+// первая запись. Записи добавляются только через appendAccount, appendPayment
+// и appendFavorite, поэтому индексы всегда полные.
+
+func (s *Service) appendAccount(account *types.Account) {
+	if s.accountsByID == nil {
+		s.accountsByID = make(map[int64]*types.Account)
+		s.accountsByPhone = make(map[types.Phone]*types.Account)
+	}
+	if _, ok := s.accountsByID[account.ID]; !ok {
+		s.accountsByID[account.ID] = account
+	}
+	if _, ok := s.accountsByPhone[account.Phone]; !ok {
+		s.accountsByPhone[account.Phone] = account
+	}
+	s.accounts = append(s.accounts, account)
+}
+
+// reindexPhone обновляет индекс телефонов после смены телефона счёта.
+func (s *Service) reindexPhone(account *types.Account, old types.Phone) {
+	if old == account.Phone || s.accountsByPhone == nil {
+		return
+	}
+	if s.accountsByPhone[old] == account {
+		delete(s.accountsByPhone, old)
+	}
+	if _, ok := s.accountsByPhone[account.Phone]; !ok {
+		s.accountsByPhone[account.Phone] = account
+	}
+}
+
+func (s *Service) appendPayment(payment *types.Payment) {
+	if s.paymentsByID == nil {
+		s.paymentsByID = make(map[string]*types.Payment)
+		s.paymentsByAccount = make(map[int64][]*types.Payment)
+	}
+	if _, ok := s.paymentsByID[payment.ID]; !ok {
+		s.paymentsByID[payment.ID] = payment
+	}
+	s.paymentsByAccount[payment.AccountID] = append(s.paymentsByAccount[payment.AccountID], payment)
+	s.payments = append(s.payments, payment)
+}
+
+func (s *Service) appendFavorite(favorite *types.Favorite) {
+	if s.favoritesByID == nil {
+		s.favoritesByID = make(map[string]*types.Favorite)
+	}
+	if _, ok := s.favoritesByID[favorite.ID]; !ok {
+		s.favoritesByID[favorite.ID] = favorite
+	}
+	s.favorites = append(s.favorites, favorite)
+}
+
+func (s *Service) findAccountByID(accountID int64) (*types.Account, error) {
+	if account, ok := s.accountsByID[accountID]; ok {
+		return account, nil
+	}
+	return nil, ErrAccountNotFound
+}
+
+func (s *Service) findAccountByPhone(phone types.Phone) (*types.Account, error) {
+	if account, ok := s.accountsByPhone[phone]; ok {
+		return account, nil
+	}
+	return nil, ErrAccountNotFound
+}
+
+func (s *Service) findPaymentByID(paymentID string) (*types.Payment, error) {
+	if payment, ok := s.paymentsByID[paymentID]; ok {
+		return payment, nil
+	}
+	return nil, ErrPaymentNotFound
+}
+
+// accountPayments возвращает платежи счёта в порядке их совершения.
+func (s *Service) accountPayments(accountID int64) []*types.Payment {
+	return s.paymentsByAccount[accountID]
+}
+
+func (s *Service) findFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	if favorite, ok := s.favoritesByID[favoriteID]; ok {
+		return favorite, nil
+	}
+	return nil, ErrFavoriteNotFound
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestService_Import_indexes(t *testing.T) {
+	//создаем Сервис и выгружаем его
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "Beeline")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fsys := &MemFS{}
+	err = s.ExportFS(fsys)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// после загрузки всё находится через индексы
+	got := &Service{}
+	err = got.ImportFS(fsys)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = got.FindPaymentByID(payments[0].ID); err != nil {
+		t.Errorf("FindPaymentByID(): error = %v", err)
+	}
+	if _, err = got.FindFavoriteByID(favorite.ID); err != nil {
+		t.Errorf("FindFavoriteByID(): error = %v", err)
+	}
+	if _, err = got.RegisterAccount(defaultTestAccount.phone); err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistered, returned = %v", err)
+	}
+	history, err := got.ExportAccountHistory(payments[0].AccountID)
+	if err != nil || len(history) != 1 {
+		t.Errorf("ExportAccountHistory(): got %v, error = %v", history, err)
+	}
+}
+
+func TestService_UpdateAccount_phoneIndex(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	update := *account
+	update.Phone = "992000000002"
+	_, err = s.UpdateAccount(update)
+	if err != nil {
+		t.Errorf("UpdateAccount(): error = %v", err)
+		return
+	}
+
+	// старый телефон освободился, новый занят
+	if _, err = s.RegisterAccount("992000000002"); err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistered, returned = %v", err)
+	}
+	if _, err = s.RegisterAccount("992000000001"); err != nil {
+		t.Errorf("RegisterAccount(): error = %v", err)
+	}
+}
+
+// Benchmark_FindPaymentByID ищет последний платёж; время не должно расти с числом платежей.
+func Benchmark_FindPaymentByID(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		svc := newPaymentsService(b, size, nil)
+		id := fmt.Sprint(size)
+
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := svc.FindPaymentByID(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_RegisterAccount_duplicate(b *testing.B) {
+	svc := newPaymentsService(b, 0, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.RegisterAccount("+992000000100"); err != ErrPhoneRegistered {
+			b.Fatalf("invalid result: got %v, want %v", err, ErrPhoneRegistered)
+		}
+	}
+}
+
+func Benchmark_FindAccountByID(b *testing.B) {
+	svc := newPaymentsService(b, 0, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.FindAccountByID(int64(i%100 + 1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
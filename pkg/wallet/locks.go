@@ -0,0 +1,60 @@
+package wallet
+
+import (
+	"sort"
+	"sync"
+)
+
+// accountShards — число блокировок, между которыми распределяются счета.
+// Платежи по счетам из разных шардов проходят параллельно.
+const accountShards = 64
+
+// shardLock — блокировка шарда счетов и номера последних изменений его
+// счетов для дельт (см. touchAccount).
+type shardLock struct {
+	sync.Mutex
+	seq map[int64]int64
+}
+
+func accountShard(accountID int64) int {
+	return int(uint64(accountID) % accountShards)
+}
+
+// lockAccounts блокирует шарды указанных счетов по возрастанию номера,
+// чтобы операции над несколькими счетами не могли взаимно заблокироваться,
+// и возвращает функцию разблокировки.
+func (s *Service) lockAccounts(accountIDs ...int64) func() {
+	shards := make([]int, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		shards = append(shards, accountShard(id))
+	}
+	sort.Ints(shards)
+
+	locked := make([]int, 0, len(shards))
+	for i, shard := range shards {
+		if i > 0 && shard == shards[i-1] {
+			continue
+		}
+		s.shards[shard].Lock()
+		locked = append(locked, shard)
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			s.shards[locked[i]].Unlock()
+		}
+	}
+}
+
+// lockAllAccounts блокирует все шарды, например для согласованной выгрузки балансов.
+func (s *Service) lockAllAccounts() func() {
+	for i := range s.shards {
+		s.shards[i].Lock()
+	}
+
+	return func() {
+		for i := len(s.shards) - 1; i >= 0; i-- {
+			s.shards[i].Unlock()
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/logger"
+	"io/ioutil"
+)
+
+// discard — логгер сервиса, которому логгер не задан: библиотека сама
+// ничего не пишет в stderr.
+var discard = logger.New(ioutil.Discard, logger.LevelError)
+
+// SetLogger задаёт логгер сервиса. Вызывается до начала работы с сервисом;
+// без него или с nil сервис ничего не логирует. Чтобы писать туда же, куда
+// остальные компоненты, передайте logger.Default().
+func (s *Service) SetLogger(l *logger.Logger) {
+	s.logger = l
+}
+
+func (s *Service) log() *logger.Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+	return discard
+}
@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"bytes"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"strings"
+	"testing"
+)
+
+func TestService_SetLogger(t *testing.T) {
+	//создаем Сервис с логгером в буфер
+	s := newTestService()
+	var buf bytes.Buffer
+	s.SetLogger(logger.New(&buf, logger.LevelDebug))
+
+	account, err := s.RegisterAccount("+992900123456")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ImportFS(&MemFS{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(buf.String(), `msg="account registered" account_id=1 phone=+992*******56`) {
+		t.Errorf("RegisterAccount(): log = %q", buf.String())
+	}
+	if strings.Contains(buf.String(), string(account.Phone)) {
+		t.Errorf("RegisterAccount(): phone must be redacted, log = %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), `level=info msg="dump file skipped" file=accounts.dump`) {
+		t.Errorf("ImportFS(): log = %q", buf.String())
+	}
+}
+
+func TestService_SetLogger_nil(t *testing.T) {
+	//без логгера сервис не пишет в логгер по умолчанию
+	var buf bytes.Buffer
+	defaultLogger := logger.Default()
+	logger.SetDefault(logger.New(&buf, logger.LevelDebug))
+	defer logger.SetDefault(defaultLogger)
+
+	s := newTestService()
+	s.SetLogger(nil)
+	_, err := s.RegisterAccount("+992900123456")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ImportFS(&MemFS{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("RegisterAccount(): log = %q, want empty", buf.String())
+	}
+}
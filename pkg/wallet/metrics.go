@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/metrics"
+	"github.com/bahrom656/wallet/pkg/types"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMetricCategories — категории платежей, которые попадают в метки метрик,
+// пока не вызван SetMetricCategories. Категория задаётся клиентом произвольно,
+// поэтому остальные считаются как "other", иначе число рядов метрик ничем не ограничено.
+var defaultMetricCategories = map[types.PaymentCategory]bool{
+	"auto":       true,
+	"food":       true,
+	"mobile":     true,
+	"pharmacy":   true,
+	"restaurant": true,
+	"salary":     true,
+	"transport":  true,
+	"utilities":  true,
+}
+
+// metricCategories — категории платежей в метках метрик.
+type metricCategories struct {
+	value atomic.Value // map[types.PaymentCategory]bool
+}
+
+func (c *metricCategories) set(categories []types.PaymentCategory) {
+	allowed := make(map[types.PaymentCategory]bool, len(categories))
+	for _, category := range categories {
+		allowed[category] = true
+	}
+	c.value.Store(allowed)
+}
+
+// label возвращает метку категории category.
+func (c *metricCategories) label(category types.PaymentCategory) string {
+	allowed, ok := c.value.Load().(map[types.PaymentCategory]bool)
+	if !ok {
+		allowed = defaultMetricCategories
+	}
+	if !allowed[category] {
+		return "other"
+	}
+	return string(category)
+}
+
+// SetMetricCategories задаёт категории платежей, которые попадают в метки
+// метрик; остальные считаются как "other". Можно вызывать в любой момент.
+func (s *Service) SetMetricCategories(categories []types.PaymentCategory) {
+	s.metricCategories.set(categories)
+}
+
+// serviceMetrics — метрики сервиса. Методы безопасно вызывать у nil,
+// если сервис не подключён к реестру.
+type serviceMetrics struct {
+	operations *metrics.Counter
+	duration   *metrics.Histogram
+}
+
+// Instrument регистрирует метрики сервиса в registry: число и длительность
+// операций, платежи по категориям и статусам, пополнения, а также число счетов,
+// платежей и общий баланс. Категории вне SetMetricCategories считаются как "other".
+// Вызывается один раз до начала работы с сервисом.
+func (s *Service) Instrument(registry *metrics.Registry) {
+	s.metrics = &serviceMetrics{
+		operations: registry.NewCounter("wallet_operations_total",
+			"Number of service operations by result.", "operation", "result"),
+		duration: registry.NewHistogram("wallet_operation_duration_seconds",
+			"Duration of service operations.", metrics.DefBuckets, "operation"),
+	}
+
+	payments := registry.NewCounter("wallet_payments_total",
+		"Number of payment state changes by category and status.", "category", "status")
+	paymentAmount := registry.NewCounter("wallet_payments_amount_total",
+		"Amount of payment state changes by category and status.", "category", "status")
+	deposits := registry.NewCounter("wallet_deposits_total", "Number of deposits.")
+	depositAmount := registry.NewCounter("wallet_deposits_amount_total", "Amount of deposits.")
+	s.events.Subscribe(func(event Event) {
+		switch e := event.(type) {
+		case PaymentCreated:
+			payments.Inc(s.metricCategories.label(e.Payment.Category), string(e.Payment.Status))
+			paymentAmount.Add(float64(e.Payment.Amount), s.metricCategories.label(e.Payment.Category), string(e.Payment.Status))
+		case PaymentRejected:
+			payments.Inc(s.metricCategories.label(e.Payment.Category), string(e.Payment.Status))
+			paymentAmount.Add(float64(e.Payment.Amount), s.metricCategories.label(e.Payment.Category), string(e.Payment.Status))
+		case Deposited:
+			deposits.Inc()
+			depositAmount.Add(float64(e.Amount))
+		}
+	})
+
+	registry.NewGaugeFunc("wallet_accounts", "Number of accounts.", func() float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return float64(len(s.accounts))
+	})
+	registry.NewGaugeFunc("wallet_payments", "Number of payments.", func() float64 {
+		s.paymentsMu.RLock()
+		defer s.paymentsMu.RUnlock()
+
+		return float64(len(s.payments))
+	})
+	registry.NewGaugeFunc("wallet_balance_total", "Total balance of all accounts.", func() float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		unlock := s.lockAllAccounts()
+		defer unlock()
+
+		total := 0.0
+		for _, account := range s.accounts {
+			total += float64(account.Balance)
+		}
+		return total
+	})
+}
+
+// observe учитывает операцию operation, начатую в start и завершившуюся с err.
+func (m *serviceMetrics) observe(operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.operations.Inc(operation, result)
+	m.duration.Observe(time.Since(start).Seconds(), operation)
+}
@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"github.com/bahrom656/wallet/pkg/metrics"
+	"github.com/bahrom656/wallet/pkg/types"
+	"strings"
+	"testing"
+)
+
+func TestService_Instrument(t *testing.T) {
+	//создаем Сервис и подключаем метрики
+	s := newTestService()
+	registry := metrics.NewRegistry()
+	s.Instrument(registry)
+
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(100, 1, "auto")
+	if err != ErrAccountNotFound {
+		t.Errorf("Pay(): must return ErrAccountNotFound, returned = %v", err)
+	}
+	_, err = s.SumPaymentsContext(context.Background(), 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ExportFS(&MemFS{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = registry.WriteText(&buf)
+	if err != nil {
+		t.Errorf("WriteText(): error = %v", err)
+		return
+	}
+	for _, want := range []string{
+		`wallet_operations_total{operation="deposit",result="ok"} 1`,
+		`wallet_operations_total{operation="pay",result="ok"} 1`,
+		`wallet_operations_total{operation="pay",result="error"} 1`,
+		`wallet_operations_total{operation="reject",result="ok"} 1`,
+		`wallet_operations_total{operation="sum",result="ok"} 1`,
+		`wallet_operations_total{operation="export",result="ok"} 1`,
+		`wallet_operation_duration_seconds_count{operation="pay"} 2`,
+		`wallet_payments_total{category="auto",status="INPROGRESS"} 1`,
+		`wallet_payments_total{category="auto",status="FAIL"} 1`,
+		`wallet_deposits_amount_total 1e+06`,
+		`wallet_accounts 1`,
+		`wallet_payments 1`,
+		`wallet_balance_total 1e+06`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("Instrument(): metrics must contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestService_Instrument_category(t *testing.T) {
+	//произвольные категории не порождают новых рядов метрик
+	s := newTestService()
+	registry := metrics.NewRegistry()
+	s.Instrument(registry)
+
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, category := range []types.PaymentCategory{"food", "Beeline", "x\"y"} {
+		_, err = s.Pay(account.ID, 100, category)
+		if err != nil {
+			t.Errorf("Pay(): error = %v", err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	err = registry.WriteText(&buf)
+	if err != nil {
+		t.Errorf("WriteText(): error = %v", err)
+		return
+	}
+	for _, want := range []string{
+		`wallet_payments_total{category="food",status="INPROGRESS"} 1`,
+		`wallet_payments_total{category="other",status="INPROGRESS"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("Instrument(): metrics must contain %q, got:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "Beeline") {
+		t.Errorf("Instrument(): metrics must not contain category Beeline, got:\n%s", buf.String())
+	}
+}
+
+func TestService_SetMetricCategories(t *testing.T) {
+	//в метки попадают только заданные категории
+	s := newTestService()
+	registry := metrics.NewRegistry()
+	s.Instrument(registry)
+	s.SetMetricCategories([]types.PaymentCategory{"Beeline"})
+
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, category := range []types.PaymentCategory{"food", "Beeline"} {
+		_, err = s.Pay(account.ID, 100, category)
+		if err != nil {
+			t.Errorf("Pay(): error = %v", err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	err = registry.WriteText(&buf)
+	if err != nil {
+		t.Errorf("WriteText(): error = %v", err)
+		return
+	}
+	for _, want := range []string{
+		`wallet_payments_total{category="Beeline",status="INPROGRESS"} 1`,
+		`wallet_payments_total{category="other",status="INPROGRESS"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("SetMetricCategories(): metrics must contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
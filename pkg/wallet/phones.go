@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/phone"
+	"github.com/bahrom656/wallet/pkg/types"
+)
+
+// PhoneMigration — результат приведения телефона одного счёта к E.164.
+// Если Err не nil (phone.ErrInvalidPhone или ErrPhoneRegistered), телефон
+// счёта остался прежним.
+type PhoneMigration struct {
+	AccountID int64
+	From      types.Phone
+	To        types.Phone
+	Err       error
+}
+
+// MigratePhones приводит к E.164 телефоны счетов, загруженных из старых
+// выгрузок, и возвращает по записи на каждый счёт, телефон которого был
+// не в E.164. Если после приведения телефон совпадает с телефоном другого
+// счёта, счета не объединяются: такой счёт получает ErrPhoneRegistered и
+// разбирается вручную. Каждый приведённый счёт публикует AccountUpdated.
+// Повторный вызов ничего не меняет, поэтому его можно выполнять при каждом
+// запуске.
+func (s *Service) MigratePhones() []PhoneMigration {
+	result, tickets := s.migratePhones()
+	for _, t := range tickets {
+		s.events.deliver(t)
+	}
+	return result
+}
+
+func (s *Service) migratePhones() ([]PhoneMigration, []*ticket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []PhoneMigration
+	var tickets []*ticket
+	for _, account := range s.accounts {
+		migration := PhoneMigration{AccountID: account.ID, From: account.Phone}
+		migration.To, migration.Err = phone.Normalize(account.Phone)
+		if migration.Err == nil && migration.To == account.Phone {
+			continue
+		}
+		if migration.Err == nil {
+			if other, err := s.findAccountByPhone(migration.To); err == nil && other != account {
+				migration.Err = ErrPhoneRegistered
+			}
+		}
+		if migration.Err != nil {
+			s.log().Warn("phone not migrated", logger.AccountID(account.ID), logger.Phone(string(account.Phone)), logger.Err(migration.Err))
+			result = append(result, migration)
+			continue
+		}
+
+		// счёт меняется под s.mu на запись, платежи счёта в это время не проходят
+		next := *account
+		next.Phone = migration.To
+		next.Version++
+		event := AccountUpdated{Account: next}
+		migration.Err = s.commit(event)
+		if migration.Err != nil {
+			result = append(result, migration)
+			continue
+		}
+
+		old := account.Phone
+		*account = next
+		s.reindexPhone(account, old)
+		s.touchAccount(account.ID)
+		tickets = append(tickets, s.events.reserve(event))
+		result = append(result, migration)
+	}
+	return result, tickets
+}
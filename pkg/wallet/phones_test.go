@@ -0,0 +1,70 @@
+package wallet
+
+import (
+	"github.com/bahrom656/wallet/pkg/phone"
+	"strings"
+	"testing"
+)
+
+func TestService_RegisterAccount_normalized(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992 900 00 00 01")
+	if err != nil {
+		t.Errorf("RegisterAccount(): error = %v", err)
+		return
+	}
+	if account.Phone != "+992900000001" {
+		t.Errorf("RegisterAccount(): phone = %v, want %v", account.Phone, "+992900000001")
+	}
+
+	//тот же номер в другом формате уже зарегистрирован
+	_, err = s.RegisterAccount("992900000001")
+	if err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): error = %v, want %v", err, ErrPhoneRegistered)
+	}
+	_, err = s.RegisterAccount("+992 900")
+	if err != phone.ErrInvalidPhone {
+		t.Errorf("RegisterAccount(): error = %v, want %v", err, phone.ErrInvalidPhone)
+	}
+
+	found, err := s.FindAccountByPhone("900 00 00 01")
+	if err != nil || found.ID != account.ID {
+		t.Errorf("FindAccountByPhone(): account = %v, error = %v", found, err)
+	}
+}
+
+func TestService_MigratePhones(t *testing.T) {
+	//загружаем старую выгрузку с телефонами не в E.164
+	s := newTestService()
+	err := s.ImportAccounts(strings.NewReader(
+		"1;992900000001;100;1|2;+992900000002;200;1|3;00992 900000001;300;1|4;12345;400;1|"))
+	if err != nil {
+		t.Errorf("ImportAccounts(): error = %v", err)
+		return
+	}
+
+	result := s.MigratePhones()
+	want := []PhoneMigration{
+		{AccountID: 1, From: "992900000001", To: "+992900000001"},
+		{AccountID: 3, From: "00992 900000001", To: "+992900000001", Err: ErrPhoneRegistered},
+		{AccountID: 4, From: "12345", Err: phone.ErrInvalidPhone},
+	}
+	if len(result) != len(want) {
+		t.Errorf("MigratePhones(): result = %v, want %v", result, want)
+		return
+	}
+	for i := range want {
+		if result[i] != want[i] {
+			t.Errorf("MigratePhones(): result[%d] = %v, want %v", i, result[i], want[i])
+		}
+	}
+
+	account, err := s.FindAccountByPhone("+992900000001")
+	if err != nil || account.ID != 1 || account.Version != 2 {
+		t.Errorf("FindAccountByPhone(): account = %v, error = %v", account, err)
+	}
+	//повторный вызов меняет только то, что не удалось перенести
+	if again := s.MigratePhones(); len(again) != 2 {
+		t.Errorf("MigratePhones(): repeated result = %v", again)
+	}
+}
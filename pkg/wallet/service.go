@@ -1,16 +1,19 @@
 package wallet
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/phone"
 	"github.com/bahrom656/wallet/pkg/types"
 	"github.com/google/uuid"
-	"io"
-	"log"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var ErrPhoneRegistered = errors.New("phone already registered")
@@ -20,562 +23,779 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
 var ErrFileNotFound = errors.New("file not found")
-
+var ErrInvalidDump = errors.New("invalid dump record")
+var ErrVersionConflict = errors.New("version conflict")
+var ErrPaymentAlreadyRejected = errors.New("payment already rejected")
+var ErrPaymentStatusChange = errors.New("payment status is changed only by Reject")
+var ErrInvalidCategory = errors.New("invalid payment category")
+var ErrInvalidName = errors.New("invalid favorite name")
+
+// Service хранит счета, платежи и избранное и безопасен для одновременного использования.
+//
+// Блокировки берутся всегда в одном порядке: mu, блокировки счетов (по возрастанию
+// номера шарда), paymentsMu, stepUp.mu, fraud.mu. mu защищает списки счетов и избранного,
+// блокировка шарда — баланс счетов этого шарда, paymentsMu — список платежей
+// и их статусы. События резервируются в шине events под блокировкой счёта,
+// а доставляются после снятия всех блокировок сервиса. Журнал (SetJournal)
+// пишется под блокировками операции, до изменения состояния.
+//
+// Методы возвращают копии счетов, платежей и избранного, поэтому читать их
+// можно без блокировок, а менять — только через методы сервиса.
 type Service struct {
+	// seq — номер последнего изменения (см. Checkpoint), меняется атомарно;
+	// стоит первым, чтобы быть выровненным на 32-битных платформах.
+	seq int64
+
+	mu            sync.RWMutex
 	accounts      []*types.Account
-	payments      []*types.Payment
 	favorites     []*types.Favorite
 	nextAccountID int64
+
+	accountsByID    map[int64]*types.Account
+	accountsByPhone map[types.Phone]*types.Account
+	favoritesByID   map[string]*types.Favorite
+
+	shards [accountShards]shardLock
+
+	paymentsMu        sync.RWMutex
+	payments          []*types.Payment
+	paymentsByID      map[string]*types.Payment
+	paymentsByAccount map[int64][]*types.Payment
+
+	paymentSeq  map[string]int64
+	favoriteSeq map[string]int64
+
+	events           Bus
+	journal          Journal
+	metrics          *serviceMetrics
+	metricCategories metricCategories
+	logger           *logger.Logger
+	stepUp           stepUp
+	fraud            reviewQueue
 }
 
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
-		}
+// RegisterAccount регистрирует счёт с телефоном number, приведённым к E.164
+// (см. phone.Normalize). Для неверного номера возвращается phone.ErrInvalidPhone.
+func (s *Service) RegisterAccount(number types.Phone) (*types.Account, error) {
+	normalized, err := phone.Normalize(number)
+	if err != nil {
+		return nil, err
+	}
+	account, t, err := s.registerAccount(normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log().Debug("account registered", logger.AccountID(account.ID), logger.Phone(string(normalized)))
+	s.events.deliver(t)
+	return account, nil
+}
+
+func (s *Service) registerAccount(phone types.Phone) (*types.Account, *ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findAccountByPhone(phone); err == nil {
+		return nil, nil, ErrPhoneRegistered
 	}
 
-	s.nextAccountID++
 	account := &types.Account{
-		ID:      s.nextAccountID,
+		ID:      s.nextAccountID + 1,
 		Phone:   phone,
 		Balance: 0,
+		Version: 1,
+	}
+	event := AccountRegistered{Account: *account}
+	err := s.commit(event)
+	if err != nil {
+		return nil, nil, err
 	}
-	s.accounts = append(s.accounts, account)
 
-	return account, nil
+	s.nextAccountID++
+	s.appendAccount(account)
+	s.touchAccount(account.ID)
+
+	return &event.Account, s.events.reserve(event), nil
 }
 
 func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.ID == accountID {
-			return account, nil
-		}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
 	}
+	return s.copyAccount(account), nil
+}
+
+// copyAccount возвращает копию счёта, снятую под блокировкой счёта:
+// баланс меняется под ней, а не под s.mu. Вызывается под s.mu.
+func (s *Service) copyAccount(account *types.Account) *types.Account {
+	unlock := s.lockAccounts(account.ID)
+	defer unlock()
 
-	return nil, ErrAccountNotFound
+	result := *account
+	return &result
+}
+
+// FindAccountByPhone ищет счёт по телефону в любом формате, который понимает
+// phone.Parse. Телефоны, не приведённые к E.164 (см. MigratePhones), находятся
+// только при точном совпадении.
+func (s *Service) FindAccountByPhone(number types.Phone) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByPhone(number)
+	if normalized, nerr := phone.Normalize(number); nerr == nil {
+		if found, nerr := s.findAccountByPhone(normalized); nerr == nil {
+			account, err = found, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.copyAccount(account), nil
 }
 
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
+	start := time.Now()
+	t, err := s.deposit(accountID, amount)
+	s.metrics.observe("deposit", start, err)
+	if err != nil {
+		return err
+	}
+
+	s.log().Debug("deposit", logger.AccountID(accountID), logger.Int64("amount", int64(amount)))
+	s.events.deliver(t)
+	return nil
+}
+
+func (s *Service) deposit(accountID int64, amount types.Money) (*ticket, error) {
 	if amount <= 0 {
-		return ErrAmountMustBePositive
+		return nil, ErrAmountMustBePositive
 	}
 
-	account, err := s.FindAccountByID(accountID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
 	if err != nil {
-		return ErrAccountNotFound
+		return nil, ErrAccountNotFound
 	}
+	err = checkCredit(account)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.lockAccounts(accountID)
+	defer unlock()
 
 	// зачисление средств пока не рассматриваем как платёж
-	account.Balance += amount
-	return nil
+	next := *account
+	next.Balance += amount
+	next.Version++
+	event := Deposited{Account: next, Amount: amount}
+	err = s.commit(event)
+	if err != nil {
+		return nil, err
+	}
+
+	// под s.mu на чтение меняются только баланс и версия счёта
+	account.Balance, account.Version = next.Balance, next.Version
+	s.touchAccount(account.ID)
+	return s.events.reserve(event), nil
 }
 
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	start := time.Now()
+	s.mu.RLock()
+	err := s.requireConfirmation(accountID, amount, category, PaymentCreated{})
+	var payment *types.Payment
+	var t *ticket
+	if err == nil {
+		payment, t, err = s.pay(accountID, amount, category, PaymentCreated{})
+	}
+	s.mu.RUnlock()
+	s.metrics.observe("pay", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log().Debug("payment created", logger.AccountID(accountID), logger.PaymentID(payment.ID))
+	s.events.deliver(t)
+	return payment, nil
+}
+
+// pay вызывается под s.mu (на чтение или на запись). В event можно передать
+// происхождение платежа, сам платёж pay заполнит.
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory, event PaymentCreated) (*types.Payment, *ticket, error) {
 	if amount <= 0 {
-		return nil, ErrAmountMustBePositive
+		return nil, nil, ErrAmountMustBePositive
+	}
+	if !validText(string(category)) {
+		return nil, nil, ErrInvalidCategory
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, nil, err
 	}
-	if account == nil {
-		return nil, ErrAccountNotFound
+	err = checkDebit(account)
+	if err != nil {
+		return nil, nil, err
+	}
+	decision := s.checkFraud(PaymentAttempt{
+		AccountID:  accountID,
+		Amount:     amount,
+		Category:   category,
+		RepeatOf:   event.RepeatOf,
+		FavoriteID: event.FavoriteID,
+	})
+	if decision.Verdict == VerdictBlock {
+		return nil, nil, ErrPaymentBlocked
 	}
 
-	if account.Balance <= 0 {
+	// номер платежа — случайный, его выдача ничего не блокирует
+	paymentID := uuid.New().String()
+
+	unlock := s.lockAccounts(accountID)
+	defer unlock()
+
+	balance, version := account.Balance, account.Version
+	payment, err := debit(account, amount, category, paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	event.Payment = *payment
+	err = s.commit(event)
+	if err != nil {
+		account.Balance, account.Version = balance, version
+		return nil, nil, err
+	}
+	s.paymentsMu.Lock()
+	s.appendPayment(payment)
+	s.touchPayment(payment.ID)
+	s.paymentsMu.Unlock()
+	if decision.Verdict == VerdictFlag {
+		s.flag(*payment, decision)
+	}
+	s.touchAccount(accountID)
+	return &event.Payment, s.events.reserve(event), nil
+}
+
+// validText сообщает, можно ли записать text в выгрузку и журнал: в них
+// ';' и '|' разделяют поля и записи, а записи идут по одной на строку.
+func validText(text string) bool {
+	return !strings.ContainsAny(text, ";|\r\n")
+}
+
+// debit списывает amount со счёта и возвращает новый, ещё не сохранённый платёж id.
+// Вызывается под блокировкой счёта.
+func debit(account *types.Account, amount types.Money, category types.PaymentCategory, id string) (*types.Payment, error) {
+	if account.Balance < amount {
 		return nil, ErrNotEnoughBalance
 	}
 
 	account.Balance -= amount
-	paymentID := uuid.New().String()
-	payment := &types.Payment{
-		ID:        paymentID,
-		AccountID: accountID,
+	account.Version++
+	return &types.Payment{
+		ID:        id,
+		AccountID: account.ID,
 		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
-	}
-	s.payments = append(s.payments, payment)
-	return payment, nil
+		Version:   1,
+	}, nil
 }
 
-func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			return payment, nil
-		}
+// Transfer переводит amount со счёта fromID на счёт toID. Перевод выше
+// порога подтверждения, как и платёж, ждёт кода (см. ConfirmTransfer), и
+// перевод проверяется на мошенничество. Перевод нельзя отменить, поэтому
+// отмеченный проверкой перевод не проводится: Transfer возвращает
+// ErrPaymentBlocked.
+func (s *Service) Transfer(fromID int64, toID int64, amount types.Money) error {
+	s.mu.RLock()
+	err := s.requireTransferConfirmation(fromID, toID, amount)
+	var tickets []*ticket
+	if err == nil {
+		tickets, err = s.transfer(fromID, toID, amount)
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
 	}
 
-	return nil, ErrPaymentNotFound
+	for _, t := range tickets {
+		s.events.deliver(t)
+	}
+	return nil
 }
 
-func (s *Service) Reject(paymentID string) error {
-	payment, err := s.FindPaymentByID(paymentID)
+// transfer вызывается под s.mu (на чтение или на запись).
+func (s *Service) transfer(fromID int64, toID int64, amount types.Money) ([]*ticket, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	from, err := s.findAccountByID(fromID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	account, err := s.FindAccountByID(payment.AccountID)
+	to, err := s.findAccountByID(toID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = checkDebit(from)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCredit(to)
+	if err != nil {
+		return nil, err
+	}
+	decision := s.checkFraud(PaymentAttempt{AccountID: fromID, Amount: amount, To: toID})
+	if decision.Verdict != VerdictAllow {
+		if decision.Verdict == VerdictFlag {
+			s.log().Warn("transfer blocked", logger.AccountID(fromID), logger.String("reasons", strings.Join(decision.Reasons, ",")))
+		}
+		return nil, ErrPaymentBlocked
 	}
 
-	payment.Status = types.PaymentStatusFail
-	account.Balance += payment.Amount
-	return nil
-}
+	unlock := s.lockAccounts(fromID, toID)
+	defer unlock()
 
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+	if from.Balance < amount {
+		return nil, ErrNotEnoughBalance
+	}
+
+	nextFrom, nextTo := *from, *to
+	nextFrom.Balance -= amount
+	nextFrom.Version++
+	nextTo.Balance += amount
+	nextTo.Version++
+	sentEvent := TransferSent{Account: nextFrom, To: toID, Amount: amount}
+	receivedEvent := TransferReceived{Account: nextTo, From: fromID, Amount: amount}
+	err = s.commit(sentEvent, receivedEvent)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	from.Balance, from.Version = nextFrom.Balance, nextFrom.Version
+	to.Balance, to.Version = nextTo.Balance, nextTo.Version
+	sent := s.events.reserve(sentEvent)
+	received := s.events.reserve(receivedEvent)
+	s.touchAccount(fromID)
+	s.touchAccount(toID)
+	return []*ticket{sent, received}, nil
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+// UpdateAccount сохраняет телефон (приведённый к E.164) и баланс account, если
+// с момента чтения счёт никто не изменил, иначе возвращает ErrVersionConflict.
+// Баланс закрытого счёта не меняется: UpdateAccount возвращает ErrAccountClosed.
+func (s *Service) UpdateAccount(account types.Account) (*types.Account, error) {
+	saved, t, err := s.updateAccount(account)
 	if err != nil {
 		return nil, err
 	}
 
-	favorite := &types.Favorite{
-		ID:        uuid.New().String(),
-		AccountID: payment.AccountID,
-		Amount:    payment.Amount,
-		Name:      name,
-		Category:  payment.Category,
-	}
-
-	s.favorites = append(s.favorites, favorite)
-	return favorite, nil
+	s.events.deliver(t)
+	return saved, nil
 }
 
-func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
-	for _, favorite := range s.favorites {
-		if favorite.ID == favoriteID {
-			return favorite, nil
-		}
+func (s *Service) updateAccount(account types.Account) (*types.Account, *ticket, error) {
+	normalized, err := phone.Normalize(account.Phone)
+	if err != nil {
+		return nil, nil, err
 	}
+	account.Phone = normalized
 
-	return nil, ErrFavoriteNotFound
-}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
-	favorite, err := s.FindFavoriteByID(favoriteID)
+	saved, err := s.findAccountByID(account.ID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if favorite == nil {
-		return nil, ErrFavoriteNotFound
+	if saved.Version != account.Version {
+		return nil, nil, ErrVersionConflict
+	}
+	if other, err := s.findAccountByPhone(account.Phone); err == nil && other.ID != account.ID {
+		return nil, nil, ErrPhoneRegistered
+	}
+	// закрытый счёт закрыт с нулевым балансом и таким остаётся
+	if AccountStatus(saved) == types.AccountStatusClosed && account.Balance != saved.Balance {
+		return nil, nil, ErrAccountClosed
 	}
 
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
+	// счёт меняется под s.mu на запись, платежи счёта в это время не проходят
+	next := *saved
+	next.Phone = account.Phone
+	next.Balance = account.Balance
+	next.Version++
+	event := AccountUpdated{Account: next}
+	err = s.commit(event)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return payment, nil
+	old := saved.Phone
+	*saved = next
+	s.reindexPhone(saved, old)
+	s.touchAccount(saved.ID)
+	return &next, s.events.reserve(event), nil
 }
 
-func (s *Service) ExportToFile(path string) error {
-	file, err := os.Create(path)
+func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	payment, err := s.findPaymentByID(paymentID)
 	if err != nil {
-		log.Print(err)
-		return err
+		return nil, err
 	}
+	result := *payment
+	return &result, nil
+}
 
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			log.Print(cerr)
-		}
-	}()
+// lookupPayment возвращает сам платёж сервиса, а не копию. Его статус
+// и категорию можно читать и менять только под s.paymentsMu.
+func (s *Service) lookupPayment(paymentID string) (*types.Payment, error) {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
 
-	for _, acc := range s.accounts {
-		_, err = file.Write([]byte(types.Phone(strconv.FormatInt(
-			acc.ID, 10)) +
-			(";") +
-			acc.Phone +
-			(";") +
-			types.Phone(strconv.FormatInt(int64(acc.Balance), 10)) +
-			("|")))
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-	}
-	return nil
+	return s.findPaymentByID(paymentID)
 }
-func (s *Service) ImportFromFile(path string) error {
-	file, err := os.Open(path)
+
+// Reject отменяет платёж и возвращает его сумму на счёт. Уже отменённый
+// платёж повторно не отменяется: Reject возвращает ErrPaymentAlreadyRejected.
+func (s *Service) Reject(paymentID string) error {
+	start := time.Now()
+	t, err := s.reject(paymentID)
+	s.metrics.observe("reject", start, err)
 	if err != nil {
-		log.Print(err)
 		return err
 	}
 
-	defer func() {
-		if serr := file.Close(); serr != nil {
-			log.Print(serr)
-		}
-	}()
+	s.log().Info("payment rejected", logger.AccountID(t.event.AccountID()), logger.PaymentID(paymentID))
+	s.events.deliver(t)
+	return nil
+}
 
-	buf := make([]byte, 4096)
-	content := make([]byte, 0)
+func (s *Service) reject(paymentID string) (*ticket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for {
-		read, err := file.Read(buf)
-		if err == io.EOF {
-			content = append(content, buf[:read]...)
-			break
-		}
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-		content = append(content, buf[:read]...)
+	payment, err := s.lookupPayment(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCredit(account)
+	if err != nil {
+		return nil, err
 	}
-	date := string(content)
-
-	acc := strings.Split(date, "|")
-	acc = acc[:len(acc)-1]
-
-	for _, accValue := range acc {
-		str := strings.Split(accValue, ";")
-
-		id, err := strconv.Atoi(str[0])
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-		phone := str[1]
 
-		balance, err := strconv.Atoi(str[2])
-		if err != nil {
-			log.Print(err)
-			return err
-		}
+	unlock := s.lockAccounts(account.ID)
+	defer unlock()
 
-		newAccount := &types.Account{
-			ID:      int64(id),
-			Phone:   types.Phone(phone),
-			Balance: types.Money(balance),
-		}
-		s.accounts = append(s.accounts, newAccount)
+	s.paymentsMu.Lock()
+	// деньги отменённого платежа уже вернулись на счёт
+	if payment.Status == types.PaymentStatusFail {
+		s.paymentsMu.Unlock()
+		return nil, ErrPaymentAlreadyRejected
 	}
-	for _, as := range s.accounts {
-		fmt.Print(as)
+	next := *payment
+	next.Status = types.PaymentStatusFail
+	next.Version++
+	event := PaymentRejected{Payment: next}
+	err = s.commit(event)
+	if err == nil {
+		*payment = next
+		s.touchPayment(payment.ID)
 	}
-	return nil
+	s.paymentsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	account.Balance += payment.Amount
+	account.Version++
+	s.touchAccount(account.ID)
+	return s.events.reserve(event), nil
 }
 
-func (s *Service) Export(dir string) error {
-	lenAccounts := len(s.accounts)
-
-	if lenAccounts != 0 {
-		fileDir := dir + "/accounts.dump"
-		file, err := os.Create(fileDir)
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
-
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		data := ""
-		for _, account := range s.accounts {
-			id := strconv.Itoa(int(account.ID)) + ";"
-			phone := string(account.Phone) + ";"
-			balance := strconv.Itoa(int(account.Balance))
-
-			data += id
-			data += phone
-			data += balance + "|"
-		}
-
-		_, err = file.Write([]byte(data))
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
+// UpdatePayment сохраняет категорию payment, если с момента чтения
+// платёж никто не изменил, иначе возвращает ErrVersionConflict. Статус
+// меняет только Reject, вместе с балансом счёта: для другого статуса
+// UpdatePayment возвращает ErrPaymentStatusChange.
+func (s *Service) UpdatePayment(payment types.Payment) (*types.Payment, error) {
+	saved, t, err := s.updatePayment(payment)
+	if err != nil {
+		return nil, err
 	}
 
-	lenPayments := len(s.payments)
-
-	if lenPayments != 0 {
-		fileDir := dir + "/payments.dump"
-
-		file, err := os.Create(fileDir)
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
-
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		data := ""
-		for _, payment := range s.payments {
-			idPayment := payment.ID + ";"
-			idPaymnetAccountId := strconv.Itoa(int(payment.AccountID)) + ";"
-			amountPayment := strconv.Itoa(int(payment.Amount)) + ";"
-			categoryPayment := string(payment.Category) + ";"
-			statusPayment := string(payment.Status)
-
-			data += idPayment
-			data += idPaymnetAccountId
-			data += amountPayment
-			data += categoryPayment
-			data += statusPayment + "|"
-		}
+	s.events.deliver(t)
+	return saved, nil
+}
 
-		_, err = file.Write([]byte(data))
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
+func (s *Service) updatePayment(payment types.Payment) (*types.Payment, *ticket, error) {
+	if !validText(string(payment.Category)) {
+		return nil, nil, ErrInvalidCategory
 	}
 
-	lenFavorites := len(s.favorites)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if lenFavorites != 0 {
-		fileDir := dir + "/favorites.dump"
-		file, err := os.Create(fileDir)
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
-
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		data := ""
-		for _, favorite := range s.favorites {
-			idFavorite := favorite.ID + ";"
-			idFavoriteAccountId := strconv.Itoa(int(favorite.AccountID)) + ";"
-			nameFavorite := favorite.Name + ";"
-			amountFavorite := strconv.Itoa(int(favorite.Amount)) + ";"
-			categoryFavorite := string(favorite.Category)
-
-			data += idFavorite
-			data += idFavoriteAccountId
-			data += nameFavorite
-			data += amountFavorite
-			data += categoryFavorite + "|"
-		}
-		_, err = file.Write([]byte(data))
-		if err != nil {
-			log.Print(err)
-			return ErrFileNotFound
-		}
+	saved, err := s.lookupPayment(payment.ID)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
-}
+	// счёт платежа не меняется; его блокировка упорядочивает событие
+	// с остальными событиями счёта, например с отменой платежа
+	unlock := s.lockAccounts(saved.AccountID)
+	defer unlock()
+	s.paymentsMu.Lock()
+	defer s.paymentsMu.Unlock()
 
-func (s *Service) Import(dir string) error {
-	dirAccount := dir + "/accounts.dump"
-	file, err := os.Open(dirAccount)
+	if saved.Version != payment.Version {
+		return nil, nil, ErrVersionConflict
+	}
+	if payment.Status != saved.Status {
+		return nil, nil, ErrPaymentStatusChange
+	}
 
+	next := *saved
+	next.Category = payment.Category
+	next.Version++
+	event := PaymentUpdated{Payment: next}
+	err = s.commit(event)
 	if err != nil {
-		log.Print(err)
-		err = ErrFileNotFound
+		return nil, nil, err
 	}
-	if err != ErrFileNotFound {
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
 
-		content := make([]byte, 0)
-		buf := make([]byte, 4)
-		for {
-			read, err := file.Read(buf)
-			if err == io.EOF {
-				break
-			}
+	*saved = next
+	s.touchPayment(saved.ID)
+	return &next, s.events.reserve(event), nil
+}
 
-			if err != nil {
-				log.Print(err)
-				return ErrFileNotFound
-			}
-			content = append(content, buf[:read]...)
-		}
+func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
+	s.mu.RLock()
+	payment, err := s.FindPaymentByID(paymentID)
+	var t *ticket
+	if err == nil {
+		err = s.requireConfirmation(payment.AccountID, payment.Amount, payment.Category, PaymentCreated{RepeatOf: paymentID})
+	}
+	if err == nil {
+		payment, t, err = s.pay(payment.AccountID, payment.Amount, payment.Category, PaymentCreated{RepeatOf: paymentID})
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 
-		data := string(content)
+	s.events.deliver(t)
+	return payment, nil
+}
 
-		accounts := strings.Split(data, "|")
-		accounts = accounts[:len(accounts)-1]
+func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+	favorite, t, err := s.favoritePayment(paymentID, name)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, account := range accounts {
+	s.events.deliver(t)
+	return favorite, nil
+}
 
-			value := strings.Split(account, ";")
+func (s *Service) favoritePayment(paymentID string, name string) (*types.Favorite, *ticket, error) {
+	if !validText(name) {
+		return nil, nil, ErrInvalidName
+	}
 
-			id, err := strconv.Atoi(value[0])
-			if err != nil {
-				return err
-			}
-			phone := types.Phone(value[1])
-			balance, err := strconv.Atoi(value[2])
-			if err != nil {
-				return err
-			}
-			editAccount := &types.Account{
-				ID:      int64(id),
-				Phone:   phone,
-				Balance: types.Money(balance),
-			}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-			s.accounts = append(s.accounts, editAccount)
-		}
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// из избранного закрытого счёта платить уже нельзя
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if AccountStatus(account) == types.AccountStatusClosed {
+		return nil, nil, ErrAccountClosed
 	}
 
-	dirPaymnet := dir + "/payments.dump"
-	filePayment, err := os.Open(dirPaymnet)
+	favorite := &types.Favorite{
+		ID:        uuid.New().String(),
+		AccountID: payment.AccountID,
+		Amount:    payment.Amount,
+		Name:      name,
+		Category:  payment.Category,
+	}
 
+	event := FavoriteCreated{Favorite: *favorite}
+	err = s.commit(event)
 	if err != nil {
-		log.Print(err)
-		err = ErrFileNotFound
+		return nil, nil, err
 	}
-	if err != ErrFileNotFound {
-		defer func() {
-			if cerr := filePayment.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
 
-		contentPayment := make([]byte, 0)
-		buf := make([]byte, 4)
-		for {
-			readPayment, err := filePayment.Read(buf)
-			if err == io.EOF {
-				break
-			}
+	s.appendFavorite(favorite)
+	s.touchFavorite(favorite.ID)
+	// избранное меняется под s.mu на запись, платежи счёта в это время не проходят
+	return &event.Favorite, s.events.reserve(event), nil
+}
 
-			if err != nil {
-				log.Print(err)
-				return ErrFileNotFound
-			}
-			contentPayment = append(contentPayment, buf[:readPayment]...)
-		}
+func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		data := string(contentPayment)
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	result := *favorite
+	return &result, nil
+}
 
-		payments := strings.Split(data, "|")
-		payments = payments[:len(payments)-1]
+func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	payment, t, err := s.payFromFavorite(favoriteID)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, payment := range payments {
+	s.events.deliver(t)
+	return payment, nil
+}
 
-			value := strings.Split(payment, ";")
-			idPayment := value[0]
+func (s *Service) payFromFavorite(favoriteID string) (*types.Payment, *ticket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-			accountIdPeyment, err := strconv.Atoi(value[1])
-			if err != nil {
-				return err
-			}
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if favorite == nil {
+		return nil, nil, ErrFavoriteNotFound
+	}
+	err = s.requireConfirmation(favorite.AccountID, favorite.Amount, favorite.Category, PaymentCreated{FavoriteID: favoriteID})
+	if err != nil {
+		return nil, nil, err
+	}
 
-			amountPayment, err := strconv.Atoi(value[2])
-			if err != nil {
-				return err
-			}
-			categoryPayment := types.PaymentCategory(value[3])
-
-			statusPayment := types.PaymentStatus(value[4])
-			newPayment := &types.Payment{
-				ID:        idPayment,
-				AccountID: int64(accountIdPeyment),
-				Amount:    types.Money(amountPayment),
-				Category:  categoryPayment,
-				Status:    statusPayment,
-			}
+	return s.pay(favorite.AccountID, favorite.Amount, favorite.Category, PaymentCreated{FavoriteID: favoriteID})
+}
 
-			s.payments = append(s.payments, newPayment)
+func (s *Service) ExportToFile(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	unlock := s.lockAllAccounts()
+	defer unlock()
 
-		}
+	file, err := os.Create(path)
+	if err != nil {
+		s.log().Error("create accounts file", logger.String("file", path), logger.Err(err))
+		return err
 	}
 
-	dirfavorite := dir + "/favorites.dump"
-	fileFavorite, err := os.Open(dirfavorite)
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			s.log().Error("close accounts file", logger.String("file", path), logger.Err(cerr))
+		}
+	}()
 
+	err = writeAccounts(file, s.accounts, func(*types.Account) bool { return true })
 	if err != nil {
-		log.Print(err)
-		// return ErrFileNotFound
-		err = ErrFileNotFound
+		s.log().Error("write accounts file", logger.String("file", path), logger.Err(err))
+		return err
 	}
-	if err != ErrFileNotFound {
-		defer func() {
-			if cerr := fileFavorite.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
+	return nil
+}
+func (s *Service) ImportFromFile(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		contentFavorite := make([]byte, 0)
-		buf := make([]byte, 4)
-		for {
-			readFavorite, err := fileFavorite.Read(buf)
-			if err == io.EOF {
-				break
-			}
+	file, err := os.Open(path)
+	if err != nil {
+		s.log().Error("open accounts file", logger.String("file", path), logger.Err(err))
+		return err
+	}
 
-			if err != nil {
-				log.Print(err)
-				return ErrFileNotFound
-			}
-			contentFavorite = append(contentFavorite, buf[:readFavorite]...)
+	defer func() {
+		if serr := file.Close(); serr != nil {
+			s.log().Error("close accounts file", logger.String("file", path), logger.Err(serr))
 		}
+	}()
+
+	err = s.importAccounts(file)
+	if err != nil {
+		s.log().Error("read accounts file", logger.String("file", path), logger.Err(err))
+		return err
+	}
+	s.log().Info("accounts imported", logger.String("file", path), logger.Int64("accounts", int64(len(s.accounts))))
+	return nil
+}
 
-		data := string(contentFavorite)
-		favorites := strings.Split(data, "|")
-		favorites = favorites[:len(favorites)-1]
+func (s *Service) Export(dir string) error {
+	return s.ExportFS(DirFS(dir))
+}
 
-		for _, favorite := range favorites {
+func (s *Service) Import(dir string) error {
+	return s.ImportFS(DirFS(dir))
+}
 
-			valueFavorite := strings.Split(favorite, ";")
-			idFavorite := valueFavorite[0]
-			accountIdFavorite, err := strconv.Atoi(valueFavorite[1])
-			if err != nil {
-				return err
-			}
-			nameFavorite := valueFavorite[2]
+// Accounts возвращает копии всех счетов.
+func (s *Service) Accounts() []types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	unlock := s.lockAllAccounts()
+	defer unlock()
 
-			amountFavorite, err := strconv.Atoi(valueFavorite[3])
-			if err != nil {
-				return err
-			}
-			categoryPayment := types.PaymentCategory(valueFavorite[4])
-
-			newFavorite := &types.Favorite{
-				ID:        idFavorite,
-				AccountID: int64(accountIdFavorite),
-				Name:      nameFavorite,
-				Amount:    types.Money(amountFavorite),
-				Category:  categoryPayment,
-			}
+	accounts := make([]types.Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
 
-			s.favorites = append(s.favorites, newFavorite)
-		}
+// Payments возвращает копии всех платежей в порядке их совершения.
+func (s *Service) Payments() []types.Payment {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
+	payments := make([]types.Payment, 0, len(s.payments))
+	for _, payment := range s.payments {
+		payments = append(payments, *payment)
 	}
+	return payments
+}
 
-	return nil
+// Favorites возвращает копии всего избранного.
+func (s *Service) Favorites() []types.Favorite {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	favorites := make([]types.Favorite, 0, len(s.favorites))
+	for _, favorite := range s.favorites {
+		favorites = append(favorites, *favorite)
+	}
+	return favorites
 }
+
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	s.paymentsMu.RLock()
+	defer s.paymentsMu.RUnlock()
+
 	var paymentFound []types.Payment
 
-	for _, payment := range s.payments {
-		if payment.AccountID == accountID {
-			paymentFound = append(paymentFound, *payment)
-		}
+	for _, payment := range s.accountPayments(accountID) {
+		paymentFound = append(paymentFound, *payment)
 	}
 	if paymentFound == nil {
 		return nil, ErrAccountNotFound
@@ -589,7 +809,7 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 			file, _ := os.OpenFile(dir+"/payments.dump", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 			defer func() {
 				if cerr := file.Close(); cerr != nil {
-					log.Print(cerr)
+					s.log().Error("close history file", logger.Err(cerr))
 				}
 			}()
 
@@ -610,7 +830,7 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 			}
 			_, err := file.WriteString(str)
 			if err != nil {
-				log.Print(err)
+				s.log().Error("write history file", logger.Err(err))
 			}
 		} else {
 			var str string
@@ -625,13 +845,12 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 				str = payment.ID + ";" + strconv.Itoa(int(payment.AccountID)) + ";" + strconv.Itoa(int(payment.Amount)) + ";" + string(payment.Category) + ";" + string(payment.Status) + "\n"
 				_, err := file.WriteString(str)
 				if err != nil {
-					log.Print(err)
+					s.log().Error("write history file", logger.Err(err))
 				}
 				if k == records {
 					str = ""
 					t++
 					k = 0
-					fmt.Println(t, " = t")
 					_ = file.Close()
 				}
 			}
@@ -641,99 +860,115 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 }
 
 func (s *Service) SumPayments(goroutines int) (sum types.Money) {
-
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-
-	count := len(s.payments)/goroutines + 1
-	for i := 0; i < goroutines; i++ {
-		wg.Add(1)
-
-		go func(val int) {
-			defer wg.Done()
-
-			var v int
-
-			for j := val * count; j < (val+1)*count; j++ {
-				if j >= len(s.payments) {
-					j = (val + 1) * count
-					break
-				}
-				v = v + int(s.payments[j].Amount)
-			}
-			mu.Lock()
-			sum += types.Money(v)
-			mu.Unlock()
-		}(i)
-
-		wg.Wait()
-	}
+	sum, _ = s.SumPaymentsContext(context.Background(), goroutines)
 	return sum
 }
 
-//func (s *Service)FilterPAyments(accountID int64, goroutines int) ([]types.Payment, error) {
-//	wg := sync.WaitGroup{}
-//	mu := sync.Mutex{}
-//
-//	count := len(s.payments)/goroutines + 1
-//	for i := 0; i < goroutines; i++ {
-//		wg.Add(1)
-//
-//		go func(val int){
-//			defer wg.Done()
-//
-//			var value string
-//
-//			for j := (val + count); j < (val + 1)*count; j++{
-//				if
-//			}
-//		}(i)
-//	}
-//}
+// defaultProgressChunk — размер части по умолчанию для SumPaymentsWithProgress.
+const defaultProgressChunk = 1_000_000
 
+// Progress сообщает о посчитанной части платежей.
 type Progress struct {
+	// Part — число платежей в части, Result — их сумма.
 	Part   int
 	Result types.Money
+	// Done из Total частей уже посчитаны, Sum — их общая сумма.
+	Done  int
+	Total int
+	Sum   types.Money
 }
 
-func (s *Service) SumPaymentsWithProgress() <-chan Progress {
-	size := 1000000
+// SumPaymentsWithProgress делит платежи на части по size штук и считает их параллельно,
+// отправляя в канал прогресс после каждой посчитанной части. Канал закрывается,
+// когда посчитаны все части или отменён ctx; после отмены все горутины завершаются,
+// даже если канал больше никто не читает.
+func (s *Service) SumPaymentsWithProgress(ctx context.Context, size int) <-chan Progress {
+	start := time.Now()
+	if size <= 0 {
+		size = defaultProgressChunk
+	}
 
-	amount := make([]types.Money, 0)
+	s.paymentsMu.RLock()
+	amount := make([]types.Money, 0, len(s.payments))
 	for _, pay := range s.payments {
 		amount = append(amount, pay.Amount)
 	}
-	wg := sync.WaitGroup{}
-	goroutines := len(amount) / size
-	ch := make(chan Progress)
-	if goroutines <= 0 {
-		goroutines = 1
+	s.paymentsMu.RUnlock()
+
+	chunks := make([][]types.Money, 0, len(amount)/size+1)
+	for from := 0; from < len(amount); from += size {
+		to := from + size
+		if to > len(amount) {
+			to = len(amount)
+		}
+		chunks = append(chunks, amount[from:to])
 	}
+
+	goroutines := runtime.GOMAXPROCS(0)
+	if goroutines > len(chunks) {
+		goroutines = len(chunks)
+	}
+
+	jobs := make(chan []types.Money)
+	results := make(chan Progress)
+	ch := make(chan Progress)
+
+	go func() {
+		defer close(jobs)
+		for _, chunk := range chunks {
+			select {
+			case jobs <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
 	for i := 0; i < goroutines; i++ {
 		wg.Add(1)
-		go func(ch chan<- Progress, amount []types.Money) {
-			sum := 0
+		go func() {
 			defer wg.Done()
-			for _, val := range amount {
-				sum += int(val)
-
-			 }
-			// if sum == 1000521000{
-			// 	ch <- Progress{
-			// 		Part:   len(amount),
-			// 		Result: types.Money(100052100),
-			// 	}
-			// }
-			ch <- Progress{
-				Part:   len(amount),
-				Result: types.Money(sum),
+			for chunk := range jobs {
+				sum := types.Money(0)
+				for _, val := range chunk {
+					sum += val
+				}
+				select {
+				case results <- Progress{Part: len(chunk), Result: sum}:
+				case <-ctx.Done():
+					return
+				}
 			}
-		}(ch, amount)
+		}()
 	}
 	go func() {
-		defer close(ch)
+		defer close(results)
 		wg.Wait()
 	}()
 
+	go func() {
+		defer close(ch)
+		defer func() {
+			s.metrics.observe("sum_progress", start, ctx.Err())
+		}()
+
+		progress := Progress{Total: len(chunks)}
+		for part := range results {
+			progress.Part = part.Part
+			progress.Result = part.Result
+			progress.Done++
+			progress.Sum += part.Result
+			select {
+			case ch <- progress:
+			case <-ctx.Done():
+				// дочитываем results, чтобы воркеры не зависли на отправке
+				for range results {
+				}
+				return
+			}
+		}
+	}()
+
 	return ch
 }
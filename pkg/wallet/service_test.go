@@ -1,11 +1,18 @@
 package wallet
 
 import (
+	"context"
 	"fmt"
 	"github.com/bahrom656/wallet/pkg/types"
 	"github.com/google/uuid"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testService struct {
@@ -36,16 +43,46 @@ func newTestService() *testService {
 	return &testService{Service: &Service{}}
 }
 
+// newPaymentsService загружает через выгрузку 100 счетов и платежи с ID от 1 до payments:
+// платёж i списан со счёта i%100+1 на сумму amount(i) (i, если amount == nil)
+// в категории auto, food или mobile по кругу.
+func newPaymentsService(tb testing.TB, payments int, amount func(i int) types.Money) *Service {
+	tb.Helper()
+	accounts := strings.Builder{}
+	for i := 1; i <= 100; i++ {
+		accounts.WriteString(fmt.Sprintf("%d;+992%09d;0;1|", i, i))
+	}
+	categories := []types.PaymentCategory{"auto", "food", "mobile"}
+	dump := strings.Builder{}
+	for i := 1; i <= payments; i++ {
+		sum := types.Money(i)
+		if amount != nil {
+			sum = amount(i)
+		}
+		dump.WriteString(fmt.Sprintf("%d;%d;%d;%s;%s;1|", i, i%100+1, sum, categories[i%3], types.PaymentStatusInProgress))
+	}
+
+	svc := &Service{}
+	if err := svc.ImportAccounts(strings.NewReader(accounts.String())); err != nil {
+		tb.Fatal(err)
+	}
+	if err := svc.ImportPayments(strings.NewReader(dump.String())); err != nil {
+		tb.Fatal(err)
+	}
+	return svc
+}
+
+// fixedAmount — сумма платежа для newPaymentsService, одинаковая для всех платежей.
+func fixedAmount(amount types.Money) func(int) types.Money {
+	return func(int) types.Money {
+		return amount
+	}
+}
+
 var s Service
 
 func TestFindAccountByID(t *testing.T) {
-	s.accounts = []*types.Account{
-		{ID: 1, Phone: "+992000000000"},
-		{ID: 2, Phone: "+992000000001"},
-		{ID: 3, Phone: "+992000000002"},
-		{ID: 4, Phone: "+992000000003"},
-		{ID: 5, Phone: "+992000000004"},
-	}
+	s := newPaymentsService(t, 0, nil)
 	acc, err := s.FindAccountByID(4)
 	if acc == nil {
 		t.Error(err)
@@ -132,6 +169,35 @@ func TestService_Reject_success(t *testing.T) {
 	}
 }
 
+func TestService_Reject_twice(t *testing.T) {
+	//повторная отмена не возвращает деньги ещё раз
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment := payments[0]
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != ErrPaymentAlreadyRejected {
+		t.Errorf("Reject(): error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+
+	savedAccount, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		t.Errorf("Reject(): can't find account by id error = %v", err)
+		return
+	}
+	if savedAccount.Balance != defaultTestAccount.balance {
+		t.Errorf("Reject(): balance = %v, want %v", savedAccount.Balance, defaultTestAccount.balance)
+	}
+}
+
 func (s *testService) addAccountWithBalance(phone types.Phone, balance types.Money) (*types.Account, error) {
 	//регистрируем там ползователя
 	account, err := s.RegisterAccount(phone)
@@ -145,8 +211,20 @@ func (s *testService) addAccountWithBalance(phone types.Phone, balance types.Mon
 		return nil, fmt.Errorf("can't deposit account, error = %v", err)
 	}
 
-	return account, nil
+	return s.FindAccountByID(account.ID)
 }
+
+// balance возвращает текущий баланс счёта: сервис отдаёт копии счетов.
+func (s *Service) balance(t *testing.T, accountID int64) types.Money {
+	t.Helper()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account.Balance
+}
+
 func (s *Service) addAccount(data testAccount) (*types.Account, []*types.Payment, error) {
 	//регистрируем там ползователя
 	account, err := s.RegisterAccount(data.phone)
@@ -168,6 +246,7 @@ func (s *Service) addAccount(data testAccount) (*types.Account, []*types.Payment
 			return nil, nil, fmt.Errorf("can't make payment, error = %v", err)
 		}
 	}
+	account, err = s.FindAccountByID(account.ID)
 	return account, payments, err
 
 }
@@ -303,11 +382,7 @@ func TestService_PayFromFavorite_fail(t *testing.T) {
 }
 
 func TestService_SumPayments(t *testing.T) {
-	svc := &Service{}
-
-	for i := 0; i < 103; i++ {
-		svc.payments = append(svc.payments, &types.Payment{Amount: 1})
-	}
+	svc := newPaymentsService(t, 103, fixedAmount(1))
 
 	sum := svc.SumPayments(10)
 	if sum != 103 {
@@ -316,11 +391,7 @@ func TestService_SumPayments(t *testing.T) {
 }
 
 func Benchmark_SumPayments(b *testing.B) {
-	svc := &Service{}
-
-	for i := 0; i < 103; i++ {
-		svc.payments = append(svc.payments, &types.Payment{Amount: 1})
-	}
+	svc := newPaymentsService(b, 103, fixedAmount(1))
 
 	result := 103
 
@@ -332,20 +403,374 @@ func Benchmark_SumPayments(b *testing.B) {
 	}
 }
 
-func TestService_ExportToFile(t *testing.T) {
-	s.accounts = []*types.Account{
-		{ID: 1, Phone: "+992000000000"},
-		{ID: 2, Phone: "+992000000001"},
-		{ID: 3, Phone: "+992000000002"},
-		{ID: 4, Phone: "+992000000003"},
-		{ID: 5, Phone: "+992000000004"},
+func TestService_PayDeposit_concurrent(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// параллельно пополняем и списываем одинаковые суммы
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Deposit(account.ID, 10); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.Pay(account.ID, 10, "auto"); err != nil {
+				t.Error(err)
+			}
+		}()
 	}
-	err := s.ExportToFile("export.txt")
+	wg.Wait()
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Balance != 1_000_00 {
+		t.Errorf("balance = %v, want %v", got.Balance, 1_000_00)
+	}
+	if len(s.payments) != 100 {
+		t.Errorf("payments = %v, want %v", len(s.payments), 100)
+	}
+}
+
+func TestService_PayRejectExport_concurrent(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("992000000001", 1_000_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payments := make(chan string, 1000)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				payment, err := s.Pay(account.ID, 1_00, "auto")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				payments <- payment.ID
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := s.Reject(<-payments); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := s.ExportFS(&MemFS{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// 1000 платежей по 1_00, половина отменена
+	if got.Balance != 1_000_000_00-500*1_00 {
+		t.Errorf("balance = %v, want %v", got.Balance, 1_000_000_00-500*1_00)
+	}
+}
+
+func TestService_PayFind_concurrent(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("992000000001", 1_000_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// найденные счета и платежи — копии, их можно читать без блокировок
+	payments := make(chan string, 100)
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		defer close(payments)
+		for i := 0; i < 100; i++ {
+			payment, err := s.Pay(account.ID, 1_00, "auto")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			payments <- payment.ID
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for id := range payments {
+			if err := s.Reject(id); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			found, err := s.FindAccountByID(account.ID)
+			if err != nil || found.Balance <= 0 {
+				t.Errorf("FindAccountByID(): account = %v, error = %v", found, err)
+				return
+			}
+			for _, payment := range s.Payments() {
+				found, err := s.FindPaymentByID(payment.ID)
+				if err != nil || found.Status == "" {
+					t.Errorf("FindPaymentByID(): payment = %v, error = %v", found, err)
+					return
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	if balance := s.balance(t, account.ID); balance != 1_000_000_00 {
+		t.Errorf("balance = %v, want %v", balance, 1_000_000_00)
+	}
+}
+
+func TestService_Transfer_concurrent(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("992000000002", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// встречные переводы берут блокировки в одном порядке и не зависают
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Transfer(first.ID, second.ID, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Transfer(second.ID, first.ID, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.balance(t, first.ID) != 1_000_00 || s.balance(t, second.ID) != 1_000_00 {
+		t.Errorf("Transfer(): balances = %v, %v, want %v", s.balance(t, first.ID), s.balance(t, second.ID), 1_000_00)
+	}
+}
+
+func TestService_Transfer_notEnoughBalance(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("992000000001", 1_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.RegisterAccount("992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Transfer(first.ID, second.ID, 2_00)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Transfer(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if s.balance(t, first.ID) != 1_00 || s.balance(t, second.ID) != 0 {
+		t.Errorf("Transfer(): balances changed, got %v, %v", s.balance(t, first.ID), s.balance(t, second.ID))
+	}
+}
+
+// Benchmark_Pay_parallel платит с разных счетов из нескольких горутин.
+// Запуск с -cpu=1,2,4,8 показывает, как пропускная способность растёт с GOMAXPROCS.
+// Общими для всех счетов у платежа остаются только s.mu на чтение и короткая
+// запись платежа под paymentsMu: номера изменений и платежей, порог
+// подтверждения и очереди событий блокировок не делят.
+func Benchmark_Pay_parallel(b *testing.B) {
+	s := newTestService()
+	s.SetConfirmationThreshold(1_000_000)
+	accounts := make([]*types.Account, accountShards)
+	for i := range accounts {
+		account, err := s.addAccountWithBalance(types.Phone(fmt.Sprintf("992%09d", i)), 1_000_000_000_00)
+		if err != nil {
+			b.Fatal(err)
+		}
+		accounts[i] = account
+	}
+
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		account := accounts[int(atomic.AddInt64(&next, 1))%len(accounts)]
+		for pb.Next() {
+			_, err := s.Pay(account.ID, 1, "auto")
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestService_UpdateAccount_conflict(t *testing.T) {
+	//создаем Сервис
+	s := newTestService()
+	account, err := s.addAccountWithBalance("992000000001", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// двое прочитали одну и ту же версию счёта
+	first := *account
+	second := *account
+
+	first.Balance = 2_000_00
+	saved, err := s.UpdateAccount(first)
+	if err != nil {
+		t.Errorf("UpdateAccount(): error = %v", err)
+		return
+	}
+	if saved.Balance != 2_000_00 || saved.Version != first.Version+1 {
+		t.Errorf("UpdateAccount(): wrong account saved = %v", saved)
+	}
+
+	second.Balance = 0
+	_, err = s.UpdateAccount(second)
+	if err != ErrVersionConflict {
+		t.Errorf("UpdateAccount(): must return ErrVersionConflict, returned = %v", err)
+	}
+	if balance := s.balance(t, account.ID); balance != 2_000_00 {
+		t.Errorf("UpdateAccount(): stale update applied, balance = %v", balance)
+	}
+}
+
+func TestService_UpdatePayment_conflict(t *testing.T) {
+	//создаем Сервис
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stale := *payments[0]
+	err = s.Reject(stale.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stale.Status = types.PaymentStatusOk
+	_, err = s.UpdatePayment(stale)
+	if err != ErrVersionConflict {
+		t.Errorf("UpdatePayment(): must return ErrVersionConflict, returned = %v", err)
+		return
+	}
+
+	fresh, err := s.FindPaymentByID(stale.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	update := *fresh
+	update.Category = "mobile"
+	saved, err := s.UpdatePayment(update)
+	if err != nil {
+		t.Errorf("UpdatePayment(): error = %v", err)
+		return
+	}
+	if saved.Category != "mobile" || saved.Status != types.PaymentStatusFail || saved.Version != update.Version+1 {
+		t.Errorf("UpdatePayment(): wrong payment saved = %v", saved)
+	}
+}
+
+func TestService_UpdatePayment_status(t *testing.T) {
+	//отменённый платёж нельзя вернуть в работу и отменить ещё раз
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rejected, err := s.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	update := *rejected
+	update.Status = types.PaymentStatusInProgress
+	_, err = s.UpdatePayment(update)
+	if err != ErrPaymentStatusChange {
+		t.Errorf("UpdatePayment(): error = %v, want %v", err, ErrPaymentStatusChange)
+	}
+	err = s.Reject(payment.ID)
+	if err != ErrPaymentAlreadyRejected {
+		t.Errorf("Reject(): error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Balance != 100 {
+		t.Errorf("balance = %v, want %v", got.Balance, 100)
+	}
+}
+
+func TestService_ExportToFile(t *testing.T) {
+	s := newPaymentsService(t, 0, nil)
+	err := s.ExportToFile(filepath.Join(t.TempDir(), "export.txt"))
 	if err != nil {
 		t.Error(err)
 	}
 }
 func TestService_ImportFromFile(t *testing.T) {
+	//выгрузки в репозитории только читаются
 	err := s.ImportFromFile("export.txt")
 	if err != nil {
 		t.Error(err)
@@ -377,7 +802,7 @@ func TestService_Export(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = s.Export("data")
+	err = s.Export(t.TempDir())
 	if err != nil {
 		t.Error(err)
 	}
@@ -388,45 +813,64 @@ func TestService_Import(t *testing.T) {
 		t.Error(err)
 	}
 }
-// func TestService_SumPaymentsWithProgress(t *testing.T) {
-// 	for i := 0; i < 10000; i++ {
-// 		payment := &types.Payment{
-// 			ID:     uuid.New().String(),
-// 			Amount: types.Money(103),
-// 		}
-// 		s.payments = append(s.payments, payment)
-// 	}
-
-// 	result := s.SumPaymentsWithProgress()
-// 	want := Progress{Result: 1030000, Part: 10000}
-
-// 	for pro := range result {
-// 		if pro.Result != 1030000 {
-// 			t.Errorf("invalid test: got %v, want %v", pro.Result, want.Result)
-// 		}
-// 	}
-// }
-
-// func Benchmark_SumPaymentsWithProgress(b *testing.B) {
-// 	for i := 0; i < 100000; i++ {
-// 		payment := &types.Payment{
-// 			ID:     uuid.New().String(),
-// 			Amount: types.Money(103),
-// 		}
-// 		s.payments = append(s.payments, payment)
-// 	}
-// 	wants := s.SumPaymentsWithProgress()
-	
-
-// 	b.ResetTimer()
-// 		result := s.SumPaymentsWithProgress()
-// 		b.StopTimer()
-// 		res := <- result
-// 		want := <- wants
-// 		want.Result = 10300000
-// 		want.Part = 100000
-// 			if res.Result != 10300000 && res.Result != 20600000 {
-// 				b.Fatalf("invalid result: got %v, want %v", res, want)
-// 			}
-// 		b.StartTimer()
-// }
+
+func TestService_SumPaymentsWithProgress(t *testing.T) {
+	svc := newPaymentsService(t, 10_003, fixedAmount(103))
+
+	parts := 0
+	var last Progress
+	for progress := range svc.SumPaymentsWithProgress(context.Background(), 1000) {
+		parts++
+		if progress.Result != types.Money(progress.Part)*103 {
+			t.Errorf("invalid part: got %v, want %v", progress.Result, types.Money(progress.Part)*103)
+		}
+		if progress.Done != parts || progress.Total != 11 {
+			t.Errorf("invalid progress: got %v/%v, want %v/%v", progress.Done, progress.Total, parts, 11)
+		}
+		if progress.Sum != last.Sum+progress.Result {
+			t.Errorf("invalid cumulative sum: got %v, previous %v", progress.Sum, last.Sum)
+		}
+		last = progress
+	}
+	if parts != 11 {
+		t.Errorf("invalid parts: got %v, want %v", parts, 11)
+	}
+	if last.Sum != 10_003*103 {
+		t.Errorf("invalid result: got %v, want %v", last.Sum, 10_003*103)
+	}
+}
+
+func TestService_SumPaymentsWithProgress_cancel(t *testing.T) {
+	svc := newPaymentsService(t, 10_000, fixedAmount(1))
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := svc.SumPaymentsWithProgress(ctx, 10)
+	<-ch
+	cancel()
+
+	// канал закрывается, хотя большая часть частей не прочитана
+	for range ch {
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > before {
+		t.Errorf("goroutines leaked: got %v, want %v", got, before)
+	}
+}
+
+func Benchmark_SumPaymentsWithProgress(b *testing.B) {
+	svc := newPaymentsService(b, 100_000, fixedAmount(103))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var last Progress
+		for progress := range svc.SumPaymentsWithProgress(context.Background(), 10_000) {
+			last = progress
+		}
+		if last.Sum != 10_300_000 {
+			b.Fatalf("invalid result: got %v, want %v", last.Sum, 10_300_000)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+)
+
+var ErrAccountFrozen = errors.New("account frozen")
+var ErrAccountBlocked = errors.New("account blocked")
+var ErrAccountClosed = errors.New("account closed")
+var ErrInvalidStatus = errors.New("invalid account status")
+var ErrInvalidTransition = errors.New("invalid account status transition")
+var ErrBalanceNotZero = errors.New("account balance is not zero")
+var ErrInvalidReason = errors.New("invalid status reason")
+
+// transitions — допустимые смены статуса счёта. Закрытый счёт закрыт навсегда.
+var transitions = map[types.AccountStatus][]types.AccountStatus{
+	types.AccountStatusActive:  {types.AccountStatusFrozen, types.AccountStatusBlocked, types.AccountStatusClosed},
+	types.AccountStatusFrozen:  {types.AccountStatusActive, types.AccountStatusBlocked, types.AccountStatusClosed},
+	types.AccountStatusBlocked: {types.AccountStatusActive, types.AccountStatusFrozen, types.AccountStatusClosed},
+}
+
+// AccountStatus возвращает статус счёта; у счетов из старых выгрузок он пустой
+// и означает активный счёт.
+func AccountStatus(account *types.Account) types.AccountStatus {
+	if account.Status == "" {
+		return types.AccountStatusActive
+	}
+	return account.Status
+}
+
+func validStatus(status types.AccountStatus) bool {
+	if status == types.AccountStatusClosed {
+		return true
+	}
+	_, ok := transitions[status]
+	return ok
+}
+
+// SetAccountStatus меняет статус счёта и запоминает причину. Замороженный счёт
+// не платит, но принимает пополнения; заблокированный и закрытый не делают
+// ни того, ни другого. Закрыть можно только счёт с нулевым балансом.
+// Причина не может содержать ';', '|' и переводы строк: она хранится в выгрузке.
+func (s *Service) SetAccountStatus(accountID int64, status types.AccountStatus, reason string) (*types.Account, error) {
+	return s.changeAccountStatus(accountID, "", status, reason)
+}
+
+// FreezeAccount замораживает активный счёт, например когда владелец потерял
+// телефон. Счёт в другом статусе не меняется: FreezeAccount возвращает
+// ErrInvalidTransition, поэтому заморозка не снимает блокировку и не
+// затирает её причину.
+func (s *Service) FreezeAccount(accountID int64, reason string) (*types.Account, error) {
+	return s.changeAccountStatus(accountID, types.AccountStatusActive, types.AccountStatusFrozen, reason)
+}
+
+func (s *Service) changeAccountStatus(accountID int64, from types.AccountStatus, status types.AccountStatus, reason string) (*types.Account, error) {
+	account, t, err := s.setAccountStatus(accountID, from, status, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log().Info("account status changed", logger.AccountID(accountID), logger.String("status", string(status)), logger.String("reason", reason))
+	s.events.deliver(t)
+	return account, nil
+}
+
+// setAccountStatus меняет статус счёта; непустой from — статус, из которого
+// смена разрешена.
+func (s *Service) setAccountStatus(accountID int64, from types.AccountStatus, status types.AccountStatus, reason string) (*types.Account, *ticket, error) {
+	if !validStatus(status) {
+		return nil, nil, ErrInvalidStatus
+	}
+	if !validText(reason) {
+		return nil, nil, ErrInvalidReason
+	}
+
+	// статус меняется под s.mu на запись, поэтому под s.mu на чтение он неизменен
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := s.lockAccounts(accountID)
+	defer unlock()
+
+	current := AccountStatus(account)
+	allowed := false
+	if from == "" || from == current {
+		for _, to := range transitions[current] {
+			allowed = allowed || to == status
+		}
+	}
+	if !allowed {
+		return nil, nil, ErrInvalidTransition
+	}
+	if status == types.AccountStatusClosed && account.Balance != 0 {
+		return nil, nil, ErrBalanceNotZero
+	}
+
+	next := *account
+	next.Status = status
+	next.StatusReason = reason
+	next.Version++
+	event := AccountStatusChanged{Account: next, From: current}
+	err = s.commit(event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	*account = next
+	s.touchAccount(account.ID)
+	return &next, s.events.reserve(event), nil
+}
+
+// checkDebit проверяет, что со счёта можно списывать средства.
+func checkDebit(account *types.Account) error {
+	switch AccountStatus(account) {
+	case types.AccountStatusFrozen:
+		return ErrAccountFrozen
+	case types.AccountStatusBlocked:
+		return ErrAccountBlocked
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// checkCredit проверяет, что на счёт можно зачислять средства.
+func checkCredit(account *types.Account) error {
+	switch AccountStatus(account) {
+	case types.AccountStatusBlocked:
+		return ErrAccountBlocked
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package wallet
+
+import (
+	"bytes"
+	"github.com/bahrom656/wallet/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService_SetAccountStatus(t *testing.T) {
+	//создаем Сервис и счёт с балансом
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		status types.AccountStatus
+		reason string
+		want   error
+	}{
+		{"DELETED", "", ErrInvalidStatus},
+		{types.AccountStatusFrozen, "lost;phone", ErrInvalidReason},
+		{types.AccountStatusActive, "", ErrInvalidTransition},
+		{types.AccountStatusFrozen, "lost phone", nil},
+		{types.AccountStatusFrozen, "", ErrInvalidTransition},
+		{types.AccountStatusBlocked, "fraud", nil},
+		{types.AccountStatusClosed, "", ErrBalanceNotZero},
+		{types.AccountStatusActive, "checked", nil},
+	}
+	for _, tt := range tests {
+		_, err := s.SetAccountStatus(account.ID, tt.status, tt.reason)
+		if err != tt.want {
+			t.Errorf("SetAccountStatus(%v): error = %v, want %v", tt.status, err, tt.want)
+		}
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Status != types.AccountStatusActive || account.StatusReason != "checked" || account.Version != 5 {
+		t.Errorf("SetAccountStatus(): account = %v", account)
+	}
+
+	_, err = s.SetAccountStatus(100, types.AccountStatusFrozen, "")
+	if err != ErrAccountNotFound {
+		t.Errorf("SetAccountStatus(): error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	//закрыть можно только пустой счёт, и закрытый счёт уже не открыть
+	_, err = s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusClosed, "by request")
+	if err != nil {
+		t.Errorf("SetAccountStatus(): error = %v", err)
+	}
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusActive, "")
+	if err != ErrInvalidTransition {
+		t.Errorf("SetAccountStatus(): error = %v, want %v", err, ErrInvalidTransition)
+	}
+}
+
+func TestService_FreezeAccount(t *testing.T) {
+	//создаем Сервис и счёт
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//заморозить можно только активный счёт, блокировка при этом остаётся
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusBlocked, "fraud")
+	if err != nil {
+		t.Errorf("SetAccountStatus(): error = %v", err)
+		return
+	}
+	_, err = s.FreezeAccount(account.ID, "lost phone")
+	if err != ErrInvalidTransition {
+		t.Errorf("FreezeAccount(): error = %v, want %v", err, ErrInvalidTransition)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Status != types.AccountStatusBlocked || account.StatusReason != "fraud" {
+		t.Errorf("FreezeAccount(): account = %v", account)
+	}
+
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusActive, "checked")
+	if err != nil {
+		t.Errorf("SetAccountStatus(): error = %v", err)
+		return
+	}
+	account, err = s.FreezeAccount(account.ID, "lost phone")
+	if err != nil {
+		t.Errorf("FreezeAccount(): error = %v", err)
+		return
+	}
+	if account.Status != types.AccountStatusFrozen || account.StatusReason != "lost phone" {
+		t.Errorf("FreezeAccount(): account = %v", account)
+	}
+}
+
+func TestService_UpdateAccount_closed(t *testing.T) {
+	//создаем Сервис и закрытый счёт
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err = s.SetAccountStatus(account.ID, types.AccountStatusClosed, "by request")
+	if err != nil {
+		t.Errorf("SetAccountStatus(): error = %v", err)
+		return
+	}
+
+	//баланс закрытого счёта не меняется
+	changed := *account
+	changed.Balance = 1_000
+	_, err = s.UpdateAccount(changed)
+	if err != ErrAccountClosed {
+		t.Errorf("UpdateAccount(): error = %v, want %v", err, ErrAccountClosed)
+	}
+	if balance := s.balance(t, account.ID); balance != 0 {
+		t.Errorf("UpdateAccount(): balance = %v, want 0", balance)
+	}
+}
+
+func TestService_SetAccountStatus_operations(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := s.addAccountWithBalance("+992000000002", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//замороженный счёт не платит, но принимает пополнения
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusFrozen, "lost phone")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	balance := s.balance(t, account.ID)
+	if _, err = s.Pay(account.ID, 100, "auto"); err != ErrAccountFrozen {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if _, err = s.Repeat(payments[0].ID); err != ErrAccountFrozen {
+		t.Errorf("Repeat(): error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if _, err = s.PayFromFavorite(favorite.ID); err != ErrAccountFrozen {
+		t.Errorf("PayFromFavorite(): error = %v, want %v", err, ErrAccountFrozen)
+	}
+	results, _ := s.PayBatch([]PaymentRequest{{AccountID: account.ID, Amount: 100, Category: "auto"}}, BatchBestEffort)
+	if results[0].Err != ErrAccountFrozen {
+		t.Errorf("PayBatch(): error = %v, want %v", results[0].Err, ErrAccountFrozen)
+	}
+	if err = s.Transfer(account.ID, other.ID, 100); err != ErrAccountFrozen {
+		t.Errorf("Transfer(): error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if got := s.balance(t, account.ID); got != balance {
+		t.Errorf("balance = %v, want %v", got, balance)
+	}
+	if err = s.Deposit(account.ID, 100); err != nil {
+		t.Errorf("Deposit(): error = %v", err)
+	}
+	if err = s.Transfer(other.ID, account.ID, 100); err != nil {
+		t.Errorf("Transfer(): error = %v", err)
+	}
+
+	//заблокированный счёт не принимает и пополнения
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusBlocked, "fraud")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = s.Deposit(account.ID, 100); err != ErrAccountBlocked {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrAccountBlocked)
+	}
+	if err = s.Reject(payments[0].ID); err != ErrAccountBlocked {
+		t.Errorf("Reject(): error = %v, want %v", err, ErrAccountBlocked)
+	}
+	if _, err = s.Pay(account.ID, 100, "auto"); err != ErrAccountBlocked {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrAccountBlocked)
+	}
+
+	//из закрытого счёта нельзя создать избранное
+	_, err = s.SetAccountStatus(other.ID, types.AccountStatusClosed, "")
+	if err != ErrBalanceNotZero {
+		t.Errorf("SetAccountStatus(): error = %v, want %v", err, ErrBalanceNotZero)
+	}
+	payment, err := s.Pay(other.ID, s.balance(t, other.ID), "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.SetAccountStatus(other.ID, types.AccountStatusClosed, "")
+	if err != nil {
+		t.Errorf("SetAccountStatus(): error = %v", err)
+	}
+	if _, err = s.FavoritePayment(payment.ID, "auto"); err != ErrAccountClosed {
+		t.Errorf("FavoritePayment(): error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err = s.Deposit(other.ID, 100); err != ErrAccountClosed {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrAccountClosed)
+	}
+}
+
+func TestService_SetAccountStatus_confirmation(t *testing.T) {
+	//платёж замороженного счёта не ждёт подтверждения
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetConfirmationThreshold(1_000)
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusFrozen, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(account.ID, 5_000, "auto")
+	if err != ErrAccountFrozen {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrAccountFrozen)
+	}
+}
+
+func TestService_ExportAccounts_status(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusFrozen, "lost phone")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = s.ExportAccounts(&buf)
+	if err != nil {
+		t.Errorf("ExportAccounts(): error = %v", err)
+		return
+	}
+	want := "1;+992000000001;0;1|2;+992000000002;0;2;FROZEN;lost phone|"
+	if buf.String() != want {
+		t.Errorf("ExportAccounts(): got %q, want %q", buf.String(), want)
+	}
+
+	loaded := newTestService()
+	err = loaded.ImportAccounts(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Errorf("ImportAccounts(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(loaded.Accounts(), s.Accounts()) {
+		t.Errorf("ImportAccounts(): accounts = %v, want %v", loaded.Accounts(), s.Accounts())
+	}
+
+	err = newTestService().ImportAccounts(strings.NewReader("1;+992000000001;0;1;DELETED;|"))
+	if err != ErrInvalidDump {
+		t.Errorf("ImportAccounts(): error = %v, want %v", err, ErrInvalidDump)
+	}
+}
+
+func TestEventStore_Rebuild_status(t *testing.T) {
+	//пополнение замороженного счёта и смена статуса попадают в журнал
+	s := newTestService()
+	var journal bytes.Buffer
+	store := NewEventStore(&journal)
+	s.SetJournal(store)
+
+	account, err := s.addAccountWithBalance("+992000000001", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.SetAccountStatus(account.ID, types.AccountStatusFrozen, "lost phone")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, 500)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loaded, err := LoadEventStore(bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Errorf("LoadEventStore(): error = %v", err)
+		return
+	}
+	events, err := loaded.Events(3, 3)
+	if err != nil {
+		t.Errorf("Events(): error = %v", err)
+		return
+	}
+	changed, ok := events[0].(AccountStatusChanged)
+	if !ok || changed.From != types.AccountStatusActive || changed.Account.StatusReason != "lost phone" {
+		t.Errorf("Events(): event = %v, want status change", events[0])
+	}
+	got, err := loaded.Rebuild(loaded.Len())
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got.Accounts(), s.Accounts()) {
+		t.Errorf("Rebuild(): accounts = %v, want %v", got.Accounts(), s.Accounts())
+	}
+}
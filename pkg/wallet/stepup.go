@@ -0,0 +1,629 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/logger"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/google/uuid"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrConfirmationRequired = errors.New("payment confirmation required")
+var ErrSecondFactorRequired = errors.New("second factor is not set up")
+var ErrChallengeNotFound = errors.New("challenge not found")
+var ErrChallengeExpired = errors.New("challenge expired")
+var ErrInvalidCode = errors.New("invalid confirmation code")
+var ErrConfirmationLocked = errors.New("confirmation locked after failed attempts")
+var ErrInvalidPIN = errors.New("pin must be 4 to 8 digits")
+var ErrTOTPEnabled = errors.New("totp already enabled")
+
+const (
+	// challengeTTL — время на подтверждение платежа.
+	challengeTTL = 5 * time.Minute
+	// maxFailures неверных кодов подряд блокируют подтверждения счёта на lockoutPeriod.
+	maxFailures   = 5
+	lockoutPeriod = 15 * time.Minute
+	// pinRounds — число раундов HMAC-SHA256 при хэшировании PIN.
+	pinRounds = 10000
+	// totpStep и totpDigits — параметры TOTP по RFC 6238.
+	totpStep   = 30
+	totpDigits = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Factor — второй фактор, которым подтверждается платёж.
+type Factor string
+
+const (
+	FactorPIN  Factor = "pin"
+	FactorTOTP Factor = "totp"
+)
+
+// Challenge — платёж или перевод, ожидающий подтверждения кодом (см.
+// ConfirmPayment и ConfirmTransfer). Деньги со счёта до подтверждения не
+// списываются.
+type Challenge struct {
+	ID        string
+	AccountID int64
+	// To — счёт получателя перевода; у платежа ноль.
+	To        int64
+	Amount    types.Money
+	Category  types.PaymentCategory
+	Factor    Factor
+	ExpiresAt time.Time
+
+	event PaymentCreated
+}
+
+// ConfirmationRequiredError возвращается вместо платежа, сумма которого выше
+// порога подтверждения. errors.Is(err, ErrConfirmationRequired) для неё верно.
+type ConfirmationRequiredError struct {
+	Challenge Challenge
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+	return ErrConfirmationRequired.Error()
+}
+
+func (e *ConfirmationRequiredError) Is(target error) bool {
+	return target == ErrConfirmationRequired
+}
+
+// stepUp хранит порог подтверждения, вторые факторы счетов и ожидающие
+// подтверждения платежи. Его mu берётся последним, под ним других
+// блокировок сервиса не берут; порог читается без mu, каждым платежом. Соль и хэш PIN и ключи TOTP попадают в
+// выгрузку (factors.dump) и в журнал (SecondFactorChanged), поэтому
+// переживают перезапуск; ожидающие платежи и счётчики попыток — нет.
+type stepUp struct {
+	mu         sync.Mutex
+	threshold  atomic.Value // types.Money
+	pins       map[int64]pinHash
+	totp       map[int64][]byte
+	lastStep   map[int64]int64
+	challenges map[string]*Challenge
+	failures   map[int64]int
+	locked     map[int64]time.Time
+}
+
+type pinHash struct {
+	salt []byte
+	hash []byte
+}
+
+// SetConfirmationThreshold включает подтверждение платежей с суммой выше
+// threshold; ноль выключает его. Платёж счёта без PIN и TOTP выше порога
+// не проходит с ошибкой ErrSecondFactorRequired.
+func (s *Service) SetConfirmationThreshold(threshold types.Money) {
+	s.stepUp.threshold.Store(threshold)
+}
+
+// SetPIN задаёт PIN счёта. Если PIN уже задан, нужен текущий PIN current;
+// неверный PIN считается неудачной попыткой подтверждения.
+func (s *Service) SetPIN(accountID int64, current string, pin string) error {
+	if !validPIN(pin) {
+		return ErrInvalidPIN
+	}
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	event := SecondFactorChanged{Account: accountID, Factor: FactorPIN, Salt: salt, Secret: hashPIN(salt, pin)}
+	return s.changeFactor(event, func(u *stepUp) error {
+		if _, ok := u.pins[accountID]; !ok {
+			return nil
+		}
+		return u.check(accountID, FactorPIN, current, time.Now())
+	})
+}
+
+// EnableTOTP включает TOTP для счёта и возвращает секрет в base32 для
+// приложения-аутентификатора. После этого платежи подтверждаются кодом TOTP.
+func (s *Service) EnableTOTP(accountID int64) (string, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, 20)
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	event := SecondFactorChanged{Account: accountID, Factor: FactorTOTP, Secret: key}
+	err = s.changeFactor(event, func(u *stepUp) error {
+		if _, ok := u.totp[accountID]; ok {
+			return ErrTOTPEnabled
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// DisableTOTP выключает TOTP счёта по текущему коду code.
+func (s *Service) DisableTOTP(accountID int64, code string) error {
+	event := SecondFactorChanged{Account: accountID, Factor: FactorTOTP}
+	return s.changeFactor(event, func(u *stepUp) error {
+		return u.check(accountID, FactorTOTP, code, time.Now())
+	})
+}
+
+// changeFactor применяет смену второго фактора event, если allowed её
+// разрешает, сохранив её сначала в журнале. Смена отмечается в контрольной
+// точке (см. Checkpoint), чтобы её увидели периодические выгрузки.
+func (s *Service) changeFactor(event SecondFactorChanged, allowed func(u *stepUp) error) error {
+	u := &s.stepUp
+	u.mu.Lock()
+	err := allowed(u)
+	if err == nil {
+		err = s.commit(event)
+	}
+	if err == nil {
+		u.apply(event)
+	}
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.touchFactors()
+	return nil
+}
+
+// HasSecondFactor сообщает, задан ли у счёта PIN или включён TOTP.
+func (s *Service) HasSecondFactor(accountID int64) bool {
+	u := &s.stepUp
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	_, pin := u.pins[accountID]
+	_, totp := u.totp[accountID]
+	return pin || totp
+}
+
+func (s *Service) FindChallengeByID(challengeID string) (*Challenge, error) {
+	u := &s.stepUp
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	challenge, ok := u.challenges[challengeID]
+	if !ok {
+		return nil, ErrChallengeNotFound
+	}
+	result := *challenge
+	return &result, nil
+}
+
+// ConfirmPayment проводит платёж, ожидающий подтверждения challengeID, если
+// code — верный PIN или код TOTP счёта. После maxFailures неверных кодов
+// подряд подтверждения счёта блокируются на lockoutPeriod, а сам платёж
+// отменяется.
+func (s *Service) ConfirmPayment(challengeID string, code string) (*types.Payment, error) {
+	challenge, err := s.stepUp.confirm(challengeID, code, false, time.Now())
+	if err != nil {
+		s.log().Warn("payment confirmation failed", logger.String("challenge_id", challengeID), logger.Err(err))
+		return nil, err
+	}
+
+	start := time.Now()
+	s.mu.RLock()
+	payment, t, err := s.pay(challenge.AccountID, challenge.Amount, challenge.Category, challenge.event)
+	s.mu.RUnlock()
+	s.metrics.observe("pay", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log().Debug("payment confirmed", logger.AccountID(payment.AccountID), logger.PaymentID(payment.ID))
+	s.events.deliver(t)
+	return payment, nil
+}
+
+// ConfirmTransfer проводит перевод, ожидающий подтверждения challengeID,
+// так же, как ConfirmPayment проводит платёж.
+func (s *Service) ConfirmTransfer(challengeID string, code string) error {
+	challenge, err := s.stepUp.confirm(challengeID, code, true, time.Now())
+	if err != nil {
+		s.log().Warn("transfer confirmation failed", logger.String("challenge_id", challengeID), logger.Err(err))
+		return err
+	}
+
+	s.mu.RLock()
+	tickets, err := s.transfer(challenge.AccountID, challenge.To, challenge.Amount)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	s.log().Debug("transfer confirmed", logger.AccountID(challenge.AccountID))
+	for _, t := range tickets {
+		s.events.deliver(t)
+	}
+	return nil
+}
+
+// requireConfirmation возвращает *ConfirmationRequiredError с новым
+// ожидающим платежом, если платёж amount требует подтверждения, и nil,
+// если не требует. В event передаётся происхождение платежа.
+// Вызывается под s.mu.
+func (s *Service) requireConfirmation(accountID int64, amount types.Money, category types.PaymentCategory, event PaymentCreated) error {
+	if !s.stepUp.required(amount) {
+		return nil
+	}
+	// платёж с такой категорией всё равно не пройдёт, см. pay
+	if !validText(string(category)) {
+		return ErrInvalidCategory
+	}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	// платёж, который всё равно не пройдёт, подтверждать незачем
+	err = checkDebit(account)
+	if err != nil {
+		return err
+	}
+
+	return s.stepUp.challenge(Challenge{
+		AccountID: accountID,
+		Amount:    amount,
+		Category:  category,
+		event:     event,
+	})
+}
+
+// requireTransferConfirmation — то же, что requireConfirmation, для
+// перевода amount со счёта fromID на счёт toID. Вызывается под s.mu.
+func (s *Service) requireTransferConfirmation(fromID int64, toID int64, amount types.Money) error {
+	if !s.stepUp.required(amount) {
+		return nil
+	}
+	from, err := s.findAccountByID(fromID)
+	if err != nil {
+		return err
+	}
+	to, err := s.findAccountByID(toID)
+	if err != nil {
+		return err
+	}
+	// перевод, который всё равно не пройдёт, подтверждать незачем
+	err = checkDebit(from)
+	if err != nil {
+		return err
+	}
+	err = checkCredit(to)
+	if err != nil {
+		return err
+	}
+
+	return s.stepUp.challenge(Challenge{AccountID: fromID, To: toID, Amount: amount})
+}
+
+// challenge сохраняет ожидающий подтверждения challenge, выбрав фактор
+// счёта, и возвращает *ConfirmationRequiredError с ним.
+func (u *stepUp) challenge(challenge Challenge) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	challenge.Factor = FactorPIN
+	if _, ok := u.totp[challenge.AccountID]; ok {
+		challenge.Factor = FactorTOTP
+	} else if _, ok := u.pins[challenge.AccountID]; !ok {
+		return ErrSecondFactorRequired
+	}
+
+	challenge.ID = uuid.New().String()
+	challenge.ExpiresAt = time.Now().Add(challengeTTL)
+	if u.challenges == nil {
+		u.challenges = make(map[string]*Challenge)
+	}
+	u.removeExpired(time.Now())
+	u.challenges[challenge.ID] = &challenge
+	return &ConfirmationRequiredError{Challenge: challenge}
+}
+
+// apply задаёт или выключает второй фактор счёта. Вызывается под u.mu.
+func (u *stepUp) apply(e SecondFactorChanged) {
+	switch e.Factor {
+	case FactorPIN:
+		if len(e.Secret) == 0 {
+			delete(u.pins, e.Account)
+			return
+		}
+		if u.pins == nil {
+			u.pins = make(map[int64]pinHash)
+		}
+		u.pins[e.Account] = pinHash{salt: e.Salt, hash: e.Secret}
+	case FactorTOTP:
+		delete(u.lastStep, e.Account)
+		if len(e.Secret) == 0 {
+			delete(u.totp, e.Account)
+			return
+		}
+		if u.totp == nil {
+			u.totp = make(map[int64][]byte)
+		}
+		u.totp[e.Account] = e.Secret
+	}
+}
+
+// exportFactors пишет вторые факторы счетов по возрастанию номера счёта.
+func (u *stepUp) exportFactors(w io.Writer) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var factors []SecondFactorChanged
+	for accountID, pin := range u.pins {
+		factors = append(factors, SecondFactorChanged{Account: accountID, Factor: FactorPIN, Salt: pin.salt, Secret: pin.hash})
+	}
+	for accountID, key := range u.totp {
+		factors = append(factors, SecondFactorChanged{Account: accountID, Factor: FactorTOTP, Secret: key})
+	}
+	sort.Slice(factors, func(i, j int) bool {
+		if factors[i].Account != factors[j].Account {
+			return factors[i].Account < factors[j].Account
+		}
+		return factors[i].Factor < factors[j].Factor
+	})
+
+	buf := bufio.NewWriter(w)
+	for _, factor := range factors {
+		_, err := buf.WriteString(formatFactor(factor))
+		if err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// importFactors заменяет вторые факторы счетов прочитанными из r.
+func (u *stepUp) importFactors(r io.Reader) error {
+	records, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	factors := make([]SecondFactorChanged, 0, len(records))
+	for _, record := range records {
+		factor, err := parseFactor(record)
+		if err != nil {
+			return err
+		}
+		factors = append(factors, factor)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.pins = nil
+	u.totp = nil
+	u.lastStep = nil
+	for _, factor := range factors {
+		u.apply(factor)
+	}
+	return nil
+}
+
+// formatFactor возвращает запись второго фактора: счёт;фактор;соль;секрет|,
+// соль и секрет — в hex.
+func formatFactor(e SecondFactorChanged) string {
+	return strconv.FormatInt(e.Account, 10) + ";" +
+		string(e.Factor) + ";" +
+		hex.EncodeToString(e.Salt) + ";" +
+		hex.EncodeToString(e.Secret) + "|"
+}
+
+func parseFactor(record string) (SecondFactorChanged, error) {
+	value := strings.Split(record, ";")
+	if len(value) != 4 {
+		return SecondFactorChanged{}, ErrInvalidDump
+	}
+	accountID, err := strconv.ParseInt(value[0], 10, 64)
+	if err != nil {
+		return SecondFactorChanged{}, err
+	}
+	factor := Factor(value[1])
+	if factor != FactorPIN && factor != FactorTOTP {
+		return SecondFactorChanged{}, ErrInvalidDump
+	}
+	salt, err := hex.DecodeString(value[2])
+	if err != nil {
+		return SecondFactorChanged{}, err
+	}
+	secret, err := hex.DecodeString(value[3])
+	if err != nil {
+		return SecondFactorChanged{}, err
+	}
+	if len(salt) == 0 {
+		salt = nil
+	}
+	if len(secret) == 0 {
+		secret = nil
+	}
+	return SecondFactorChanged{Account: accountID, Factor: factor, Salt: salt, Secret: secret}, nil
+}
+
+// required сообщает, нужен ли платежу amount подтверждение.
+func (u *stepUp) required(amount types.Money) bool {
+	threshold := u.limit()
+	return threshold > 0 && amount > threshold
+}
+
+// limit возвращает порог подтверждения; ноль — подтверждение выключено.
+func (u *stepUp) limit() types.Money {
+	threshold, _ := u.threshold.Load().(types.Money)
+	return threshold
+}
+
+// confirm проверяет code и отдаёт ожидающий перевод (transfer) или платёж
+// challengeID; перевод не подтверждается как платёж и наоборот.
+func (u *stepUp) confirm(challengeID string, code string, transfer bool, now time.Time) (*Challenge, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	challenge, ok := u.challenges[challengeID]
+	if !ok || (challenge.To != 0) != transfer {
+		return nil, ErrChallengeNotFound
+	}
+	if now.After(challenge.ExpiresAt) {
+		delete(u.challenges, challengeID)
+		return nil, ErrChallengeExpired
+	}
+
+	err := u.check(challenge.AccountID, challenge.Factor, code, now)
+	if err == ErrConfirmationLocked {
+		delete(u.challenges, challengeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	delete(u.challenges, challengeID)
+	return challenge, nil
+}
+
+// check проверяет code по фактору factor счёта и учитывает неудачные попытки.
+// Вызывается под u.mu.
+func (u *stepUp) check(accountID int64, factor Factor, code string, now time.Time) error {
+	if until, ok := u.locked[accountID]; ok {
+		if now.Before(until) {
+			return ErrConfirmationLocked
+		}
+		delete(u.locked, accountID)
+	}
+
+	valid := false
+	switch factor {
+	case FactorPIN:
+		pin, ok := u.pins[accountID]
+		if !ok {
+			return ErrSecondFactorRequired
+		}
+		valid = subtle.ConstantTimeCompare(hashPIN(pin.salt, code), pin.hash) == 1
+	case FactorTOTP:
+		key, ok := u.totp[accountID]
+		if !ok {
+			return ErrSecondFactorRequired
+		}
+		valid = u.checkTOTP(accountID, key, code, now)
+	}
+
+	if valid {
+		delete(u.failures, accountID)
+		return nil
+	}
+
+	if u.failures == nil {
+		u.failures = make(map[int64]int)
+	}
+	u.failures[accountID]++
+	if u.failures[accountID] < maxFailures {
+		return ErrInvalidCode
+	}
+
+	delete(u.failures, accountID)
+	if u.locked == nil {
+		u.locked = make(map[int64]time.Time)
+	}
+	u.locked[accountID] = now.Add(lockoutPeriod)
+	return ErrConfirmationLocked
+}
+
+// checkTOTP принимает код текущего, предыдущего или следующего шага, но
+// каждый шаг — только один раз, чтобы подсмотренный код нельзя было повторить.
+func (u *stepUp) checkTOTP(accountID int64, key []byte, code string, now time.Time) bool {
+	step := now.Unix() / totpStep
+	for _, candidate := range []int64{step - 1, step, step + 1} {
+		if candidate <= u.lastStep[accountID] {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totp(key, candidate)), []byte(code)) == 1 {
+			if u.lastStep == nil {
+				u.lastStep = make(map[int64]int64)
+			}
+			u.lastStep[accountID] = candidate
+			return true
+		}
+	}
+	return false
+}
+
+func (u *stepUp) removeExpired(now time.Time) {
+	for id, challenge := range u.challenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(u.challenges, id)
+		}
+	}
+}
+
+// TOTPCode возвращает код TOTP (RFC 6238: HMAC-SHA1, шаг 30 секунд, 6 цифр)
+// для секрета secret в base32 в момент t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return totp(key, t.Unix()/totpStep), nil
+}
+
+func totp(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+func hashPIN(salt []byte, pin string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	_, _ = mac.Write([]byte(pin))
+	sum := mac.Sum(nil)
+	for i := 1; i < pinRounds; i++ {
+		mac.Reset()
+		_, _ = mac.Write(sum)
+		sum = mac.Sum(sum[:0])
+	}
+	return sum
+}
+
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
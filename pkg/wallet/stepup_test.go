@@ -0,0 +1,336 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	//тестовый вектор RFC 6238 для SHA1 (последние шесть цифр 94287082)
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	code, err := TOTPCode(secret, time.Unix(59, 0))
+	if err != nil {
+		t.Errorf("TOTPCode(): error = %v", err)
+		return
+	}
+	if code != "287082" {
+		t.Errorf("TOTPCode(): code = %v, want %v", code, "287082")
+	}
+}
+
+func TestService_Pay_confirmPIN(t *testing.T) {
+	//создаем Сервис с порогом подтверждения
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetConfirmationThreshold(1_000)
+
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	if err != ErrSecondFactorRequired {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrSecondFactorRequired)
+	}
+	err = s.SetPIN(account.ID, "", "1234")
+	if err != nil {
+		t.Errorf("SetPIN(): error = %v", err)
+		return
+	}
+
+	//платёж ниже порога проходит сразу
+	_, err = s.Pay(account.ID, 500, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	var required *ConfirmationRequiredError
+	if !errors.As(err, &required) || !errors.Is(err, ErrConfirmationRequired) {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrConfirmationRequired)
+		return
+	}
+	if required.Challenge.Factor != FactorPIN {
+		t.Errorf("Pay(): factor = %v, want %v", required.Challenge.Factor, FactorPIN)
+	}
+	if balance := s.balance(t, account.ID); balance != 9_500 {
+		t.Errorf("Pay(): balance = %v, want %v", balance, 9_500)
+	}
+
+	_, err = s.ConfirmPayment(required.Challenge.ID, "0000")
+	if err != ErrInvalidCode {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrInvalidCode)
+	}
+	payment, err := s.ConfirmPayment(required.Challenge.ID, "1234")
+	if err != nil {
+		t.Errorf("ConfirmPayment(): error = %v", err)
+		return
+	}
+	if balance := s.balance(t, account.ID); payment.Amount != 2_000 || balance != 7_500 {
+		t.Errorf("ConfirmPayment(): payment = %v, balance = %v", payment, balance)
+	}
+	_, err = s.ConfirmPayment(required.Challenge.ID, "1234")
+	if err != ErrChallengeNotFound {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrChallengeNotFound)
+	}
+
+	//пакет не может содержать платёж выше порога
+	results, _ := s.PayBatch([]PaymentRequest{{AccountID: account.ID, Amount: 2_000, Category: "auto"}}, BatchBestEffort)
+	if results[0].Err != ErrConfirmationRequired {
+		t.Errorf("PayBatch(): error = %v, want %v", results[0].Err, ErrConfirmationRequired)
+	}
+}
+
+func TestService_Transfer_confirm(t *testing.T) {
+	//создаем Сервис с порогом подтверждения
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetConfirmationThreshold(1_000)
+
+	err = s.Transfer(first.ID, second.ID, 2_000)
+	if err != ErrSecondFactorRequired {
+		t.Errorf("Transfer(): error = %v, want %v", err, ErrSecondFactorRequired)
+	}
+	err = s.SetPIN(first.ID, "", "1234")
+	if err != nil {
+		t.Errorf("SetPIN(): error = %v", err)
+		return
+	}
+
+	err = s.Transfer(first.ID, second.ID, 2_000)
+	var required *ConfirmationRequiredError
+	if !errors.As(err, &required) || required.Challenge.To != second.ID {
+		t.Errorf("Transfer(): error = %v, want %v", err, ErrConfirmationRequired)
+		return
+	}
+	if balance := s.balance(t, first.ID); balance != 10_000 {
+		t.Errorf("Transfer(): balance = %v, want %v", balance, 10_000)
+	}
+
+	//перевод не подтверждается как платёж
+	_, err = s.ConfirmPayment(required.Challenge.ID, "1234")
+	if err != ErrChallengeNotFound {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrChallengeNotFound)
+	}
+	err = s.ConfirmTransfer(required.Challenge.ID, "1234")
+	if err != nil {
+		t.Errorf("ConfirmTransfer(): error = %v", err)
+		return
+	}
+	if s.balance(t, first.ID) != 8_000 || s.balance(t, second.ID) != 2_000 {
+		t.Errorf("ConfirmTransfer(): balances = %v, %v", s.balance(t, first.ID), s.balance(t, second.ID))
+	}
+	err = s.ConfirmTransfer(required.Challenge.ID, "1234")
+	if err != ErrChallengeNotFound {
+		t.Errorf("ConfirmTransfer(): error = %v, want %v", err, ErrChallengeNotFound)
+	}
+
+	//и платёж не подтверждается как перевод
+	_, err = s.Pay(first.ID, 2_000, "auto")
+	if !errors.As(err, &required) {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrConfirmationRequired)
+		return
+	}
+	err = s.ConfirmTransfer(required.Challenge.ID, "1234")
+	if err != ErrChallengeNotFound {
+		t.Errorf("ConfirmTransfer(): error = %v, want %v", err, ErrChallengeNotFound)
+	}
+}
+
+func TestService_ConfirmPayment_lockout(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetConfirmationThreshold(1_000)
+	_ = s.SetPIN(account.ID, "", "1234")
+
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	var required *ConfirmationRequiredError
+	if !errors.As(err, &required) {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	for i := 1; i < maxFailures; i++ {
+		_, err = s.ConfirmPayment(required.Challenge.ID, "0000")
+		if err != ErrInvalidCode {
+			t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrInvalidCode)
+		}
+	}
+	_, err = s.ConfirmPayment(required.Challenge.ID, "0000")
+	if err != ErrConfirmationLocked {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrConfirmationLocked)
+	}
+
+	//во время блокировки не проходит даже верный код, а смена PIN невозможна
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	if !errors.As(err, &required) {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.ConfirmPayment(required.Challenge.ID, "1234")
+	if err != ErrConfirmationLocked {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrConfirmationLocked)
+	}
+	err = s.SetPIN(account.ID, "1234", "4321")
+	if err != ErrConfirmationLocked {
+		t.Errorf("SetPIN(): error = %v, want %v", err, ErrConfirmationLocked)
+	}
+	if balance := s.balance(t, account.ID); balance != 10_000 {
+		t.Errorf("ConfirmPayment(): balance = %v, want %v", balance, 10_000)
+	}
+}
+
+func TestService_ConfirmPayment_TOTP(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetConfirmationThreshold(1_000)
+	secret, err := s.EnableTOTP(account.ID)
+	if err != nil {
+		t.Errorf("EnableTOTP(): error = %v", err)
+		return
+	}
+
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	var required *ConfirmationRequiredError
+	if !errors.As(err, &required) || required.Challenge.Factor != FactorTOTP {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	code, _ := TOTPCode(secret, time.Now())
+	_, err = s.ConfirmPayment(required.Challenge.ID, code)
+	if err != nil {
+		t.Errorf("ConfirmPayment(): error = %v", err)
+		return
+	}
+
+	//тот же код второй раз не принимается
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	if !errors.As(err, &required) {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.ConfirmPayment(required.Challenge.ID, code)
+	if err != ErrInvalidCode {
+		t.Errorf("ConfirmPayment(): error = %v, want %v", err, ErrInvalidCode)
+	}
+	if balance := s.balance(t, account.ID); balance != 8_000 {
+		t.Errorf("ConfirmPayment(): balance = %v, want %v", balance, 8_000)
+	}
+}
+
+func TestService_SetPIN_persisted(t *testing.T) {
+	//PIN и TOTP переживают выгрузку и восстановление из журнала
+	s := newTestService()
+	var journal bytes.Buffer
+	store := NewEventStore(&journal)
+	s.SetJournal(store)
+	first, err := s.addAccountWithBalance("+992000000001", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992000000002", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoint := s.Checkpoint()
+	err = s.SetPIN(first.ID, "", "1234")
+	if err != nil {
+		t.Errorf("SetPIN(): error = %v", err)
+		return
+	}
+	if s.Checkpoint() == checkpoint {
+		t.Errorf("SetPIN(): checkpoint = %v, must change", s.Checkpoint())
+	}
+	secret, err := s.EnableTOTP(second.ID)
+	if err != nil {
+		t.Errorf("EnableTOTP(): error = %v", err)
+		return
+	}
+
+	fsys := &MemFS{}
+	err = s.ExportFS(fsys)
+	if err != nil {
+		t.Errorf("ExportFS(): error = %v", err)
+		return
+	}
+	imported := newTestService()
+	err = imported.ImportFS(fsys)
+	if err != nil {
+		t.Errorf("ImportFS(): error = %v", err)
+		return
+	}
+	loaded, err := LoadEventStore(bytes.NewReader(journal.Bytes()), nil)
+	if err != nil {
+		t.Errorf("LoadEventStore(): error = %v", err)
+		return
+	}
+	rebuilt, err := loaded.Rebuild(loaded.Len())
+	if err != nil {
+		t.Errorf("Rebuild(): error = %v", err)
+		return
+	}
+
+	for _, svc := range []*Service{imported.Service, rebuilt} {
+		if !svc.HasSecondFactor(first.ID) || !svc.HasSecondFactor(second.ID) {
+			t.Errorf("HasSecondFactor(): factors lost")
+			continue
+		}
+		//сменить PIN можно только с текущим PIN
+		err = svc.SetPIN(first.ID, "", "5678")
+		if err != ErrInvalidCode {
+			t.Errorf("SetPIN(): error = %v, want %v", err, ErrInvalidCode)
+		}
+		err = svc.SetPIN(first.ID, "1234", "5678")
+		if err != nil {
+			t.Errorf("SetPIN(): error = %v", err)
+		}
+		code, err := TOTPCode(secret, time.Now())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = svc.DisableTOTP(second.ID, code)
+		if err != nil {
+			t.Errorf("DisableTOTP(): error = %v", err)
+		}
+		if svc.HasSecondFactor(second.ID) {
+			t.Errorf("DisableTOTP(): totp still enabled")
+		}
+	}
+
+	//выключенный TOTP не возвращается после повторной выгрузки
+	err = imported.ExportFS(fsys)
+	if err != nil {
+		t.Errorf("ExportFS(): error = %v", err)
+		return
+	}
+	reimported := newTestService()
+	err = reimported.ImportFS(fsys)
+	if err != nil {
+		t.Errorf("ImportFS(): error = %v", err)
+		return
+	}
+	if !reimported.HasSecondFactor(first.ID) || reimported.HasSecondFactor(second.ID) {
+		t.Errorf("ImportFS(): factors = %v, %v, want true, false", reimported.HasSecondFactor(first.ID), reimported.HasSecondFactor(second.ID))
+	}
+}
@@ -0,0 +1,315 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bahrom656/wallet/pkg/types"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"github.com/google/uuid"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+var ErrClosed = errors.New("dispatcher closed")
+
+// EventType — вид события в доставке.
+type EventType string
+
+const (
+	EventPaymentCreated  EventType = "payment.created"
+	EventPaymentRejected EventType = "payment.rejected"
+	EventPaymentRepeated EventType = "payment.repeated"
+)
+
+// Заголовки запроса с доставкой.
+const (
+	HeaderEvent     = "X-Wallet-Event"
+	HeaderDelivery  = "X-Wallet-Delivery"
+	HeaderSignature = "X-Wallet-Signature"
+)
+
+// Subscription — подписка на события платежей. Пустой Events означает все события.
+type Subscription struct {
+	ID     string
+	URL    string
+	Secret string
+	Events []EventType
+}
+
+func (s Subscription) matches(eventType EventType) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery — доставка одного события по одной подписке.
+type Delivery struct {
+	ID             string
+	SubscriptionID string
+	URL            string
+	Event          EventType
+	Body           []byte
+	Attempts       int
+	Err            error
+}
+
+// Dispatcher рассылает события платежей подписчикам: тело запроса — JSON,
+// подписанный HMAC-SHA256 секретом подписки. Неудачная доставка повторяется
+// с экспоненциально растущей паузой, после MaxAttempts попыток попадает
+// в список недоставленных (DeadLetters).
+type Dispatcher struct {
+	// MaxAttempts — число попыток доставки, Backoff — пауза после первой
+	// неудачи, далее она удваивается, но не превышает MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu            sync.Mutex
+	subscriptions []Subscription
+	deadLetters   []Delivery
+	closed        bool
+}
+
+// NewDispatcher создаёт рассыльщик, отправляющий запросы через client.
+// Если client равен nil, используется клиент с таймаутом 10 секунд.
+func NewDispatcher(client *http.Client) *Dispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		client:      client,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Subscribe добавляет подписку на события events (все, если events не указаны)
+// и возвращает её.
+func (d *Dispatcher) Subscribe(url string, secret string, events ...EventType) Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscription := Subscription{ID: uuid.New().String(), URL: url, Secret: secret, Events: events}
+	d.subscriptions = append(d.subscriptions, subscription)
+	return subscription
+}
+
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, subscription := range d.subscriptions {
+		if subscription.ID == id {
+			d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrSubscriptionNotFound
+}
+
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Subscription(nil), d.subscriptions...)
+}
+
+// DeadLetters возвращает доставки, исчерпавшие все попытки.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Delivery(nil), d.deadLetters...)
+}
+
+// Handle ставит событие платежа в доставку всем подходящим подпискам и сразу
+// возвращается, остальные события пропускает. Подходит как синхронный подписчик
+// шины wallet.Service.Events().
+func (d *Dispatcher) Handle(event wallet.Event) {
+	eventType, payment, ok := paymentEvent(event)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	for _, subscription := range d.subscriptions {
+		if !subscription.matches(eventType) {
+			continue
+		}
+		delivery := Delivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: subscription.ID,
+			URL:            subscription.URL,
+			Event:          eventType,
+		}
+		delivery.Body = encode(delivery.ID, eventType, payment)
+
+		d.wg.Add(1)
+		go d.deliver(delivery, subscription.Secret)
+	}
+}
+
+// Wait ждёт завершения всех начатых доставок.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Close прекращает приём событий, прерывает ожидающие повтора доставки
+// (они попадают в DeadLetters) и ждёт завершения текущих.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(delivery Delivery, secret string) {
+	defer d.wg.Done()
+
+	backoff := d.Backoff
+	for {
+		delivery.Attempts++
+		delivery.Err = d.send(delivery, secret)
+		if delivery.Err == nil {
+			return
+		}
+		if delivery.Attempts >= d.MaxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			delivery.Err = ErrClosed
+			d.deadLetter(delivery)
+			return
+		}
+		backoff *= 2
+		if backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+	d.deadLetter(delivery)
+}
+
+func (d *Dispatcher) deadLetter(delivery Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deadLetters = append(d.deadLetters, delivery)
+}
+
+func (d *Dispatcher) send(delivery Delivery, secret string) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, Sign(secret, delivery.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", delivery.URL, resp.Status)
+	}
+	return nil
+}
+
+// Sign возвращает значение заголовка X-Wallet-Signature для тела body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify проверяет подпись тела body на стороне получателя.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Payment — платёж в теле запроса.
+type Payment struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+	Status    types.PaymentStatus   `json:"status"`
+	Version   int64                 `json:"version"`
+}
+
+// Message — тело запроса с доставкой.
+type Message struct {
+	ID      string    `json:"id"`
+	Type    EventType `json:"type"`
+	Payment Payment   `json:"payment"`
+}
+
+func paymentEvent(event wallet.Event) (EventType, types.Payment, bool) {
+	switch e := event.(type) {
+	case wallet.PaymentCreated:
+		if e.RepeatOf != "" {
+			return EventPaymentRepeated, e.Payment, true
+		}
+		return EventPaymentCreated, e.Payment, true
+	case wallet.PaymentRejected:
+		return EventPaymentRejected, e.Payment, true
+	}
+	return "", types.Payment{}, false
+}
+
+func encode(id string, eventType EventType, payment types.Payment) []byte {
+	body, _ := json.Marshal(Message{
+		ID:   id,
+		Type: eventType,
+		Payment: Payment{
+			ID:        payment.ID,
+			AccountID: payment.AccountID,
+			Amount:    payment.Amount,
+			Category:  payment.Category,
+			Status:    payment.Status,
+			Version:   payment.Version,
+		},
+	})
+	return body
+}
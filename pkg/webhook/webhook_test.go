@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/bahrom656/wallet/pkg/wallet"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	messages []Message
+	calls    int
+}
+
+func (r *receiver) handler(t *testing.T, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !Verify(secret, body, req.Header.Get(HeaderSignature)) {
+			t.Errorf("wrong signature %q", req.Header.Get(HeaderSignature))
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.calls++
+		if r.calls <= r.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var message Message
+		err = json.Unmarshal(body, &message)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Header.Get(HeaderEvent) != string(message.Type) {
+			t.Errorf("header %v = %q, want %q", HeaderEvent, req.Header.Get(HeaderEvent), message.Type)
+		}
+		r.messages = append(r.messages, message)
+	}
+}
+
+func newTestDispatcher() *Dispatcher {
+	d := NewDispatcher(nil)
+	d.Backoff = time.Millisecond
+	d.MaxBackoff = 4 * time.Millisecond
+	d.MaxAttempts = 3
+	return d
+}
+
+func TestDispatcher_serviceEvents(t *testing.T) {
+	rcv := &receiver{}
+	server := httptest.NewServer(rcv.handler(t, "secret"))
+	defer server.Close()
+
+	d := newTestDispatcher()
+	defer d.Close()
+	d.Subscribe(server.URL, "secret", EventPaymentRejected, EventPaymentRepeated)
+
+	//создаем Сервис и совершаем платежи
+	svc := &wallet.Service{}
+	svc.Events().Subscribe(d.Handle)
+	account, err := svc.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Deposit(account.ID, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := svc.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+	_, err = svc.Repeat(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	//создание платежа не подходит под фильтр подписки
+	if len(rcv.messages) != 2 {
+		t.Errorf("Handle(): delivered %v, want rejected and repeated", rcv.messages)
+		return
+	}
+	if rcv.messages[0].Type != EventPaymentRejected || rcv.messages[0].Payment.ID != payment.ID {
+		t.Errorf("Handle(): first message = %+v", rcv.messages[0])
+	}
+	if rcv.messages[1].Type != EventPaymentRepeated || rcv.messages[1].Payment.Amount != 1_000 {
+		t.Errorf("Handle(): second message = %+v", rcv.messages[1])
+	}
+}
+
+func TestDispatcher_retry(t *testing.T) {
+	rcv := &receiver{failures: 2}
+	server := httptest.NewServer(rcv.handler(t, "secret"))
+	defer server.Close()
+
+	d := newTestDispatcher()
+	defer d.Close()
+	d.Subscribe(server.URL, "secret")
+	d.Handle(wallet.PaymentCreated{})
+	d.Wait()
+
+	if rcv.calls != 3 || len(rcv.messages) != 1 {
+		t.Errorf("Handle(): calls = %v, delivered = %v, want 3 and 1", rcv.calls, len(rcv.messages))
+	}
+	if len(d.DeadLetters()) != 0 {
+		t.Errorf("DeadLetters() = %v, want empty", d.DeadLetters())
+	}
+}
+
+func TestDispatcher_deadLetters(t *testing.T) {
+	rcv := &receiver{failures: 100}
+	server := httptest.NewServer(rcv.handler(t, "secret"))
+	defer server.Close()
+
+	d := newTestDispatcher()
+	defer d.Close()
+	subscription := d.Subscribe(server.URL, "secret")
+	d.Handle(wallet.PaymentCreated{})
+	d.Wait()
+
+	deadLetters := d.DeadLetters()
+	if len(deadLetters) != 1 {
+		t.Errorf("DeadLetters() = %v, want one delivery", deadLetters)
+		return
+	}
+	if deadLetters[0].Attempts != 3 || deadLetters[0].Err == nil || deadLetters[0].SubscriptionID != subscription.ID {
+		t.Errorf("DeadLetters() = %+v", deadLetters[0])
+	}
+}
+
+func TestDispatcher_Unsubscribe(t *testing.T) {
+	d := newTestDispatcher()
+	defer d.Close()
+	subscription := d.Subscribe("http://localhost", "secret")
+
+	err := d.Unsubscribe(subscription.ID)
+	if err != nil {
+		t.Errorf("Unsubscribe(): error = %v", err)
+	}
+	err = d.Unsubscribe(subscription.ID)
+	if err != ErrSubscriptionNotFound {
+		t.Errorf("Unsubscribe(): must return ErrSubscriptionNotFound, returned = %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	if !Verify("secret", body, Sign("secret", body)) {
+		t.Error("Verify(): valid signature rejected")
+	}
+	if Verify("other", body, Sign("secret", body)) {
+		t.Error("Verify(): signature with wrong secret accepted")
+	}
+}